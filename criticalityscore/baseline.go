@@ -0,0 +1,326 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidBaseline error = fmt.Errorf("invalid baseline file")
+)
+
+// Change statuses reported by CompareToBaseline.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// ScoreChange describes how a repository's score changed since a baseline run.
+type ScoreChange struct {
+	Name         string
+	URL          string
+	Status       string
+	ScoreDelta   float64
+	MetricDeltas map[string]float64
+	Regression   bool
+}
+
+// LoadBaseline reads previously saved scores from a file. Files ending in .csv are
+// read as csv output of PrintScore; anything else is read as json, either a single
+// array or one score object per line (JSONL).
+func LoadBaseline(path string) ([]Score, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return parseBaselineCSV(b)
+	}
+	return parseBaselineJSON(b)
+}
+
+// CompareToBaseline matches current scores to baseline scores by repository URL and
+// returns the change for each repository. A repository whose criticality score dropped
+// by at least regressionThreshold is flagged as a regression.
+func CompareToBaseline(baseline, current []Score, regressionThreshold float64) []ScoreChange {
+
+	previous := make(map[string]Score)
+	for _, s := range baseline {
+		previous[strings.ToLower(s.URL)] = s
+	}
+
+	seen := make(map[string]bool)
+	var changes []ScoreChange
+
+	for _, s := range current {
+		key := strings.ToLower(s.URL)
+		seen[key] = true
+
+		p, ok := previous[key]
+		if !ok {
+			changes = append(changes, ScoreChange{Name: s.Name, URL: s.URL, Status: ChangeAdded})
+			continue
+		}
+
+		delta := s.CriticalityScore - p.CriticalityScore
+		changes = append(changes, ScoreChange{
+			Name:         s.Name,
+			URL:          s.URL,
+			Status:       ChangeChanged,
+			ScoreDelta:   delta,
+			MetricDeltas: metricDeltas(p, s),
+			Regression:   -delta >= regressionThreshold,
+		})
+	}
+
+	for _, s := range baseline {
+		if seen[strings.ToLower(s.URL)] {
+			continue
+		}
+		changes = append(changes, ScoreChange{Name: s.Name, URL: s.URL, Status: ChangeRemoved})
+	}
+
+	return changes
+}
+
+// ScoredBaseline returns the baseline scores of the repositories in current, so that
+// comparing a partial run, e.g. of a single repository, doesn't report the rest of the
+// baseline as removed.
+func ScoredBaseline(baseline, current []Score) []Score {
+
+	scored := make(map[string]bool)
+	for _, s := range current {
+		scored[strings.ToLower(s.URL)] = true
+	}
+
+	var filtered []Score
+	for _, s := range baseline {
+		if scored[strings.ToLower(s.URL)] {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// PrintChanges outputs the changes since a baseline run.
+func PrintChanges(changes []ScoreChange) {
+	WriteChanges(os.Stdout, changes)
+}
+
+// WriteChanges writes the changes since a baseline run to w.
+func WriteChanges(w io.Writer, changes []ScoreChange) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s: %s %s\n", c.Status, c.Name, c.URL)
+		if c.Status != ChangeChanged {
+			continue
+		}
+
+		line := fmt.Sprintf("  criticality_score: %+0.5f", c.ScoreDelta)
+		if c.Regression {
+			line += " (regression)"
+		}
+		fmt.Fprintln(w, line)
+
+		names := make([]string, 0, len(c.MetricDeltas))
+		for name := range c.MetricDeltas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %+0.1f\n", name, c.MetricDeltas[name])
+		}
+	}
+}
+
+// metricDeltas returns the non-zero differences of the numeric metric fields. The
+// lookback windows are settings rather than metrics, and are left out.
+func metricDeltas(previous, current Score) map[string]float64 {
+
+	deltas := make(map[string]float64)
+
+	pv := reflect.ValueOf(previous)
+	cv := reflect.ValueOf(current)
+	typeOfScore := pv.Type()
+
+	for i := 0; i < pv.NumField(); i++ {
+		name := jsonName(typeOfScore.Field(i))
+		if name == "criticality_score" || name == "issue_lookback_days" || name == "release_lookback_days" {
+			continue
+		}
+		p, ok := numericValue(pv.Field(i))
+		if !ok {
+			continue
+		}
+		c, _ := numericValue(cv.Field(i))
+		if c != p {
+			deltas[name] = c - p
+		}
+	}
+
+	return deltas
+}
+
+func numericValue(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int:
+		return float64(v.Int()), true
+	case reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func parseBaselineJSON(b []byte) ([]Score, error) {
+
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	if b[0] == '[' {
+		var scores []Score
+		if err := json.Unmarshal(b, &scores); err != nil {
+			return nil, fmt.Errorf("%w : %s", ErrInvalidBaseline, err.Error())
+		}
+		return scores, nil
+	}
+
+	var scores []Score
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var s Score
+		err := dec.Decode(&s)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w : %s", ErrInvalidBaseline, err.Error())
+		}
+		scores = append(scores, s)
+	}
+	return scores, nil
+}
+
+// parseBaselineCSV reads the two-column key/value csv layout, where each
+// "name" row starts a new score, or the csv-table layout with a header row.
+func parseBaselineCSV(b []byte) ([]Score, error) {
+
+	r := csv.NewReader(bytes.NewReader(b))
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", ErrInvalidBaseline, err.Error())
+	}
+
+	if len(records) > 0 && len(records[0]) > 2 {
+		return parseBaselineCSVTable(records)
+	}
+
+	var scores []Score
+	var s *Score
+	for _, record := range records {
+		if record[0] == "name" {
+			scores = append(scores, Score{})
+			s = &scores[len(scores)-1]
+		}
+		if s == nil {
+			return nil, fmt.Errorf("%w : expected a name row first", ErrInvalidBaseline)
+		}
+		if err := setField(s, record[0], record[1]); err != nil {
+			return nil, fmt.Errorf("%w : %s", ErrInvalidBaseline, err.Error())
+		}
+	}
+	return scores, nil
+}
+
+// parseBaselineCSVTable reads the csv-table layout, a header row of field names
+// followed by a row per score. Empty cells are fields the score didn't have.
+func parseBaselineCSVTable(records [][]string) ([]Score, error) {
+
+	header := records[0]
+	var scores []Score
+	for _, record := range records[1:] {
+		var s Score
+		for i, value := range record {
+			if value == "" || value == "null" {
+				continue
+			}
+			if err := setField(&s, header[i], value); err != nil {
+				return nil, fmt.Errorf("%w : %s", ErrInvalidBaseline, err.Error())
+			}
+		}
+		scores = append(scores, s)
+	}
+	return scores, nil
+}
+
+// setField sets the Score field with the given json name from its string value.
+// Unknown names are ignored.
+func setField(s *Score, name, value string) error {
+
+	v := reflect.ValueOf(s).Elem()
+	typeOfScore := v.Type()
+
+	for i := 0; i < v.NumField(); i++ {
+		if jsonName(typeOfScore.Field(i)) != name {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Ptr {
+			f.Set(reflect.New(f.Type().Elem()))
+			f = f.Elem()
+		}
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s should be type bool", name)
+			}
+			f.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s should be type int", name)
+			}
+			f.SetInt(int64(n))
+		case reflect.Float64:
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s should be type float64", name)
+			}
+			f.SetFloat(n)
+		}
+		return nil
+	}
+	return nil
+}
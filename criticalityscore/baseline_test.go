@@ -0,0 +1,86 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompareToBaseline(t *testing.T) {
+
+	baseline := []Score{
+		{Name: "a", URL: "https://github.com/o/a", CriticalityScore: 0.5},
+		{Name: "b", URL: "https://github.com/o/b", CriticalityScore: 0.4},
+		{Name: "c", URL: "https://github.com/o/c", CriticalityScore: 0.3},
+	}
+	current := []Score{
+		{Name: "a", URL: "https://github.com/O/A", CriticalityScore: 0.4},
+		{Name: "b", URL: "https://github.com/o/b", CriticalityScore: 0.42},
+		{Name: "d", URL: "https://github.com/o/d", CriticalityScore: 0.1},
+	}
+
+	changes := CompareToBaseline(baseline, current, 0.05)
+
+	want := map[string]struct {
+		status     string
+		regression bool
+	}{
+		"a": {ChangeChanged, true},
+		"b": {ChangeChanged, false},
+		"c": {ChangeRemoved, false},
+		"d": {ChangeAdded, false},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("len(changes) = %d, want %d", len(changes), len(want))
+	}
+	for _, c := range changes {
+		w, ok := want[c.Name]
+		if !ok {
+			t.Errorf("unexpected change for %s", c.Name)
+			continue
+		}
+		if c.Status != w.status || c.Regression != w.regression {
+			t.Errorf("%s: status %s, regression %v, want %s, %v", c.Name, c.Status, c.Regression, w.status, w.regression)
+		}
+	}
+}
+
+func TestScoredBaseline(t *testing.T) {
+
+	baseline := []Score{
+		{Name: "a", URL: "https://github.com/o/a"},
+		{Name: "b", URL: "https://github.com/o/b"},
+	}
+	current := []Score{{Name: "a", URL: "https://github.com/O/a"}}
+
+	changes := CompareToBaseline(ScoredBaseline(baseline, current), current, 0.05)
+	if len(changes) != 1 || changes[0].Name != "a" || changes[0].Status != ChangeChanged {
+		t.Errorf("changes = %+v, want only a as changed", changes)
+	}
+}
+
+func TestWriteErrorJSON(t *testing.T) {
+
+	var b bytes.Buffer
+	WriteError(&b, errors.New("boom"), "json")
+
+	if !strings.Contains(b.String(), `"error": "boom"`) {
+		t.Errorf("WriteError() = %s, want a json object with the error", b.String())
+	}
+}
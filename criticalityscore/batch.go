@@ -0,0 +1,347 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownSortKey error = fmt.Errorf("unknown sort key")
+)
+
+// ScoreResult is the outcome of scoring one repository of a batch. Index is the
+// position of the repository in the batch.
+type ScoreResult struct {
+	Index   int
+	RepoURL string
+	Score   Score
+	Err     error
+}
+
+// ScoreRepositories scores repositories with up to config.Concurrency of them at a time,
+// and calls emit with the result of each in the order of repoURLs. A result is emitted
+// as soon as the results of all earlier repositories have been, so results that complete
+// out of order are buffered until then. emit is never called concurrently. All GitHub
+// repositories are loaded from a single data source, with one client and rate limit check.
+//
+// If ctx is done, repositories that haven't started are skipped and a repository scored
+// past the deadline is left out, since its metrics are incomplete. Results after it
+// aren't emitted either, to keep the order, and ErrTimedOut is returned.
+func ScoreRepositories(ctx context.Context, repoURLs []string, token string, config ScoreConfig, params []string, emit func(ScoreResult)) error {
+
+	concurrency := config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	source, sourceErr := newBatchDataSource(ctx, token, config)
+
+	// batchResult marks results that were completed after ctx was done.
+	type batchResult struct {
+		ScoreResult
+		incomplete bool
+	}
+
+	indexes := make(chan int)
+	results := make(chan batchResult)
+
+	go func() {
+		defer close(indexes)
+		for i := range repoURLs {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result := ScoreResult{Index: i, RepoURL: repoURLs[i]}
+				ghr, err := loadBatchRepository(ctx, repoURLs[i], source, sourceErr, config)
+				if err == nil {
+					result.Score, err = RepositoryStats(ghr, params)
+				}
+				result.Err = err
+				results <- batchResult{result, ctx.Err() != nil}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]batchResult)
+	next := 0
+	stopped := false
+
+	for result := range results {
+		if stopped {
+			continue
+		}
+		pending[result.Index] = result
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if r.incomplete {
+				stopped = true
+				break
+			}
+			emit(r.ScoreResult)
+			next++
+		}
+	}
+
+	if next < len(repoURLs) {
+		return fmt.Errorf("%w with %d of %d repos scored", ErrTimedOut, next, len(repoURLs))
+	}
+	return nil
+}
+
+// newBatchDataSource returns the GitHub data source the repositories of a batch are
+// loaded from, so they share a client, rate limiter and cache, after waiting for the rate
+// limit once.
+func newBatchDataSource(ctx context.Context, token string, config ScoreConfig) (RepoDataSource, error) {
+
+	source, err := NewGitHubDataSource(ctx, token, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pauseIfGitHubRateLimitExceeded(source, ctx); err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// loadBatchRepository loads a repository of a batch from source, or returns sourceErr if
+// the source couldn't be created. GitLab projects don't need the source.
+func loadBatchRepository(ctx context.Context, repoURL string, source RepoDataSource, sourceErr error, config ScoreConfig) (GitHubRepository, error) {
+	if sourceErr != nil && parseGitLabURL(repoURL) == "" {
+		return GitHubRepository{}, sourceErr
+	}
+	return LoadRepositoryFromDataSource(ctx, repoURL, source, config)
+}
+
+// LoadRepoList reads a file of repository urls, one per line. Blank lines and lines
+// starting with # are skipped.
+func LoadRepoList(path string) ([]string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var repoURLs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repoURLs = append(repoURLs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return repoURLs, nil
+}
+
+// SortScores sorts scores stably by a sort key: a json field name of Score, or score for
+// the criticality score, with an optional :asc or :desc suffix. Numbers sort in
+// descending order and text in ascending order by default, and scores with the field
+// listed as unavailable sort last either way.
+func SortScores(scores []Score, key string) error {
+
+	field, desc, err := parseSortKey(key)
+	if err != nil {
+		return err
+	}
+	name := jsonName(reflect.TypeOf(Score{}).Field(field))
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		ui, uj := contains(scores[i].Unavailable, name), contains(scores[j].Unavailable, name)
+		if ui || uj {
+			return !ui && uj
+		}
+		vi, vj := reflect.ValueOf(scores[i]).Field(field), reflect.ValueOf(scores[j]).Field(field)
+		if desc {
+			return lessValue(vj, vi)
+		}
+		return lessValue(vi, vj)
+	})
+
+	return nil
+}
+
+// FilterScores returns the scores with a criticality score of at least min, in the
+// configured Scale of each score, keeping their order.
+func FilterScores(scores []Score, min float64) []Score {
+	var filtered []Score
+	for _, score := range scores {
+		if score.CriticalityScore >= min {
+			filtered = append(filtered, score)
+		}
+	}
+	return filtered
+}
+
+// ValidateSortKey returns ErrUnknownSortKey if key isn't a sort key of SortScores.
+func ValidateSortKey(key string) error {
+	_, _, err := parseSortKey(key)
+	return err
+}
+
+// parseSortKey returns the index of the Score field of a sort key, and whether it sorts
+// in descending order.
+func parseSortKey(key string) (int, bool, error) {
+
+	name, direction := key, ""
+	if i := strings.LastIndex(key, ":"); i >= 0 {
+		name, direction = key[:i], key[i+1:]
+	}
+	if name == "score" {
+		name = "criticality_score"
+	}
+
+	typeOfScore := reflect.TypeOf(Score{})
+	for i := 0; i < typeOfScore.NumField(); i++ {
+		f := typeOfScore.Field(i)
+		if jsonName(f) != name || !sortable(f.Type) {
+			continue
+		}
+		switch direction {
+		case "":
+			return i, f.Type.Kind() != reflect.String, nil
+		case "asc":
+			return i, false, nil
+		case "desc":
+			return i, true, nil
+		}
+		return 0, false, fmt.Errorf("%w : %s, the direction must be asc or desc", ErrUnknownSortKey, key)
+	}
+
+	keys := []string{"score"}
+	for i := 0; i < typeOfScore.NumField(); i++ {
+		if f := typeOfScore.Field(i); jsonName(f) != "-" && sortable(f.Type) {
+			keys = append(keys, jsonName(f))
+		}
+	}
+	return 0, false, fmt.Errorf("%w : %s, available keys are [%s]", ErrUnknownSortKey, key, strings.Join(keys, ", "))
+}
+
+// sortable reports whether SortScores can sort by a field of type t.
+func sortable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// lessValue reports whether a sorts before b, for values of a sortable type.
+func lessValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Float64:
+		return a.Float() < b.Float()
+	}
+	return a.Int() < b.Int()
+}
+
+// PrintCSVTable outputs scores as a single csv table, in the csv-table format.
+func PrintCSVTable(scores []Score) {
+	WriteScores(os.Stdout, scores, "csv-table")
+}
+
+// CSVTableWriter writes scores as the rows of a csv table with a column per output field
+// of Score, so that the scores of a batch, even when written as they're scored, form a
+// single table. A field a score doesn't have is an empty cell.
+type CSVTableWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVTableWriter returns a CSVTableWriter that writes to w.
+func NewCSVTableWriter(w io.Writer) *CSVTableWriter {
+	return &CSVTableWriter{w: csv.NewWriter(w)}
+}
+
+// Write writes the row of a score, after the header row if it's the first score.
+func (t *CSVTableWriter) Write(score Score) error {
+
+	v := reflect.ValueOf(score)
+	typeOfScore := v.Type()
+
+	var columns []int
+	for i := 0; i < typeOfScore.NumField(); i++ {
+		if outputField(typeOfScore.Field(i), score.OutputFields) {
+			columns = append(columns, i)
+		}
+	}
+
+	if !t.header {
+		header := make([]string, len(columns))
+		for j, i := range columns {
+			header[j] = jsonName(typeOfScore.Field(i))
+		}
+		if err := t.w.Write(header); err != nil {
+			return err
+		}
+		t.header = true
+	}
+
+	record := make([]string, len(columns))
+	for j, i := range columns {
+		f, ok := fieldValue(typeOfScore.Field(i), v.Field(i), score.Unavailable, score.OutputFields)
+		if !ok {
+			continue
+		}
+		record[j] = csvValue(jsonName(typeOfScore.Field(i)), f)
+	}
+	if err := t.w.Write(record); err != nil {
+		return err
+	}
+
+	t.w.Flush()
+	return t.w.Error()
+}
@@ -0,0 +1,154 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newBatchServer starts a GitHub API serving the repositories o/<name>, each responding
+// after delay(name) if delay isn't nil, except for o/missing, and returns a config with only created_since
+// enabled that requests it, and the number of requests by path.
+func newBatchServer(t *testing.T, delay func(name string) time.Duration) (ScoreConfig, func(path string) int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+
+	srv := httptest.NewServer(http.StripPrefix("/api/v3", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/rate_limit":
+			fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":5000}}}`)
+		case r.URL.Path == "/repos/o/missing":
+			http.NotFound(w, r)
+		case strings.HasPrefix(r.URL.Path, "/repos/o/"):
+			name := strings.TrimPrefix(r.URL.Path, "/repos/o/")
+			if delay != nil {
+				time.Sleep(delay(name))
+			}
+			fmt.Fprintf(w, `{"name":%q,"full_name":"o/%s","html_url":"https://github.com/o/%s","owner":{"login":"o"},"created_at":"2020-01-01T00:00:00Z"}`, name, name, name)
+		default:
+			http.NotFound(w, r)
+		}
+	})))
+	t.Cleanup(srv.Close)
+
+	config := DefaultScoreConfig()
+	config.GitHubBaseURL = srv.URL + "/api/v3/"
+	config.RequestsPerHour = 0
+	config.Metrics = []string{"created_since"}
+
+	return config, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[path]
+	}
+}
+
+func TestScoreRepositoriesInputOrder(t *testing.T) {
+
+	// Each repository responds later than the next, so they complete in reverse order.
+	config, requests := newBatchServer(t, func(name string) time.Duration {
+		var i int
+		fmt.Sscanf(name, "r%d", &i)
+		return time.Duration(8-i) * 20 * time.Millisecond
+	})
+	config.Concurrency = 8
+
+	var repoURLs []string
+	for i := 0; i < 8; i++ {
+		repoURLs = append(repoURLs, fmt.Sprintf("https://%s/o/r%d", config.GitHubHost(), i))
+	}
+
+	var names []string
+	err := ScoreRepositories(context.Background(), repoURLs, "tok", config, nil, func(result ScoreResult) {
+		if result.Err != nil {
+			t.Errorf("result %d error = %v", result.Index, result.Err)
+			return
+		}
+		if result.RepoURL != repoURLs[result.Index] {
+			t.Errorf("result %d RepoURL = %s, want %s", result.Index, result.RepoURL, repoURLs[result.Index])
+		}
+		// The first repository is the slowest, so all others were buffered until it
+		// completed.
+		if result.Index == 0 && requests("/repos/o/r7") != 1 {
+			t.Error("result 0 emitted before the last repository completed, want the repositories scored concurrently")
+		}
+		names = append(names, result.Score.Name)
+	})
+	if err != nil {
+		t.Fatalf("ScoreRepositories() error = %v", err)
+	}
+
+	want := "r0,r1,r2,r3,r4,r5,r6,r7"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("emitted %s, want %s in input order", got, want)
+	}
+}
+
+func TestScoreRepositoriesSharedClient(t *testing.T) {
+
+	config, requests := newBatchServer(t, nil)
+	config.Concurrency = 3
+
+	var repoURLs []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		repoURLs = append(repoURLs, "https://"+config.GitHubHost()+"/o/"+name)
+	}
+	err := ScoreRepositories(context.Background(), repoURLs, "tok", config, nil, func(result ScoreResult) {
+		if result.Err != nil {
+			t.Errorf("result %d error = %v", result.Index, result.Err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("ScoreRepositories() error = %v", err)
+	}
+
+	// The rate limit is checked once for the batch rather than for each repository.
+	if n := requests("/rate_limit"); n != 1 {
+		t.Errorf("rate limit requested %d times, want 1", n)
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if n := requests("/repos/o/" + name); n != 1 {
+			t.Errorf("repo %s requested %d times, want 1", name, n)
+		}
+	}
+}
+
+func TestScoreRepositoriesTimedOut(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := ScoreRepositories(ctx, []string{"https://github.com/o/a", "https://github.com/o/b"}, "tok", DefaultScoreConfig(), nil, func(result ScoreResult) {
+		t.Errorf("emitted result %d after ctx was done", result.Index)
+	})
+	if !errors.Is(err, ErrTimedOut) {
+		t.Errorf("ScoreRepositories() error = %v, want ErrTimedOut", err)
+	}
+}
@@ -0,0 +1,214 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+var (
+	ErrCacheDirNotProvided error = fmt.Errorf("please provide a cache directory")
+)
+
+// cachingTransport is an http.RoundTripper that caches successful GET responses on disk.
+type cachingTransport struct {
+	base  http.RoundTripper
+	dir   string
+	ttl   time.Duration
+	token string
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	// The rate limit has to be live to be useful.
+	if req.Method != http.MethodGet || strings.HasSuffix(req.URL.Path, "/rate_limit") {
+		return t.base.RoundTrip(req)
+	}
+
+	path := filepath.Join(t.dir, t.key(req))
+
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < t.ttl {
+		if resp, err := readCachedResponse(path, req); err == nil {
+			return resp, nil
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	b, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return resp, nil
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		log.Println(err.Error())
+		return resp, nil
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		log.Println(err.Error())
+	}
+	return resp, nil
+}
+
+// readCachedResponse reads a response saved by httputil.DumpResponse.
+func readCachedResponse(path string, req *http.Request) (*http.Response, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// key identifies a request by its token, url and accepted media type.
+func (t *cachingTransport) key(req *http.Request) string {
+	h := sha256.Sum256([]byte(t.token + "\n" + req.URL.String() + "\n" + req.Header.Get("Accept")))
+	return hex.EncodeToString(h[:])
+}
+
+// WarmCache scores every repository owned by an org or user, filling the disk cache
+// in config.CacheDir without outputting any scores. Repositories that fail to score
+// are logged and skipped. It returns the number of repositories cached, and
+// ErrTimedOut if ctx is done before all repositories are cached.
+func WarmCache(ctx context.Context, owner, token string, config ScoreConfig) (int, error) {
+
+	if config.CacheDir == "" {
+		return 0, ErrCacheDirNotProvided
+	}
+
+	client, err := newGitHubClient(ctx, token, config)
+	if err != nil {
+		return 0, err
+	}
+
+	repos, err := listOwnerRepositories(ctx, client, owner)
+	if ctx.Err() != nil {
+		return 0, fmt.Errorf("%w before listing the repos of %s", ErrTimedOut, owner)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	repoURLs := make([]string, len(repos))
+	for i, r := range repos {
+		repoURLs[i] = r.GetHTMLURL()
+	}
+
+	return WarmCacheRepos(ctx, repoURLs, token, config)
+}
+
+// WarmCacheRepos scores each repository url, e.g. from a file read with LoadRepoList,
+// filling the disk cache like WarmCache. All metrics are collected, including the opt-in
+// metrics and those left out of config.Metrics, so any later score within the cache TTL
+// is served from the cache. Dependents are still skipped with NoDependents or NoSearch.
+func WarmCacheRepos(ctx context.Context, repoURLs []string, token string, config ScoreConfig) (int, error) {
+
+	if config.CacheDir == "" {
+		return 0, ErrCacheDirNotProvided
+	}
+
+	config = config.withAllMetrics()
+
+	source, sourceErr := newBatchDataSource(ctx, token, config)
+
+	count := 0
+	for i, repoURL := range repoURLs {
+		ghr, err := loadBatchRepository(ctx, repoURL, source, sourceErr, config)
+		if err == nil {
+			_, err = RepositoryStats(ghr, nil)
+		}
+		if ctx.Err() != nil {
+			return count, fmt.Errorf("%w with %d of %d repos cached", ErrTimedOut, i, len(repoURLs))
+		}
+		if err != nil {
+			log.Printf("%s: %s\n", repoURL, err.Error())
+			continue
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// listOwnerRepositories returns all repositories of an org, or of a user if owner
+// isn't an org.
+func listOwnerRepositories(ctx context.Context, client *github.Client, owner string) ([]*github.Repository, error) {
+
+	var allRepos []*github.Repository
+
+	orgOpts := &github.RepositoryListByOrgOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		repos, resp, err := client.Repositories.ListByOrg(ctx, owner, orgOpts)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				break
+			}
+			return nil, err
+		}
+		allRepos = append(allRepos, repos...)
+		if resp.NextPage == 0 {
+			return allRepos, nil
+		}
+		orgOpts.Page = resp.NextPage
+	}
+
+	userOpts := &github.RepositoryListOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		repos, resp, err := client.Repositories.List(ctx, owner, userOpts)
+		if err != nil {
+			return nil, err
+		}
+		allRepos = append(allRepos, repos...)
+		if resp.NextPage == 0 {
+			return allRepos, nil
+		}
+		userOpts.Page = resp.NextPage
+	}
+}
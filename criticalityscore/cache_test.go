@@ -0,0 +1,137 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWarmCacheReposRequiresCacheDir(t *testing.T) {
+	_, err := WarmCacheRepos(context.Background(), []string{"https://github.com/o/r"}, "tok", DefaultScoreConfig())
+	if !errors.Is(err, ErrCacheDirNotProvided) {
+		t.Errorf("WarmCacheRepos() error = %v, want ErrCacheDirNotProvided", err)
+	}
+}
+
+func TestWarmCacheReposSkipsFailures(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "criticalityscore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	config := DefaultScoreConfig()
+	config.CacheDir = dir
+
+	// Without a token every repository fails to load, and is logged and skipped.
+	count, err := WarmCacheRepos(context.Background(), []string{"https://github.com/o/a", "https://github.com/o/b"}, "", config)
+	if err != nil || count != 0 {
+		t.Errorf("WarmCacheRepos() = %d, %v, want 0 repos cached", count, err)
+	}
+	if !strings.Contains(logs.String(), "https://github.com/o/b: ") {
+		t.Errorf("logs = %q, want the failed repos logged", logs.String())
+	}
+}
+
+func TestWarmCacheReposServesRerunFromCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "criticalityscore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var requests []string
+
+	srv := httptest.NewServer(http.StripPrefix("/api/v3", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/rate_limit":
+			fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":5000}}}`)
+		case "/repos/o/r":
+			fmt.Fprint(w, `{"name":"r","full_name":"o/r","html_url":"https://github.com/o/r","owner":{"login":"o"},"has_issues":true,"created_at":"2020-01-01T00:00:00Z"}`)
+		case "/repos/o/r/stats/participation":
+			fmt.Fprint(w, `{"all":[]}`)
+		case "/repos/o/r/contents/":
+			fmt.Fprint(w, `[{"type":"file","name":"SECURITY.md","path":"SECURITY.md"}]`)
+		case "/repos/o/r/contents/.github/FUNDING.yml":
+			fmt.Fprint(w, `{"type":"file","name":"FUNDING.yml","path":".github/FUNDING.yml"}`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	})))
+	defer srv.Close()
+
+	config := DefaultScoreConfig()
+	config.GitHubBaseURL = srv.URL + "/api/v3/"
+	config.CacheDir = dir
+	config.NoDependents = true
+	config.RequestsPerHour = 0
+	config.UserLookupDelay = 0
+	config.Metrics = []string{"created_since"}
+
+	run := func() []string {
+		mu.Lock()
+		requests = nil
+		mu.Unlock()
+
+		count, err := WarmCacheRepos(context.Background(), []string{"https://" + config.GitHubHost() + "/o/r"}, "tok", config)
+		if err != nil || count != 1 {
+			t.Fatalf("WarmCacheRepos() = %d, %v, want 1 repo cached", count, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+
+	// The first run collects every metric, including the opt-in metrics and those left
+	// out of config.Metrics.
+	first := make(map[string]bool)
+	for _, path := range run() {
+		first[path] = true
+	}
+	for _, path := range []string{"/repos/o/r/pulls", "/repos/o/r/stargazers", "/repos/o/r/contributors"} {
+		if !first[path] {
+			t.Errorf("first run didn't request %s", path)
+		}
+	}
+
+	// The second run is served from the cache, except for the rate limit check.
+	if second := run(); len(second) != 1 || second[0] != "/rate_limit" {
+		t.Errorf("second run requested %v, want only /rate_limit", second)
+	}
+}
@@ -0,0 +1,96 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/google/go-github/github"
+)
+
+// HasCI returns whether the repository has CI configured, from the presence of any of
+// these markers:
+//
+//   - a .yml or .yaml workflow in .github/workflows (GitHub Actions)
+//   - .travis.yml (Travis CI)
+//   - .circleci/config.yml (CircleCI)
+//   - Jenkinsfile (Jenkins)
+//
+// File names are matched exactly, as the CI services do. An empty repository has no CI.
+func (ghr GitHubRepository) HasCI() (bool, error) {
+
+	if ghr.gitlab != nil {
+		return false, ErrMetricUnavailable
+	}
+
+	root, found, err := ghr.listDir("")
+	if err != nil || !found {
+		return false, err
+	}
+
+	dirs := make(map[string]bool)
+	for _, file := range root {
+		switch file.GetType() {
+		case "file":
+			if file.GetName() == ".travis.yml" || file.GetName() == "Jenkinsfile" {
+				return true, nil
+			}
+		case "dir":
+			dirs[file.GetName()] = true
+		}
+	}
+
+	if dirs[".circleci"] {
+		files, _, err := ghr.listDir(".circleci")
+		if err != nil {
+			return false, err
+		}
+		for _, file := range files {
+			if file.GetType() == "file" && file.GetName() == "config.yml" {
+				return true, nil
+			}
+		}
+	}
+
+	if dirs[".github"] {
+		workflows, _, err := ghr.listDir(".github/workflows")
+		if err != nil {
+			return false, err
+		}
+		for _, file := range workflows {
+			ext := path.Ext(file.GetName())
+			if file.GetType() == "file" && (ext == ".yml" || ext == ".yaml") {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// listDir returns the contents of a directory of the repository, and false if it
+// doesn't exist.
+func (ghr GitHubRepository) listDir(dir string) ([]*github.RepositoryContent, bool, error) {
+	_, contents, _, err := ghr.source.GetContents(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), dir, nil)
+	if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, classifyForbidden(err)
+	}
+	return contents, true, nil
+}
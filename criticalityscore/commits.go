@@ -0,0 +1,184 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// commitCache holds the commits of a repository that several metric methods use, so
+// they're fetched once rather than by each method. It's shared by the copies of a
+// GitHubRepository, and safe for the metric methods RepositoryStats calls concurrently.
+type commitCache struct {
+	recentOnce sync.Once
+	recent     []*github.RepositoryCommit
+	recentErr  error
+
+	pathAuthorsOnce sync.Once
+	pathAuthors     map[string]int
+	pathAuthorsErr  error
+
+	authorsOnce sync.Once
+	authors     map[string]int
+	authorsErr  error
+}
+
+// recentCommits returns the most recent default-branch commits, at most
+// RecentCommitSampleSize, newest first. An empty repository has no commits.
+func (ghr GitHubRepository) recentCommits() ([]*github.RepositoryCommit, error) {
+	if ghr.gitlab != nil {
+		return nil, ErrMetricUnavailable
+	}
+	if ghr.commits == nil {
+		return ghr.listRecentCommits()
+	}
+	c := ghr.commits
+	c.recentOnce.Do(func() {
+		c.recent, c.recentErr = ghr.listRecentCommits()
+	})
+	return c.recent, c.recentErr
+}
+
+func (ghr GitHubRepository) listRecentCommits() ([]*github.RepositoryCommit, error) {
+
+	opts := &github.CommitsListOptions{
+		ListOptions: github.ListOptions{
+			PerPage: RecentCommitSampleSize,
+		},
+	}
+
+	commits, resp, err := ghr.source.ListCommits(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	if err != nil {
+		// GitHub responds with 409 Conflict for an empty repository.
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			return nil, nil
+		}
+		return nil, classifyForbidden(err)
+	}
+
+	return commits, nil
+}
+
+// cachedPathCommitAuthors returns pathCommitAuthors, fetched once for the contributor
+// and org counts.
+func (ghr GitHubRepository) cachedPathCommitAuthors() (map[string]int, error) {
+	if ghr.commits == nil {
+		return ghr.pathCommitAuthors()
+	}
+	c := ghr.commits
+	c.pathAuthorsOnce.Do(func() {
+		c.pathAuthors, c.pathAuthorsErr = ghr.pathCommitAuthors()
+	})
+	return c.pathAuthors, c.pathAuthorsErr
+}
+
+// RecentCommitAuthors returns the number of distinct authors of the commits within
+// AuthorLookbackDays, inspecting at most AuthorPageLimit pages of commits. If a path is
+// configured, only commits touching that path are considered.
+func (ghr GitHubRepository) RecentCommitAuthors() (int, error) {
+	authors, err := ghr.recentAuthorCommits()
+	return len(authors), err
+}
+
+// BusFactor returns the fewest authors who made half of the commits within
+// AuthorLookbackDays, from the same commits as RecentCommitAuthors. It's 0 if there are
+// no such commits.
+func (ghr GitHubRepository) BusFactor() (int, error) {
+
+	authors, err := ghr.recentAuthorCommits()
+	if err != nil {
+		return 0, err
+	}
+
+	counts := make([]int, 0, len(authors))
+	total := 0
+	for _, count := range authors {
+		counts = append(counts, count)
+		total += count
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+
+	busFactor, commits := 0, 0
+	for _, count := range counts {
+		if commits*2 >= total {
+			break
+		}
+		commits += count
+		busFactor++
+	}
+
+	return busFactor, nil
+}
+
+// recentAuthorCommits returns the number of commits within AuthorLookbackDays by each
+// author, keyed by login or, for authors without a GitHub account, by email. It's
+// fetched once for RecentCommitAuthors and BusFactor.
+func (ghr GitHubRepository) recentAuthorCommits() (map[string]int, error) {
+	if ghr.gitlab != nil {
+		return nil, ErrMetricUnavailable
+	}
+	if ghr.commits == nil {
+		return ghr.listRecentAuthorCommits()
+	}
+	c := ghr.commits
+	c.authorsOnce.Do(func() {
+		c.authors, c.authorsErr = ghr.listRecentAuthorCommits()
+	})
+	return c.authors, c.authorsErr
+}
+
+func (ghr GitHubRepository) listRecentAuthorCommits() (map[string]int, error) {
+
+	opts := &github.CommitsListOptions{
+		Path:  ghr.config.Path,
+		Since: lookbackTime(AuthorLookbackDays),
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+
+	authors := make(map[string]int)
+	for page := 0; page < AuthorPageLimit; page++ {
+		commits, resp, err := ghr.source.ListCommits(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+		if err != nil {
+			// GitHub responds with 409 Conflict for an empty repository.
+			if resp != nil && resp.StatusCode == http.StatusConflict {
+				return authors, nil
+			}
+			return nil, classifyForbidden(err)
+		}
+		for _, commit := range commits {
+			author := commit.GetAuthor().GetLogin()
+			if author == "" {
+				author = strings.ToLower(commit.GetCommit().GetAuthor().GetEmail())
+			}
+			if author != "" {
+				authors[author]++
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return authors, nil
+}
@@ -0,0 +1,501 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrProfileNotFound error = fmt.Errorf("config profile not found")
+	ErrInvalidConfig   error = fmt.Errorf("invalid config file")
+)
+
+// Commit frequency sources.
+const (
+	CommitFrequencyCommitActivity = "commit_activity"
+	CommitFrequencyParticipation  = "participation"
+)
+
+// Dependents methods.
+const (
+	DependentsDependencyGraph = "dependency_graph"
+	DependentsCommitSearch    = "commit_search"
+)
+
+// Score scales.
+const (
+	ScaleRaw     = "raw"
+	ScaleUnit    = "unit"
+	ScalePercent = "percent"
+)
+
+// Scored on time formats.
+const (
+	TimeFormatRFC3339  = "rfc3339"
+	TimeFormatUnixDate = "unixdate"
+)
+
+// MetricParams holds a value, e.g. the weight or max threshold, for each metric.
+// Boolean metrics always have a max threshold of 1.
+type MetricParams struct {
+	CreatedSince        float64 `json:"created_since"`
+	UpdatedSince        float64 `json:"updated_since"`
+	ContributorCount    float64 `json:"contributor_count"`
+	OrgCount            float64 `json:"org_count"`
+	CommitFrequency     float64 `json:"commit_frequency"`
+	RecentReleases      float64 `json:"recent_releases_count"`
+	ClosedIssues        float64 `json:"closed_issues_count"`
+	UpdatedIssues       float64 `json:"updated_issues_count"`
+	CommentFrequency    float64 `json:"comment_frequency"`
+	DependentsCount     float64 `json:"dependents_count"`
+	ChurnFilesCount     float64 `json:"churn_files_count"`
+	StarGrowth          float64 `json:"star_growth"`
+	SignedCommits       float64 `json:"signed_commits_ratio"`
+	ReleaseContributors float64 `json:"release_contributors_count"`
+	CommitAuthors       float64 `json:"recent_commit_authors_count"`
+	BusFactor           float64 `json:"bus_factor"`
+	OpenPullRequests    float64 `json:"open_pull_requests_count"`
+	MergedPullRequests  float64 `json:"merged_pull_requests_count"`
+	PullRequestMerges   float64 `json:"pull_request_merge_rate"`
+	StarsCount          float64 `json:"stars_count"`
+	ForksCount          float64 `json:"forks_count"`
+	WatchersCount       float64 `json:"watchers_count"`
+	WikiEnabled         float64 `json:"wiki_enabled,omitempty"`
+	DiscussionsEnabled  float64 `json:"discussions_enabled,omitempty"`
+	Funded              float64 `json:"funded,omitempty"`
+	Licensed            float64 `json:"licensed,omitempty"`
+	SecurityPolicy      float64 `json:"security_policy,omitempty"`
+	CI                  float64 `json:"ci_configured,omitempty"`
+}
+
+// ScoreConfig holds the runtime settings used when loading and scoring a repository.
+type ScoreConfig struct {
+	// Weights are the weights of the metrics in the criticality score.
+	Weights MetricParams `json:"weights"`
+	// Thresholds are the max thresholds of the metrics.
+	Thresholds MetricParams `json:"thresholds"`
+	// Caps clamp the normalized value (between 0 and 1) of each metric before it's
+	// weighted, so a maxed-out metric can't dominate the score. Zero means uncapped.
+	Caps MetricParams `json:"caps"`
+	// IssueLookbackDays is the window, in days, for the updated and closed issue
+	// counts and the comment frequency.
+	IssueLookbackDays float64 `json:"issue_lookback_days"`
+	// ReleaseLookbackDays is the window, in days, for the recent releases count.
+	ReleaseLookbackDays float64 `json:"release_lookback_days"`
+	// RequestsPerHour is the target GitHub API request rate shared by all metric calls.
+	// A value <= 0 disables rate limiting.
+	RequestsPerHour float64 `json:"requests_per_hour"`
+	// RequestBurst is the number of requests allowed to go out back-to-back before
+	// the request rate is smoothed.
+	RequestBurst int `json:"request_burst"`
+	// GitHubBaseURL is the API url of a GitHub Enterprise Server, e.g.
+	// https://github.mycorp.com/api/v3/. Repository urls are then on its host rather than
+	// github.com. Empty uses github.com.
+	GitHubBaseURL string `json:"github_base_url"`
+	// GitHubUploadURL is the upload API url of a GitHub Enterprise Server. It defaults to
+	// /api/uploads/ on the host of GitHubBaseURL.
+	GitHubUploadURL string `json:"github_upload_url"`
+	// GitLabToken is the GitLab personal access token used for gitlab.com projects.
+	GitLabToken string `json:"-"`
+	// AllowUnauthenticated allows loading a repository without a token. Scores from
+	// unauthenticated runs are marked as not reliable.
+	AllowUnauthenticated bool `json:"allow_unauthenticated"`
+	// CommunitySignals enables detection of the wiki and GitHub Discussions,
+	// which are then reported and scored as small community-health signals.
+	CommunitySignals bool `json:"community_signals"`
+	// NoSearch skips every metric that uses GitHub search (currently the dependents
+	// count), so scoring only uses the core API quota. Skipped metrics are marked as
+	// unavailable and left out of the score.
+	NoSearch bool `json:"no_search"`
+	// NoDependents skips the dependents count, the slowest metric, e.g. for quick
+	// interactive scoring. It's marked as unavailable and left out of the score.
+	NoDependents bool `json:"no_dependents"`
+	// GraphQL collects the updated since, commit frequency, issue and recent release
+	// metrics with a single GitHub GraphQL API query, falling back to the REST API for
+	// any of them it has no data for. It requires a token.
+	GraphQL bool `json:"graphql"`
+	// Path scopes the commit frequency, updated since and contributor metrics to
+	// commits touching a subdirectory, e.g. a single package in a monorepo.
+	Path string `json:"path"`
+	// IncludeAnonymous counts anonymous contributors (commit authors without a GitHub
+	// account) in both the contributor count and the top contributors used for the org count.
+	IncludeAnonymous bool `json:"include_anonymous"`
+	// Concurrency is the number of repositories scored at a time in a batch, such as
+	// the dependencies of a lockfile.
+	Concurrency int `json:"concurrency"`
+	// DependentsAttempts is the maximum number of dependents search requests made
+	// when the search is rate limited or fails with a server error. At least one
+	// request is always made.
+	DependentsAttempts int `json:"dependents_attempts"`
+	// DependentsBackoff is the delay before the first dependents search retry,
+	// doubled after every further attempt.
+	DependentsBackoff time.Duration `json:"dependents_backoff"`
+	// OrgNameNormalizer maps the company of a top contributor to an org name for the
+	// org count, e.g. to map subsidiaries to their parent company. Contributors with
+	// the same org name count as one org. The built-in normalization is used if nil.
+	OrgNameNormalizer func(company string) string `json:"-"`
+	// UserLookupDelay is the delay before each user lookup of the top contributors for
+	// the org count, to avoid GitHub's secondary rate limit.
+	UserLookupDelay time.Duration `json:"user_lookup_delay"`
+	// UserLookupAttempts is the maximum number of attempts of a user lookup when
+	// GitHub's secondary rate limit is hit. Every user is looked up at least once.
+	UserLookupAttempts int `json:"user_lookup_attempts"`
+	// DependentsSource counts the dependents of a repository. If nil, DependentsMethod
+	// selects a built-in source.
+	DependentsSource DependentsSource `json:"-"`
+	// DependentsMethod is how dependents are counted without a DependentsSource: with
+	// DependentsCommitSearch, the default, the commit search results, or with
+	// DependentsDependencyGraph, the dependency graph's count of dependent repositories.
+	// The dependents count is unavailable for a repository the method has no count for.
+	DependentsMethod string `json:"dependents_method"`
+	// StatsAttempts is the maximum number of times the commit statistics are requested
+	// while GitHub is still calculating them.
+	StatsAttempts int `json:"stats_attempts"`
+	// StatsRetryDelay is the delay between commit statistics requests.
+	StatsRetryDelay time.Duration `json:"stats_retry_delay"`
+	// Model combines the metrics into the criticality score. DefaultModel is used if nil.
+	Model ScoringModel `json:"-"`
+	// FailUnder is the criticality score, in the configured Scale, below which a score is
+	// marked as below threshold. Zero disables it.
+	FailUnder float64 `json:"fail_under"`
+	// ShortCircuit collects the cheap metrics first and skips the expensive ones if the
+	// repository can't reach FailUnder even with their best values. The score is then
+	// the highest achievable score.
+	ShortCircuit bool `json:"short_circuit"`
+	// Scale is the scale of the criticality score: ScaleRaw as returned by the model,
+	// ScaleUnit rescaled from the model's range to 0-1, or ScalePercent as 0-100.
+	Scale string `json:"scale"`
+	// ScoreMin and ScoreMax clamp a raw criticality score, which weights and additional
+	// params can push out of 0-1, with a warning. Clamping is disabled if ScoreMax isn't
+	// above ScoreMin.
+	ScoreMin float64 `json:"score_min"`
+	ScoreMax float64 `json:"score_max"`
+	// Tiers adds the Tier of the criticality score to the output.
+	Tiers bool `json:"tiers"`
+	// TierCutoffs are the lowest scores, on a 0-1 scale, of the tiers above low, see
+	// Tier. DefaultTierCutoffs are used if they aren't valid.
+	TierCutoffs []float64 `json:"tier_cutoffs"`
+	// ParamScores adds each metric's ParamScore to the output, for tuning the model.
+	ParamScores bool `json:"param_scores"`
+	// Explain adds the contribution of each metric to the output, with its value,
+	// weight, max threshold and ParamScore, to show why a repository scored as it did.
+	Explain bool `json:"explain"`
+	// Churn enables the churn metric, the number of distinct files changed by
+	// recent commits.
+	Churn bool `json:"churn"`
+	// StarGrowth enables the star growth metric, the number of new stars per 30 days.
+	StarGrowth bool `json:"star_growth"`
+	// ReleaseContributors enables the release contributors metric, the number of distinct
+	// commit authors between the two most recent releases.
+	ReleaseContributors bool `json:"release_contributors"`
+	// CommitAuthors enables the commit authors metrics, the number of distinct authors
+	// of recent commits and the bus factor.
+	CommitAuthors bool `json:"commit_authors"`
+	// PullRequests enables the pull request metrics, the number of open pull requests,
+	// and the number merged and the merge rate of those closed within the issue lookback
+	// days.
+	PullRequests bool `json:"pull_requests"`
+	// Funding enables detection of a FUNDING.yml, reported as the funding platforms and
+	// scored as the funded signal.
+	Funding bool `json:"funding"`
+	// License enables the licensed signal, whether GitHub recognized the license of the
+	// repository.
+	License bool `json:"license"`
+	// SecurityPolicy enables the security policy signal, whether the repository has a
+	// SECURITY.md.
+	SecurityPolicy bool `json:"security_policy"`
+	// CI enables the CI signal, whether the repository has CI configured.
+	CI bool `json:"ci"`
+	// SignedCommits enables the signed commits metric, the fraction of recent commits
+	// with a verified signature.
+	SignedCommits bool `json:"signed_commits"`
+	// Scorecard adds the OpenSSF Scorecard of the repository from deps.dev to the output,
+	// if there is one. It's not part of the criticality score.
+	Scorecard bool `json:"scorecard"`
+	// ScorecardChecks are the Scorecard checks included in the output.
+	ScorecardChecks []string `json:"scorecard_checks"`
+	// Metrics limits the metrics collected and scored to these names, to save API
+	// quota. The other metrics are marked as unavailable and left out of the score, and
+	// optional metrics still have to be enabled. All metrics are collected if it's empty.
+	Metrics []string `json:"metrics"`
+	// Fields limits the output of a score to these json field names, see
+	// Score.OutputFields. All fields are output if it's empty.
+	Fields []string `json:"fields"`
+	// TimeFormat is the format of the scored on timestamp, either TimeFormatRFC3339 or
+	// TimeFormatUnixDate. RFC 3339 is used if it's empty.
+	TimeFormat string `json:"time_format"`
+	// LocalTime gives the scored on timestamp in local time instead of UTC.
+	LocalTime bool `json:"local_time"`
+	// IncludeRepository adds metadata of the scored github.Repository to the output.
+	IncludeRepository bool `json:"include_repository"`
+	// CommitFrequencySource is the statistics endpoint tried first for the commit
+	// frequency, either CommitFrequencyCommitActivity or CommitFrequencyParticipation.
+	CommitFrequencySource string `json:"commit_frequency_source"`
+	// CacheDir is a directory where GitHub responses are cached, so later runs
+	// can score from the cache. An empty value disables caching.
+	CacheDir string `json:"cache_dir"`
+	// CacheTTL is how long cached responses are used before being fetched again.
+	CacheTTL time.Duration `json:"cache_ttl"`
+}
+
+// skipsDependents returns whether the dependents count is skipped, either on its own
+// or with the other search-based metrics.
+func (config ScoreConfig) skipsDependents() bool {
+	return config.NoSearch || config.NoDependents
+}
+
+// scoredOn formats t as the scored on timestamp of a score, in the configured
+// TimeFormat and in UTC unless LocalTime is set.
+func (config ScoreConfig) scoredOn(t time.Time) string {
+	if config.LocalTime {
+		t = t.Local()
+	} else {
+		t = t.UTC()
+	}
+	if config.TimeFormat == TimeFormatUnixDate {
+		return t.Format(time.UnixDate)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// GitHubHost returns the host of the configured GitHub, i.e. github.com or the host of a
+// GitHub Enterprise Server.
+func (config ScoreConfig) GitHubHost() string {
+	if config.GitHubBaseURL == "" {
+		return DefaultGitHubHost
+	}
+	u, err := url.Parse(config.GitHubBaseURL)
+	if err != nil || u.Host == "" {
+		return DefaultGitHubHost
+	}
+	return u.Host
+}
+
+// configDuration is a duration in a config file, written as a duration string such
+// as "5s" or "250ms". Bare numbers are rejected rather than read as nanoseconds.
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\", got %s", string(b))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(v)
+	return nil
+}
+
+// UnmarshalJSON decodes a config file over config, leaving the settings the file
+// doesn't specify unchanged. The duration settings are read as duration strings.
+func (config *ScoreConfig) UnmarshalJSON(b []byte) error {
+
+	type plain ScoreConfig
+	aux := struct {
+		*plain
+		DependentsBackoff *configDuration `json:"dependents_backoff"`
+		UserLookupDelay   *configDuration `json:"user_lookup_delay"`
+		StatsRetryDelay   *configDuration `json:"stats_retry_delay"`
+		CacheTTL          *configDuration `json:"cache_ttl"`
+	}{plain: (*plain)(config)}
+
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if aux.DependentsBackoff != nil {
+		config.DependentsBackoff = time.Duration(*aux.DependentsBackoff)
+	}
+	if aux.UserLookupDelay != nil {
+		config.UserLookupDelay = time.Duration(*aux.UserLookupDelay)
+	}
+	if aux.StatsRetryDelay != nil {
+		config.StatsRetryDelay = time.Duration(*aux.StatsRetryDelay)
+	}
+	if aux.CacheTTL != nil {
+		config.CacheTTL = time.Duration(*aux.CacheTTL)
+	}
+	return nil
+}
+
+// DefaultScoreConfig returns a ScoreConfig populated with the default settings.
+func DefaultScoreConfig() ScoreConfig {
+	return ScoreConfig{
+		Weights: MetricParams{
+			CreatedSince:        CreatedSinceWeight,
+			UpdatedSince:        UpdatedSinceWeight,
+			ContributorCount:    ContributorCountWeight,
+			OrgCount:            OrgCountWeight,
+			CommitFrequency:     CommitFrequencyWeight,
+			RecentReleases:      RecentReleasesWeight,
+			ClosedIssues:        ClosedIssuesWeight,
+			UpdatedIssues:       UpdatedIssuesWeight,
+			CommentFrequency:    CommentFrequencyWeight,
+			DependentsCount:     DependentsCountWeight,
+			ChurnFilesCount:     ChurnWeight,
+			StarGrowth:          StarGrowthWeight,
+			SignedCommits:       SignedCommitsWeight,
+			ReleaseContributors: ReleaseContributorsWeight,
+			CommitAuthors:       CommitAuthorsWeight,
+			BusFactor:           BusFactorWeight,
+			OpenPullRequests:    OpenPullRequestsWeight,
+			MergedPullRequests:  MergedPullRequestsWeight,
+			PullRequestMerges:   PullRequestMergeRateWeight,
+			StarsCount:          StarsCountWeight,
+			ForksCount:          ForksCountWeight,
+			WatchersCount:       WatchersCountWeight,
+			WikiEnabled:         WikiEnabledWeight,
+			DiscussionsEnabled:  DiscussionsEnabledWeight,
+			Funded:              FundedWeight,
+			Licensed:            LicensedWeight,
+			SecurityPolicy:      SecurityPolicyWeight,
+			CI:                  CIWeight,
+		},
+		Thresholds: MetricParams{
+			CreatedSince:        CreatedSinceThreshold,
+			UpdatedSince:        UpdatedSinceThreshold,
+			ContributorCount:    ContributorCountThreshold,
+			OrgCount:            OrgCountThreshold,
+			CommitFrequency:     CommitFrequencyThreshold,
+			RecentReleases:      RecentReleasesThreshold,
+			ClosedIssues:        ClosedIssuesThreshold,
+			UpdatedIssues:       UpdatedIssuesThreshold,
+			CommentFrequency:    CommentFrequencyThreshold,
+			DependentsCount:     DependentsCountThreshold,
+			ChurnFilesCount:     ChurnThreshold,
+			StarGrowth:          StarGrowthThreshold,
+			SignedCommits:       SignedCommitsThreshold,
+			ReleaseContributors: ReleaseContributorsThreshold,
+			CommitAuthors:       CommitAuthorsThreshold,
+			BusFactor:           BusFactorThreshold,
+			OpenPullRequests:    OpenPullRequestsThreshold,
+			MergedPullRequests:  MergedPullRequestsThreshold,
+			PullRequestMerges:   PullRequestMergeRateThreshold,
+			StarsCount:          StarsCountThreshold,
+			ForksCount:          ForksCountThreshold,
+			WatchersCount:       WatchersCountThreshold,
+		},
+		IssueLookbackDays:     IssueLookbackDays,
+		ReleaseLookbackDays:   ReleaseLookbackDays,
+		RequestsPerHour:       AuthenticatedRequestsPerHour,
+		RequestBurst:          DefaultRequestBurst,
+		IncludeAnonymous:      true,
+		Concurrency:           DefaultConcurrency,
+		ScoreMin:              DefaultScoreMin,
+		ScoreMax:              DefaultScoreMax,
+		TierCutoffs:           append([]float64(nil), DefaultTierCutoffs...),
+		DependentsAttempts:    DefaultDependentsAttempts,
+		DependentsBackoff:     DefaultDependentsBackoff,
+		DependentsMethod:      DependentsCommitSearch,
+		StatsAttempts:         DefaultStatsAttempts,
+		UserLookupDelay:       DefaultUserLookupDelay,
+		UserLookupAttempts:    DefaultUserLookupAttempts,
+		StatsRetryDelay:       DefaultStatsRetryDelay,
+		CommitFrequencySource: CommitFrequencyCommitActivity,
+		CacheTTL:              DefaultCacheTTL,
+		ScorecardChecks:       append([]string(nil), DefaultScorecardChecks...),
+		Scale:                 ScaleRaw,
+		TimeFormat:            TimeFormatRFC3339,
+	}
+}
+
+// BuiltinProfiles returns the built-in config profiles, which adjust the default
+// weights for different kinds of repositories.
+func BuiltinProfiles() map[string]ScoreConfig {
+
+	// Libraries are critical when many projects depend on them.
+	library := DefaultScoreConfig()
+	library.Weights.DependentsCount = 3.0
+	library.Weights.RecentReleases = 1.0
+
+	// Applications are rarely depended on, so activity and maintenance matter more.
+	application := DefaultScoreConfig()
+	application.Weights.DependentsCount = 0.5
+	application.Weights.CommitFrequency = 2.0
+	application.Weights.UpdatedIssues = 1.0
+	application.Weights.CommentFrequency = 1.5
+
+	return map[string]ScoreConfig{
+		"default":     DefaultScoreConfig(),
+		"library":     library,
+		"application": application,
+	}
+}
+
+// LoadScoreConfig returns the ScoreConfig for a profile, overridden by a json config file.
+// Settings are applied in order, each overriding only the settings it specifies: the
+// defaults, the built-in profile, the top-level settings of the config file and finally
+// the profile of the same name in the file's "profiles" object. An empty path skips the
+// file and an empty profile skips the profiles. ErrProfileNotFound is returned if the
+// profile is neither built in nor in the file.
+func LoadScoreConfig(path, profile string) (ScoreConfig, error) {
+
+	config := DefaultScoreConfig()
+
+	builtin, found := BuiltinProfiles()[profile]
+	if found {
+		config = builtin
+	}
+
+	var file struct {
+		Profiles map[string]json.RawMessage `json:"profiles"`
+	}
+
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return ScoreConfig{}, err
+		}
+		if err := json.Unmarshal(b, &config); err != nil {
+			return ScoreConfig{}, fmt.Errorf("%w : %s", ErrInvalidConfig, err.Error())
+		}
+		if err := json.Unmarshal(b, &file); err != nil {
+			return ScoreConfig{}, fmt.Errorf("%w : %s", ErrInvalidConfig, err.Error())
+		}
+		if raw, ok := file.Profiles[profile]; ok && profile != "" {
+			if err := json.Unmarshal(raw, &config); err != nil {
+				return ScoreConfig{}, fmt.Errorf("%w : %s", ErrInvalidConfig, err.Error())
+			}
+			found = true
+		}
+	}
+
+	if profile != "" && !found {
+		available := make(map[string]bool)
+		for name := range BuiltinProfiles() {
+			available[name] = true
+		}
+		for name := range file.Profiles {
+			available[name] = true
+		}
+		var names []string
+		for name := range available {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return ScoreConfig{}, fmt.Errorf("%w : %s, available profiles are [%s]", ErrProfileNotFound, profile, strings.Join(names, ", "))
+	}
+
+	return config, nil
+}
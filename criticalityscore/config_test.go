@@ -0,0 +1,121 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "criticalityscore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultScoreConfigRequiresToken(t *testing.T) {
+	if DefaultScoreConfig().AllowUnauthenticated {
+		t.Error("DefaultScoreConfig().AllowUnauthenticated = true, want false")
+	}
+}
+
+func TestLoadScoreConfigDurations(t *testing.T) {
+
+	path := writeConfigFile(t, `{
+		"dependents_backoff": "5s",
+		"user_lookup_delay": "250ms",
+		"stats_retry_delay": "1m",
+		"cache_ttl": "24h",
+		"requests_per_hour": 3000
+	}`)
+
+	config, err := LoadScoreConfig(path, "")
+	if err != nil {
+		t.Fatalf("LoadScoreConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"dependents_backoff", config.DependentsBackoff, 5 * time.Second},
+		{"user_lookup_delay", config.UserLookupDelay, 250 * time.Millisecond},
+		{"stats_retry_delay", config.StatsRetryDelay, time.Minute},
+		{"cache_ttl", config.CacheTTL, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if config.RequestsPerHour != 3000 {
+		t.Errorf("RequestsPerHour = %v, want 3000", config.RequestsPerHour)
+	}
+}
+
+func TestLoadScoreConfigKeepsUnsetDurations(t *testing.T) {
+
+	path := writeConfigFile(t, `{"profiles": {"slow": {"stats_retry_delay": "10s"}}}`)
+
+	config, err := LoadScoreConfig(path, "slow")
+	if err != nil {
+		t.Fatalf("LoadScoreConfig() error = %v", err)
+	}
+	if config.StatsRetryDelay != 10*time.Second {
+		t.Errorf("StatsRetryDelay = %v, want 10s", config.StatsRetryDelay)
+	}
+	if config.DependentsBackoff != DefaultDependentsBackoff {
+		t.Errorf("DependentsBackoff = %v, want the default %v", config.DependentsBackoff, DefaultDependentsBackoff)
+	}
+	if config.CacheTTL != DefaultCacheTTL {
+		t.Errorf("CacheTTL = %v, want the default %v", config.CacheTTL, DefaultCacheTTL)
+	}
+}
+
+func TestLoadScoreConfigRejectsBadDurations(t *testing.T) {
+	for _, content := range []string{
+		`{"dependents_backoff": 5000000000}`,
+		`{"cache_ttl": "a day"}`,
+		`{"profiles": {"p": {"user_lookup_delay": 250}}}`,
+	} {
+		_, err := LoadScoreConfig(writeConfigFile(t, content), "p")
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("LoadScoreConfig(%s) error = %v, want ErrInvalidConfig", content, err)
+		}
+	}
+}
+
+func TestDefaultScoreConfigCopiesScorecardChecks(t *testing.T) {
+	config := DefaultScoreConfig()
+	want := DefaultScorecardChecks[0]
+	config.ScorecardChecks[0] = "Changed"
+	if DefaultScorecardChecks[0] != want {
+		t.Errorf("DefaultScorecardChecks[0] = %q after changing a config, want %q", DefaultScorecardChecks[0], want)
+	}
+}
@@ -15,7 +15,10 @@
 
 package criticalityscore
 
-import "regexp"
+import (
+	"regexp"
+	"time"
+)
 
 // Constants used in OSS criticality score calculation.
 
@@ -34,6 +37,72 @@ const (
 	CommentFrequencyWeight = 1.0
 	DependentsCountWeight  = 2.0
 
+	// Weight and max threshold for the opt-in churn metric.
+
+	ChurnWeight    = 0.5
+	ChurnThreshold = 1000.0
+
+	// Weight and max threshold (new stars per 30 days) for the opt-in star growth metric.
+
+	StarGrowthWeight    = 0.5
+	StarGrowthThreshold = 1000.0
+
+	// Weight and max threshold for the opt-in release contributors metric.
+
+	ReleaseContributorsWeight    = 0.5
+	ReleaseContributorsThreshold = 100.0
+
+	// Weight and max threshold (fraction of commits) for the opt-in signed commits metric.
+
+	SignedCommitsWeight    = 0.25
+	SignedCommitsThreshold = 1.0
+
+	// Weights and max thresholds for the opt-in commit authors metrics. The bus factor
+	// is only reported by default.
+
+	CommitAuthorsWeight    = 1.0
+	CommitAuthorsThreshold = 100.0
+	BusFactorWeight        = 0.0
+	BusFactorThreshold     = 10.0
+
+	// Weights and max thresholds for the opt-in pull request metrics. They're only
+	// reported by default.
+
+	OpenPullRequestsWeight        = 0.0
+	OpenPullRequestsThreshold     = 1000.0
+	MergedPullRequestsWeight      = 0.0
+	MergedPullRequestsThreshold   = 1000.0
+	PullRequestMergeRateWeight    = 0.0
+	PullRequestMergeRateThreshold = 1.0
+
+	// Weight for the opt-in funded signal. It's only reported by default; a negative
+	// weight raises the score of critical projects without funding.
+
+	FundedWeight = 0.0
+
+	// Weight for the opt-in licensed signal.
+
+	LicensedWeight = 0.25
+
+	// Weight for the opt-in security policy signal.
+
+	SecurityPolicyWeight = 0.25
+
+	// Weight for the opt-in CI signal.
+
+	CIWeight = 0.25
+
+	// Weights for the popularity metrics. They're only reported by default.
+
+	StarsCountWeight    = 0.0
+	ForksCountWeight    = 0.0
+	WatchersCountWeight = 0.0
+
+	// Weights for opt-in community signals.
+
+	WikiEnabledWeight        = 0.25
+	DiscussionsEnabledWeight = 0.25
+
 	// Max thresholds for various parameters.
 
 	CreatedSinceThreshold     = 120.0
@@ -46,17 +115,89 @@ const (
 	UpdatedIssuesThreshold    = 5000.0
 	CommentFrequencyThreshold = 15.0
 	DependentsCountThreshold  = 500000.0
+	StarsCountThreshold       = 100000.0
+	ForksCountThreshold       = 20000.0
+	WatchersCountThreshold    = 5000.0
 
 	// Others.
 
-	TopContributorCount = 15.0
-	IssueLookbackDays   = 90.0
-	ReleaseLookbackDays = 365.0
+	TopContributorCount    = 15.0
+	IssueLookbackDays      = 90.0
+	ReleaseLookbackDays    = 365.0
+	PathCommitPageLimit    = 50
+	GeometricMeanFloor     = 0.01
+	ChurnCommitLimit       = 30
+	ChurnLookbackDays      = 90.0
+	StarGrowthPageLimit    = 10
+	StarGrowthLookbackDays = 90.0
+	GitLabCountPageLimit   = 100
+	SignedCommitSampleSize = 100
+	RecentCommitSampleSize = 100
+	AuthorLookbackDays     = 180.0
+	AuthorPageLimit        = 10
+	PullRequestPageLimit   = 10
+
+	// GitHub API rate limits.
+
+	AuthenticatedRequestsPerHour   = 5000.0
+	UnauthenticatedRequestsPerHour = 60.0
+	DefaultRequestBurst            = 100
+
+	// Range a raw criticality score is clamped to.
+
+	DefaultScoreMin = 0.0
+	DefaultScoreMax = 1.0
+
+	// Page size of GitHub list endpoints when per_page isn't given.
+
+	DefaultPerPage = 30
+
+	// Repositories scored at a time in a batch.
+
+	DefaultConcurrency = 4
+
+	// Dependents search retries.
+
+	DefaultDependentsAttempts = 3
+	DefaultDependentsBackoff  = 5 * time.Second
+
+	// Commit statistics retries, while GitHub is still calculating them (202).
+
+	DefaultStatsAttempts   = 4
+	DefaultStatsRetryDelay = 3 * time.Second
+
+	// Contributor user lookups.
+
+	DefaultUserLookupDelay    = 250 * time.Millisecond
+	DefaultUserLookupAttempts = 3
+	DefaultAbuseBackoff       = time.Minute
+
+	// GitHub rate limits: requests kept in reserve before waiting for the reset, the
+	// longest wait for a reset or Retry-After, and retries of rate-limited requests.
+
+	RateLimitReserve         = 50
+	MaxRateLimitWait         = time.Hour
+	DefaultRateLimitAttempts = 3
+
+	// Disk cache.
+
+	DefaultCacheTTL = 24 * time.Hour
 )
 
-var DependentsRegex *regexp.Regexp
+var (
+	DependentsRegex               *regexp.Regexp
+	DependentsNoResultsRegex      *regexp.Regexp
+	DependencyGraphRegex          *regexp.Regexp
+	DependencyGraphNoResultsRegex *regexp.Regexp
+)
 
 func init() {
 	// Regex to match dependents count.
 	DependentsRegex = regexp.MustCompile(".*[^0-9,]([0-9,]+).*commit results")
+	// Regex to match a search results page without any results.
+	DependentsNoResultsRegex = regexp.MustCompile("We couldn.{1,3}t find any .*commits")
+	// Regex to match the dependent repositories count of a dependents page.
+	DependencyGraphRegex = regexp.MustCompile(`dependent_type=REPOSITORY[^>]*>(?:\s|<[^>]*>)*([0-9][0-9,]*)\s+Repositor`)
+	// Regex to match a dependents page without any dependents.
+	DependencyGraphNoResultsRegex = regexp.MustCompile("We haven.{1,3}t found any dependents")
 }
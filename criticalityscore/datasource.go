@@ -0,0 +1,235 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// RepoDataSource is the GitHub API that the metrics of a GitHubRepository are computed
+// from. Each method is a single API request, taking the same arguments and returning the
+// same values as the github.Client method it's named after, so the pagination, counting
+// and aggregation of the metrics is the same for every implementation. NewGitHubDataSource
+// returns the implementation that makes the requests with a github.Client, and any other
+// implementation, such as a fake serving fixed responses, can be loaded with
+// LoadRepositoryFromDataSource to score a repository without network access.
+type RepoDataSource interface {
+	// Authenticated returns whether the requests are made with a token, i.e. whether
+	// the score is reliable.
+	Authenticated() bool
+
+	RateLimits(ctx context.Context) (*github.RateLimits, *github.Response, error)
+	GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error)
+	GetRepositoryByID(ctx context.Context, id int64) (*github.Repository, *github.Response, error)
+	// HasDiscussions returns whether a repository has GitHub Discussions enabled, a flag
+	// github.Repository doesn't have.
+	HasDiscussions(ctx context.Context, owner, repo string) (bool, *github.Response, error)
+	GetContents(ctx context.Context, owner, repo, path string, opts *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error)
+
+	ListCommits(ctx context.Context, owner, repo string, opts *github.CommitsListOptions) ([]*github.RepositoryCommit, *github.Response, error)
+	GetCommit(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error)
+	CompareCommits(ctx context.Context, owner, repo, base, head string) (*github.CommitsComparison, *github.Response, error)
+	ListCommitActivity(ctx context.Context, owner, repo string) ([]*github.WeeklyCommitActivity, *github.Response, error)
+	ListParticipation(ctx context.Context, owner, repo string) (*github.RepositoryParticipation, *github.Response, error)
+	ListContributors(ctx context.Context, owner, repo string, opts *github.ListContributorsOptions) ([]*github.Contributor, *github.Response, error)
+	ListReleases(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error)
+	ListTags(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryTag, *github.Response, error)
+	ListStargazers(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.Stargazer, *github.Response, error)
+	ListIssues(ctx context.Context, owner, repo string, opts *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error)
+	// ListIssueComments lists the comments on all issues of a repository.
+	ListIssueComments(ctx context.Context, owner, repo string, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error)
+	ListPullRequests(ctx context.Context, owner, repo string, opts *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error)
+
+	GetUser(ctx context.Context, login string) (*github.User, *github.Response, error)
+	GetUserByID(ctx context.Context, id int64) (*github.User, *github.Response, error)
+
+	// CountPage returns the number of items on the page of a list at pageURL, e.g. the
+	// "last" link of a Link header.
+	CountPage(ctx context.Context, pageURL string) (int, *github.Response, error)
+	// GraphQL posts a query to the GraphQL API and decodes the response into v.
+	GraphQL(ctx context.Context, query interface{}, v interface{}) (*github.Response, error)
+}
+
+// githubDataSource is the RepoDataSource that makes its requests with a github.Client.
+type githubDataSource struct {
+	client *github.Client
+	authed bool
+}
+
+var _ RepoDataSource = githubDataSource{}
+
+// NewGitHubDataSource returns the RepoDataSource of the GitHub API, with a client
+// authorized with token like the one LoadRepositoryContext creates for config. All
+// repositories loaded from it with LoadRepositoryFromDataSource share the client, so a
+// batch of repositories is scored with a single client, rate limiter and cache.
+func NewGitHubDataSource(ctx context.Context, token string, config ScoreConfig) (RepoDataSource, error) {
+
+	if token == "" && !config.AllowUnauthenticated {
+		return nil, ErrUnauthenticated
+	}
+
+	client, err := newGitHubClient(ctx, token, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return githubDataSource{client: client, authed: token != ""}, nil
+}
+
+func (s githubDataSource) Authenticated() bool {
+	return s.authed
+}
+
+func (s githubDataSource) RateLimits(ctx context.Context) (*github.RateLimits, *github.Response, error) {
+	return s.client.RateLimits(ctx)
+}
+
+func (s githubDataSource) GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	return s.client.Repositories.Get(ctx, owner, repo)
+}
+
+func (s githubDataSource) GetRepositoryByID(ctx context.Context, id int64) (*github.Repository, *github.Response, error) {
+	return s.client.Repositories.GetByID(ctx, id)
+}
+
+// HasDiscussions fetches the repository again, decoding only has_discussions.
+func (s githubDataSource) HasDiscussions(ctx context.Context, owner, repo string) (bool, *github.Response, error) {
+
+	req, err := s.client.NewRequest("GET", fmt.Sprintf("repos/%s/%s", owner, repo), nil)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var r struct {
+		HasDiscussions bool `json:"has_discussions"`
+	}
+	resp, err := s.client.Do(ctx, req, &r)
+	return r.HasDiscussions, resp, err
+}
+
+func (s githubDataSource) GetContents(ctx context.Context, owner, repo, path string, opts *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error) {
+	return s.client.Repositories.GetContents(ctx, owner, repo, path, opts)
+}
+
+func (s githubDataSource) ListCommits(ctx context.Context, owner, repo string, opts *github.CommitsListOptions) ([]*github.RepositoryCommit, *github.Response, error) {
+	return s.client.Repositories.ListCommits(ctx, owner, repo, opts)
+}
+
+func (s githubDataSource) GetCommit(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error) {
+	return s.client.Repositories.GetCommit(ctx, owner, repo, sha)
+}
+
+func (s githubDataSource) CompareCommits(ctx context.Context, owner, repo, base, head string) (*github.CommitsComparison, *github.Response, error) {
+	return s.client.Repositories.CompareCommits(ctx, owner, repo, base, head)
+}
+
+func (s githubDataSource) ListCommitActivity(ctx context.Context, owner, repo string) ([]*github.WeeklyCommitActivity, *github.Response, error) {
+	return s.client.Repositories.ListCommitActivity(ctx, owner, repo)
+}
+
+func (s githubDataSource) ListParticipation(ctx context.Context, owner, repo string) (*github.RepositoryParticipation, *github.Response, error) {
+	return s.client.Repositories.ListParticipation(ctx, owner, repo)
+}
+
+func (s githubDataSource) ListContributors(ctx context.Context, owner, repo string, opts *github.ListContributorsOptions) ([]*github.Contributor, *github.Response, error) {
+	return s.client.Repositories.ListContributors(ctx, owner, repo, opts)
+}
+
+func (s githubDataSource) ListReleases(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error) {
+	return s.client.Repositories.ListReleases(ctx, owner, repo, opts)
+}
+
+func (s githubDataSource) ListTags(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryTag, *github.Response, error) {
+	return s.client.Repositories.ListTags(ctx, owner, repo, opts)
+}
+
+func (s githubDataSource) ListStargazers(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.Stargazer, *github.Response, error) {
+	return s.client.Activity.ListStargazers(ctx, owner, repo, opts)
+}
+
+func (s githubDataSource) ListIssues(ctx context.Context, owner, repo string, opts *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error) {
+	return s.client.Issues.ListByRepo(ctx, owner, repo, opts)
+}
+
+// ListIssueComments lists the comments on all issues, which go-github requests from the
+// repository-wide endpoint for issue number 0.
+func (s githubDataSource) ListIssueComments(ctx context.Context, owner, repo string, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	return s.client.Issues.ListComments(ctx, owner, repo, 0, opts)
+}
+
+func (s githubDataSource) ListPullRequests(ctx context.Context, owner, repo string, opts *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	return s.client.PullRequests.List(ctx, owner, repo, opts)
+}
+
+func (s githubDataSource) GetUser(ctx context.Context, login string) (*github.User, *github.Response, error) {
+	return s.client.Users.Get(ctx, login)
+}
+
+func (s githubDataSource) GetUserByID(ctx context.Context, id int64) (*github.User, *github.Response, error) {
+	return s.client.Users.GetByID(ctx, id)
+}
+
+func (s githubDataSource) CountPage(ctx context.Context, pageURL string) (int, *github.Response, error) {
+
+	req, err := s.client.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var items []json.RawMessage
+	resp, err := s.client.Do(ctx, req, &items)
+	return len(items), resp, err
+}
+
+// GraphQL posts to /graphql on api.github.com, and to /api/graphql on a GitHub
+// Enterprise Server.
+func (s githubDataSource) GraphQL(ctx context.Context, query interface{}, v interface{}) (*github.Response, error) {
+
+	u := *s.client.BaseURL
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/v3") + "/graphql"
+
+	req, err := s.client.NewRequest(http.MethodPost, u.String(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.client.Do(ctx, req, v)
+}
+
+// withContextConfig returns a copy of the repository that makes its API requests with
+// ctx and collects its metrics with config, including those of a GitLab project. The
+// copy has its own commit and pull request caches, since what's cached depends on the
+// config.
+func (ghr GitHubRepository) withContextConfig(ctx context.Context, config ScoreConfig) GitHubRepository {
+	ghr.ctx = ctx
+	ghr.config = config
+	ghr.commits = new(commitCache)
+	ghr.pulls = new(pullRequestCache)
+	if ghr.gitlab != nil {
+		gl := *ghr.gitlab
+		gl.ctx = ctx
+		gl.config = config
+		gl.issues = new(gitLabIssueCounts)
+		ghr.gitlab = &gl
+	}
+	return ghr
+}
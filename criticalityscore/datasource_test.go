@@ -0,0 +1,821 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// fakeAPIURL is the base url of the pagination links of fakeGitHub.
+const fakeAPIURL = "https://api.github.test/"
+
+// fakeGitHub is an in-memory RepoDataSource of the repository o/r, which pages its lists
+// like the GitHub API. Each request fails with the error in errs by the name of its
+// method, and the requests of each method are counted. It's also the http transport of
+// the deps.dev Scorecard, which responds with scorecardStatus.
+type fakeGitHub struct {
+	repo            *github.Repository
+	commits         []*github.RepositoryCommit // most recent first
+	contributors    []*github.Contributor
+	users           map[int64]*github.User
+	weeks           []*github.WeeklyCommitActivity
+	releases        []*github.RepositoryRelease // most recent first
+	tags            int
+	stargazers      []*github.Stargazer // oldest first
+	issues          int
+	closedIssues    int
+	comments        int
+	openPulls       int
+	closedPulls     []*github.PullRequest // most recently updated first
+	files           map[string]*github.RepositoryContent
+	dirs            map[string][]*github.RepositoryContent
+	discussions     bool
+	scorecardStatus int
+
+	errs map[string]error
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// newFakeGitHub returns the fake of a busy, widely used repository.
+func newFakeGitHub() *fakeGitHub {
+
+	now := time.Now()
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+
+	f := &fakeGitHub{
+		repo: &github.Repository{
+			Name:             github.String("r"),
+			FullName:         github.String("o/r"),
+			Owner:            &github.User{Login: github.String("o")},
+			HTMLURL:          github.String("https://github.com/o/r"),
+			Language:         github.String("Go"),
+			CreatedAt:        &github.Timestamp{Time: daysAgo(3650)},
+			StargazersCount:  github.Int(10000),
+			ForksCount:       github.Int(2000),
+			SubscribersCount: github.Int(500),
+			HasIssues:        github.Bool(true),
+			HasWiki:          github.Bool(true),
+		},
+		users:           make(map[int64]*github.User),
+		tags:            20,
+		issues:          300,
+		closedIssues:    200,
+		comments:        750,
+		openPulls:       20,
+		discussions:     true,
+		scorecardStatus: http.StatusOK,
+		files: map[string]*github.RepositoryContent{
+			"r:.github/FUNDING.yml": {Type: github.String("file"), Content: github.String("github: o\n")},
+		},
+		dirs: map[string][]*github.RepositoryContent{
+			"r:": {
+				{Type: github.String("dir"), Name: github.String(".github")},
+				{Type: github.String("file"), Name: github.String("SECURITY.md")},
+			},
+			"r:.github/workflows": {
+				{Type: github.String("file"), Name: github.String("ci.yml")},
+			},
+		},
+		calls: make(map[string]int),
+	}
+
+	// A commit a day by six authors, every other one signed.
+	for i := 0; i < 150; i++ {
+		date := &github.Timestamp{Time: daysAgo(i)}
+		f.commits = append(f.commits, &github.RepositoryCommit{
+			SHA:    github.String(strconv.Itoa(i)),
+			Author: &github.User{Login: github.String(fmt.Sprintf("dev%d", i%6))},
+			Commit: &github.Commit{
+				Author:       &github.CommitAuthor{Date: &date.Time},
+				Committer:    &github.CommitAuthor{Date: &date.Time},
+				Verification: &github.SignatureVerification{Verified: github.Bool(i%2 == 0)},
+			},
+		})
+	}
+
+	// The top contributors work for eight companies.
+	for i := 1; i <= 400; i++ {
+		id := int64(i)
+		f.contributors = append(f.contributors, &github.Contributor{ID: &id, Type: github.String("User")})
+		if i <= TopContributorCount {
+			f.users[id] = &github.User{ID: &id, Company: github.String(fmt.Sprintf("@org%d", i%8))}
+		}
+	}
+
+	for i := 0; i < 52; i++ {
+		f.weeks = append(f.weeks, &github.WeeklyCommitActivity{Total: github.Int(30)})
+	}
+
+	// A release a month.
+	for i := 0; i < 12; i++ {
+		f.releases = append(f.releases, &github.RepositoryRelease{
+			TagName:   github.String(fmt.Sprintf("v1.%d.0", 12-i)),
+			CreatedAt: &github.Timestamp{Time: daysAgo(i*30 + 1)},
+		})
+	}
+
+	// A star a day.
+	for i := 250; i > 0; i-- {
+		f.stargazers = append(f.stargazers, &github.Stargazer{StarredAt: &github.Timestamp{Time: daysAgo(i)}})
+	}
+
+	// Pull requests closed over the last 50 days, of which 80 were merged.
+	for i := 0; i < 100; i++ {
+		closed := daysAgo(i / 2)
+		pull := &github.PullRequest{UpdatedAt: &closed, ClosedAt: &closed}
+		if i < 80 {
+			pull.MergedAt = &closed
+		}
+		f.closedPulls = append(f.closedPulls, pull)
+	}
+
+	return f
+}
+
+var _ RepoDataSource = (*fakeGitHub)(nil)
+
+// call counts a request of method and returns the error it fails with.
+func (f *fakeGitHub) call(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[method]++
+	return f.errs[method]
+}
+
+func (f *fakeGitHub) called(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// page returns the bounds of the page of opts of a list of n items, and its response,
+// with a "last" link like GitHub's if there are more pages.
+func (f *fakeGitHub) page(list string, n int, opts github.ListOptions) (int, int, *github.Response) {
+
+	perPage := opts.PerPage
+	if perPage == 0 {
+		perPage = DefaultPerPage
+	}
+	page := opts.Page
+	if page == 0 {
+		page = 1
+	}
+
+	resp := &github.Response{Response: &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}}
+	if last := (n + perPage - 1) / perPage; page < last {
+		resp.NextPage = page + 1
+		resp.LastPage = last
+		resp.Header.Set("Link", fmt.Sprintf(`<%s%s?page=%d&per_page=%d>; rel="last"`, fakeAPIURL, list, last, perPage))
+	}
+
+	start := (page - 1) * perPage
+	if start > n {
+		start = n
+	}
+	end := start + perPage
+	if end > n {
+		end = n
+	}
+	return start, end, resp
+}
+
+// size returns the number of items of a list by the name of its pagination links.
+func (f *fakeGitHub) size(list string) int {
+	switch list {
+	case "commits":
+		return len(f.commits)
+	case "contributors":
+		return len(f.contributors)
+	case "tags":
+		return f.tags
+	case "issues":
+		return f.issues
+	case "closed_issues":
+		return f.closedIssues
+	case "comments":
+		return f.comments
+	case "pulls":
+		return f.openPulls
+	}
+	return 0
+}
+
+// notFound returns the error of a 404 response.
+func notFound() error {
+	return &github.ErrorResponse{
+		Response: &http.Response{
+			StatusCode: http.StatusNotFound,
+			Request:    &http.Request{Method: "GET", URL: &url.URL{Scheme: "https", Host: "api.github.test"}},
+		},
+		Message: "Not Found",
+	}
+}
+
+func (f *fakeGitHub) Authenticated() bool { return true }
+
+func (f *fakeGitHub) RateLimits(ctx context.Context) (*github.RateLimits, *github.Response, error) {
+	if err := f.call("RateLimits"); err != nil {
+		return nil, nil, err
+	}
+	return &github.RateLimits{Core: &github.Rate{Limit: 5000, Remaining: 5000}}, nil, nil
+}
+
+func (f *fakeGitHub) GetRepository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	if err := f.call("GetRepository"); err != nil {
+		return nil, nil, err
+	}
+	if owner != f.repo.GetOwner().GetLogin() || repo != f.repo.GetName() {
+		return nil, nil, notFound()
+	}
+	return f.repo, nil, nil
+}
+
+func (f *fakeGitHub) GetRepositoryByID(ctx context.Context, id int64) (*github.Repository, *github.Response, error) {
+	if err := f.call("GetRepositoryByID"); err != nil {
+		return nil, nil, err
+	}
+	return f.repo, nil, nil
+}
+
+func (f *fakeGitHub) HasDiscussions(ctx context.Context, owner, repo string) (bool, *github.Response, error) {
+	if err := f.call("HasDiscussions"); err != nil {
+		return false, nil, err
+	}
+	return f.discussions, nil, nil
+}
+
+func (f *fakeGitHub) GetContents(ctx context.Context, owner, repo, path string, opts *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error) {
+	if err := f.call("GetContents"); err != nil {
+		return nil, nil, nil, err
+	}
+	key := repo + ":" + path
+	if file, ok := f.files[key]; ok {
+		return file, nil, nil, nil
+	}
+	if dir, ok := f.dirs[key]; ok {
+		return nil, dir, nil, nil
+	}
+	return nil, nil, nil, notFound()
+}
+
+func (f *fakeGitHub) ListCommits(ctx context.Context, owner, repo string, opts *github.CommitsListOptions) ([]*github.RepositoryCommit, *github.Response, error) {
+	if err := f.call("ListCommits"); err != nil {
+		return nil, nil, err
+	}
+	var commits []*github.RepositoryCommit
+	for _, c := range f.commits {
+		if c.GetCommit().GetAuthor().GetDate().Before(opts.Since) {
+			break
+		}
+		commits = append(commits, c)
+	}
+	start, end, resp := f.page("commits", len(commits), opts.ListOptions)
+	return commits[start:end], resp, nil
+}
+
+func (f *fakeGitHub) GetCommit(ctx context.Context, owner, repo, sha string) (*github.RepositoryCommit, *github.Response, error) {
+	if err := f.call("GetCommit"); err != nil {
+		return nil, nil, err
+	}
+	// Each commit changes one of ten files.
+	i, _ := strconv.Atoi(sha)
+	return &github.RepositoryCommit{
+		SHA:   github.String(sha),
+		Stats: &github.CommitStats{Total: github.Int(1)},
+		Files: []github.CommitFile{{Filename: github.String(fmt.Sprintf("file%d.go", i%10))}},
+	}, nil, nil
+}
+
+func (f *fakeGitHub) CompareCommits(ctx context.Context, owner, repo, base, head string) (*github.CommitsComparison, *github.Response, error) {
+	if err := f.call("CompareCommits"); err != nil {
+		return nil, nil, err
+	}
+	var commits []github.RepositoryCommit
+	for _, c := range f.commits[:30] {
+		commits = append(commits, *c)
+	}
+	return &github.CommitsComparison{Commits: commits}, nil, nil
+}
+
+func (f *fakeGitHub) ListCommitActivity(ctx context.Context, owner, repo string) ([]*github.WeeklyCommitActivity, *github.Response, error) {
+	if err := f.call("ListCommitActivity"); err != nil {
+		return nil, nil, err
+	}
+	return f.weeks, nil, nil
+}
+
+func (f *fakeGitHub) ListParticipation(ctx context.Context, owner, repo string) (*github.RepositoryParticipation, *github.Response, error) {
+	if err := f.call("ListParticipation"); err != nil {
+		return nil, nil, err
+	}
+	participation := &github.RepositoryParticipation{}
+	for _, week := range f.weeks {
+		participation.All = append(participation.All, week.GetTotal())
+	}
+	return participation, nil, nil
+}
+
+func (f *fakeGitHub) ListContributors(ctx context.Context, owner, repo string, opts *github.ListContributorsOptions) ([]*github.Contributor, *github.Response, error) {
+	if err := f.call("ListContributors"); err != nil {
+		return nil, nil, err
+	}
+	start, end, resp := f.page("contributors", len(f.contributors), opts.ListOptions)
+	return f.contributors[start:end], resp, nil
+}
+
+func (f *fakeGitHub) ListReleases(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error) {
+	if err := f.call("ListReleases"); err != nil {
+		return nil, nil, err
+	}
+	start, end, resp := f.page("releases", len(f.releases), *opts)
+	return f.releases[start:end], resp, nil
+}
+
+func (f *fakeGitHub) ListTags(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryTag, *github.Response, error) {
+	if err := f.call("ListTags"); err != nil {
+		return nil, nil, err
+	}
+	start, end, resp := f.page("tags", f.tags, *opts)
+	return make([]*github.RepositoryTag, end-start), resp, nil
+}
+
+func (f *fakeGitHub) ListStargazers(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.Stargazer, *github.Response, error) {
+	if err := f.call("ListStargazers"); err != nil {
+		return nil, nil, err
+	}
+	start, end, resp := f.page("stargazers", len(f.stargazers), *opts)
+	return f.stargazers[start:end], resp, nil
+}
+
+func (f *fakeGitHub) ListIssues(ctx context.Context, owner, repo string, opts *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error) {
+	if err := f.call("ListIssues"); err != nil {
+		return nil, nil, err
+	}
+	list := "issues"
+	if opts.State == "closed" {
+		list = "closed_issues"
+	}
+	start, end, resp := f.page(list, f.size(list), opts.ListOptions)
+	return make([]*github.Issue, end-start), resp, nil
+}
+
+func (f *fakeGitHub) ListIssueComments(ctx context.Context, owner, repo string, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	if err := f.call("ListIssueComments"); err != nil {
+		return nil, nil, err
+	}
+	start, end, resp := f.page("comments", f.comments, opts.ListOptions)
+	return make([]*github.IssueComment, end-start), resp, nil
+}
+
+func (f *fakeGitHub) ListPullRequests(ctx context.Context, owner, repo string, opts *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	if err := f.call("ListPullRequests"); err != nil {
+		return nil, nil, err
+	}
+	if opts.State == "closed" {
+		start, end, resp := f.page("closed_pulls", len(f.closedPulls), opts.ListOptions)
+		return f.closedPulls[start:end], resp, nil
+	}
+	start, end, resp := f.page("pulls", f.openPulls, opts.ListOptions)
+	return make([]*github.PullRequest, end-start), resp, nil
+}
+
+func (f *fakeGitHub) GetUser(ctx context.Context, login string) (*github.User, *github.Response, error) {
+	if err := f.call("GetUser"); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, notFound()
+}
+
+func (f *fakeGitHub) GetUserByID(ctx context.Context, id int64) (*github.User, *github.Response, error) {
+	if err := f.call("GetUserByID"); err != nil {
+		return nil, nil, err
+	}
+	user, ok := f.users[id]
+	if !ok {
+		return nil, nil, notFound()
+	}
+	return user, nil, nil
+}
+
+func (f *fakeGitHub) CountPage(ctx context.Context, pageURL string) (int, *github.Response, error) {
+	if err := f.call("CountPage"); err != nil {
+		return 0, nil, err
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return 0, nil, err
+	}
+	page, _ := strconv.Atoi(u.Query().Get("page"))
+	perPage, _ := strconv.Atoi(u.Query().Get("per_page"))
+	list := strings.TrimPrefix(u.Path, "/")
+	start, end, resp := f.page(list, f.size(list), github.ListOptions{Page: page, PerPage: perPage})
+	return end - start, resp, nil
+}
+
+func (f *fakeGitHub) GraphQL(ctx context.Context, query interface{}, v interface{}) (*github.Response, error) {
+	if err := f.call("GraphQL"); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w : not supported by the fake", ErrGraphQL)
+}
+
+// RoundTrip serves the deps.dev project of the repository.
+func (f *fakeGitHub) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := `{"scorecard": {"date": "2026-10-01", "overallScore": 7, "checks": [{"name": "Maintained", "score": 10}]}}`
+	return &http.Response{
+		StatusCode: f.scorecardStatus,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+// fakeConfig returns the default config for scoring a fakeGitHub, with its dependents
+// counted by a fixed source and without the delay between user lookups.
+func fakeConfig() ScoreConfig {
+	config := DefaultScoreConfig()
+	config.UserLookupDelay = 0
+	config.DependentsSource = countFunc(func() (int, error) { return 50000, nil })
+	return config
+}
+
+// loadFake loads the repository of a fakeGitHub, requesting its Scorecard from the fake.
+func loadFake(t *testing.T, f *fakeGitHub, config ScoreConfig) GitHubRepository {
+	t.Helper()
+	ghr, err := LoadRepositoryFromDataSource(context.Background(), "https://github.com/o/r", f, config)
+	if err != nil {
+		t.Fatalf("LoadRepositoryFromDataSource() error = %v", err)
+	}
+	ghr.httpClient = &http.Client{Transport: f}
+	return ghr
+}
+
+// withOptIns returns a config with every opt-in metric enabled.
+func withOptIns(config ScoreConfig) ScoreConfig {
+	config.CommunitySignals = true
+	config.Churn = true
+	config.StarGrowth = true
+	config.ReleaseContributors = true
+	config.CommitAuthors = true
+	config.PullRequests = true
+	config.SignedCommits = true
+	config.Funding = true
+	config.License = true
+	config.SecurityPolicy = true
+	config.CI = true
+	config.Scorecard = true
+	return config
+}
+
+func TestLoadRepositoryFromDataSource(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		repoURL string
+		wantErr error
+	}{
+		{name: "repository", repoURL: "https://github.com/o/r"},
+		{name: "not found", repoURL: "https://github.com/o/missing", wantErr: ErrRepoNotFound},
+		{name: "invalid url", repoURL: "https://github.com/o", wantErr: ErrInvalidGitHubURL},
+		{name: "no url", wantErr: ErrRepoNotProvided},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			f := newFakeGitHub()
+			ghr, err := LoadRepositoryFromDataSource(context.Background(), tt.repoURL, f, fakeConfig())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoadRepositoryFromDataSource() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && ghr.R.GetFullName() != "o/r" {
+				t.Errorf("repository = %q, want o/r", ghr.R.GetFullName())
+			}
+			if calls := f.called("RateLimits"); calls != 0 {
+				t.Errorf("RateLimits called %d times, want the rate limit left to the caller", calls)
+			}
+		})
+	}
+}
+
+func TestRepositoryStatsFake(t *testing.T) {
+
+	errForbidden := errors.New("403 forbidden")
+
+	tests := []struct {
+		name            string
+		modify          func(f *fakeGitHub)
+		config          func(ScoreConfig) ScoreConfig
+		errs            map[string]error
+		wantErrMetrics  []string
+		wantUnavailable []string
+		wantWarning     string
+		check           func(t *testing.T, score Score)
+	}{
+		{
+			name: "all metrics",
+			check: func(t *testing.T, score Score) {
+				if score.ContributorCount != 400 || score.OrgCount != 8 || score.DependentsCount != 50000 {
+					t.Errorf("metrics = %d contributors, %d orgs, %d dependents, want 400, 8, 50000", score.ContributorCount, score.OrgCount, score.DependentsCount)
+				}
+				if score.UpdatedIssuesCount != 300 || score.ClosedIssuesCount != 200 || score.CommentFrequency != 2.5 {
+					t.Errorf("issue metrics = %d updated, %d closed, %v comment frequency, want 300, 200, 2.5", score.UpdatedIssuesCount, score.ClosedIssuesCount, score.CommentFrequency)
+				}
+				if score.CommitFrequency != 30 || score.RecentReleasesCount != 12 || score.UpdatedSince != 0 {
+					t.Errorf("activity = %v commit frequency, %d releases, updated %d months ago, want 30, 12, 0", score.CommitFrequency, score.RecentReleasesCount, score.UpdatedSince)
+				}
+				if score.CriticalityScore <= 0 || score.CriticalityScore > 1 {
+					t.Errorf("CriticalityScore = %v, want within (0, 1]", score.CriticalityScore)
+				}
+				if score.ChurnFilesCount != nil {
+					t.Errorf("ChurnFilesCount = %d, want nil when not enabled", *score.ChurnFilesCount)
+				}
+			},
+		},
+		{
+			name:   "all opt-in metrics",
+			config: withOptIns,
+			check: func(t *testing.T, score Score) {
+				if score.ChurnFilesCount == nil || *score.ChurnFilesCount != 10 {
+					t.Errorf("ChurnFilesCount = %v, want 10", score.ChurnFilesCount)
+				}
+				if score.BusFactor == nil || *score.BusFactor != 3 {
+					t.Errorf("BusFactor = %v, want 3", score.BusFactor)
+				}
+				if score.ReleaseContributors == nil || *score.ReleaseContributors != 6 {
+					t.Errorf("ReleaseContributors = %v, want 6", score.ReleaseContributors)
+				}
+				if score.OpenPullRequests == nil || *score.OpenPullRequests != 20 || score.MergedPullRequests == nil || *score.MergedPullRequests != 80 {
+					t.Errorf("pull requests = %v open, %v merged, want 20, 80", score.OpenPullRequests, score.MergedPullRequests)
+				}
+				if score.SignedCommitsRatio == nil || *score.SignedCommitsRatio != 0.5 {
+					t.Errorf("SignedCommits = %v, want 0.5", score.SignedCommitsRatio)
+				}
+				if score.StarGrowth == nil || *score.StarGrowth <= 0 {
+					t.Errorf("StarGrowth = %v, want positive", score.StarGrowth)
+				}
+				if score.CI == nil || !*score.CI || score.SecurityPolicy == nil || !*score.SecurityPolicy {
+					t.Errorf("CI = %v, SecurityPolicy = %v, want true", score.CI, score.SecurityPolicy)
+				}
+				if score.Scorecard == nil || score.Scorecard.OverallScore != 7 {
+					t.Errorf("Scorecard = %v, want the scorecard", score.Scorecard)
+				}
+			},
+		},
+		{
+			name:           "failed metric fails the score",
+			errs:           map[string]error{"ListContributors": errForbidden},
+			wantErrMetrics: []string{"contributor_count", "org_count"},
+		},
+		{
+			name:            "empty repository",
+			modify:          func(f *fakeGitHub) { f.commits = nil },
+			wantUnavailable: []string{"updated_since"},
+		},
+		{
+			name: "dependents without a match are left out",
+			config: func(config ScoreConfig) ScoreConfig {
+				config.DependentsSource = countFunc(func() (int, error) { return 0, ErrDependentsNoMatch })
+				return config
+			},
+			wantUnavailable: []string{"dependents_count"},
+		},
+		{
+			name: "dependents with a wrapped no match are left out",
+			config: func(config ScoreConfig) ScoreConfig {
+				config.DependentsSource = countFunc(func() (int, error) { return 0, fmt.Errorf("custom source: %w", ErrDependentsNoMatch) })
+				return config
+			},
+			wantUnavailable: []string{"dependents_count"},
+		},
+		{
+			name:            "failed opt-in metrics are left out with a warning",
+			config:          withOptIns,
+			errs:            map[string]error{"HasDiscussions": errForbidden, "ListPullRequests": errForbidden},
+			wantUnavailable: []string{"discussions_enabled", "merged_pull_requests_count", "open_pull_requests_count", "pull_request_merge_rate"},
+			wantWarning:     "discussions_enabled is unavailable : 403 forbidden",
+			check: func(t *testing.T, score Score) {
+				if score.DiscussionsEnabled != nil {
+					t.Errorf("DiscussionsEnabled = %v, want nil", *score.DiscussionsEnabled)
+				}
+				if score.CI == nil {
+					t.Error("CI = nil, want the other opt-in metrics kept")
+				}
+			},
+		},
+		{
+			name:            "unavailable opt-in metric has no warning",
+			config:          withOptIns,
+			modify:          func(f *fakeGitHub) { f.releases = f.releases[:1] },
+			wantUnavailable: []string{"release_contributors_count"},
+			check: func(t *testing.T, score Score) {
+				for _, w := range score.Warnings {
+					if strings.HasPrefix(w, "release_contributors_count is unavailable :") {
+						t.Errorf("warning %q, want none for an unavailable metric", w)
+					}
+				}
+			},
+		},
+		{
+			name:        "failed scorecard is a warning",
+			config:      withOptIns,
+			modify:      func(f *fakeGitHub) { f.scorecardStatus = http.StatusInternalServerError },
+			wantWarning: "scorecard is unavailable: deps.dev returned status 500",
+		},
+		{
+			name: "skipped metrics",
+			config: func(config ScoreConfig) ScoreConfig {
+				config.NoDependents = true
+				config.Metrics = []string{"created_since", "contributor_count", "org_count", "dependents_count"}
+				return config
+			},
+			wantUnavailable: []string{"closed_issues_count", "comment_frequency", "commit_frequency", "dependents_count", "forks_count", "recent_releases_count", "stars_count", "updated_issues_count", "updated_since", "watchers_count"},
+			check: func(t *testing.T, score Score) {
+				if score.ContributorCount != 400 {
+					t.Errorf("ContributorCount = %d, want 400", score.ContributorCount)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			config := fakeConfig()
+			if tt.config != nil {
+				config = tt.config(config)
+			}
+			f := newFakeGitHub()
+			f.errs = tt.errs
+			if tt.modify != nil {
+				tt.modify(f)
+			}
+
+			score, err := RepositoryStats(loadFake(t, f, config), nil)
+
+			if tt.wantErrMetrics != nil {
+				var errs MetricErrors
+				if !errors.As(err, &errs) {
+					t.Fatalf("RepositoryStats() error = %v, want MetricErrors", err)
+				}
+				var metrics []string
+				for _, e := range errs {
+					metrics = append(metrics, e.Metric)
+				}
+				if strings.Join(metrics, ",") != strings.Join(tt.wantErrMetrics, ",") {
+					t.Errorf("failed metrics = %v, want %v", metrics, tt.wantErrMetrics)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RepositoryStats() error = %v", err)
+			}
+
+			if strings.Join(score.Unavailable, ",") != strings.Join(tt.wantUnavailable, ",") {
+				t.Errorf("Unavailable = %v, want %v", score.Unavailable, tt.wantUnavailable)
+			}
+			if tt.wantWarning != "" && !contains(score.Warnings, tt.wantWarning) {
+				t.Errorf("Warnings = %q, want %q", score.Warnings, tt.wantWarning)
+			}
+			if tt.check != nil {
+				tt.check(t, score)
+			}
+		})
+	}
+}
+
+func TestRepositoryStatsShortCircuit(t *testing.T) {
+
+	// A new repository without any activity.
+	quiet := func(f *fakeGitHub) {
+		f.repo.CreatedAt = &github.Timestamp{Time: time.Now().AddDate(0, -1, 0)}
+		f.repo.StargazersCount, f.repo.ForksCount, f.repo.SubscribersCount = github.Int(0), github.Int(0), github.Int(0)
+		f.commits = f.commits[len(f.commits)-1:]
+		old := time.Now().AddDate(-5, 0, 0)
+		f.commits[0].Commit.Author.Date = &old
+		f.contributors, f.weeks, f.releases = nil, nil, nil
+		f.tags, f.issues, f.closedIssues, f.comments = 0, 0, 0, 0
+	}
+
+	tests := []struct {
+		name              string
+		modify            func(f *fakeGitHub)
+		failUnder         float64
+		wantSkipped       bool
+		wantBelow         bool
+		wantReleasesCalls int
+	}{
+		{name: "can't reach the fail-under score", modify: quiet, failUnder: 0.5, wantSkipped: true, wantBelow: true},
+		{name: "can reach the fail-under score", failUnder: 0.1, wantReleasesCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			config := fakeConfig()
+			config.ShortCircuit = true
+			config.FailUnder = tt.failUnder
+			f := newFakeGitHub()
+			if tt.modify != nil {
+				tt.modify(f)
+			}
+
+			score, err := RepositoryStats(loadFake(t, f, config), nil)
+			if err != nil {
+				t.Fatalf("RepositoryStats() error = %v", err)
+			}
+
+			if calls := f.called("ListReleases"); calls != tt.wantReleasesCalls {
+				t.Errorf("ListReleases called %d times, want %d", calls, tt.wantReleasesCalls)
+			}
+			skipped := false
+			for _, w := range score.Warnings {
+				if strings.HasPrefix(w, "can't reach the fail-under score") {
+					skipped = true
+				}
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("short-circuited = %v, want %v (warnings %q)", skipped, tt.wantSkipped, score.Warnings)
+			}
+			if score.BelowThreshold != tt.wantBelow {
+				t.Errorf("BelowThreshold = %v, want %v (score %v)", score.BelowThreshold, tt.wantBelow, score.CriticalityScore)
+			}
+		})
+	}
+}
+
+func TestRepositoryStatsContextDone(t *testing.T) {
+
+	ghr := loadFake(t, newFakeGitHub(), fakeConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := RepositoryStatsWithContext(ctx, ghr, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RepositoryStatsWithContext() error = %v, want context.Canceled", err)
+	}
+}
+
+// TestRepositoryStatsConcurrent scores with every metric collected concurrently, several
+// scores at a time sharing a data source, and is meant to be run with -race.
+func TestRepositoryStatsConcurrent(t *testing.T) {
+
+	config := withOptIns(fakeConfig())
+	config.Explain = true
+	config.ParamScores = true
+	f := newFakeGitHub()
+	f.errs = map[string]error{"HasDiscussions": errors.New("discussions failed")}
+	ghr := loadFake(t, f, config)
+
+	var wg sync.WaitGroup
+	scores := make([]Score, 8)
+	errs := make([]error, len(scores))
+	for i := range scores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			scores[i], errs[i] = RepositoryStats(ghr, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range scores {
+		if errs[i] != nil {
+			t.Fatalf("score %d error = %v", i, errs[i])
+		}
+		if scores[i].CriticalityScore != scores[0].CriticalityScore || !contains(scores[i].Unavailable, "discussions_enabled") {
+			t.Errorf("score %d = %v, unavailable %v, want %v with discussions_enabled unavailable", i, scores[i].CriticalityScore, scores[i].Unavailable, scores[0].CriticalityScore)
+		}
+	}
+	if calls := f.called("ListReleases"); calls != 2*len(scores) {
+		t.Errorf("ListReleases called %d times, want %d", calls, 2*len(scores))
+	}
+}
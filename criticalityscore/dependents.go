@@ -0,0 +1,189 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DependentsSource counts the dependents of a repository.
+type DependentsSource interface {
+	Count(ctx context.Context, owner, repo string) (int, error)
+}
+
+// ScrapeDependentsSource counts dependents as the number of GitHub commit search
+// results that contain the repository name, scraped from the search results page.
+// Rate-limited (403/429) and server error (5xx) responses are retried with exponential
+// backoff, starting at Backoff, or after the delay of a Retry-After header, up to
+// Attempts requests, and it returns early when the context is done. A 403 without rate limit headers means the search was blocked and
+// returns ErrForbidden. A page with neither a result count nor a message saying no
+// commits were found returns ErrDependentsNoMatch. The search page is on Host, github.com
+// if empty, e.g. the host of a GitHub Enterprise Server.
+type ScrapeDependentsSource struct {
+	Client   *http.Client
+	Host     string
+	Attempts int
+	Backoff  time.Duration
+}
+
+// Count implements DependentsSource.
+func (s ScrapeDependentsSource) Count(ctx context.Context, owner, repo string) (int, error) {
+
+	params := url.Values{}
+	params.Add("q", fmt.Sprintf(`"%s/%s"`, owner, repo))
+	params.Add("type", "commits")
+
+	dependentsURL := fmt.Sprintf(`https://%s/search?%s`, hostOrDefault(s.Host), params.Encode())
+
+	content, err := getDependentsPage(ctx, s.Client, dependentsURL, s.Attempts, s.Backoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseDependentsCount(content)
+}
+
+// DependencyGraphDependentsSource counts dependents as the number of repositories that
+// depend on the repository according to GitHub's dependency graph, i.e. the "Used by"
+// count, scraped from the dependents page of the repository. Neither the REST nor the
+// GraphQL API exposes dependents: the GraphQL dependency graph only lists the manifests
+// and dependencies of a repository itself, so there's no authenticated request to make
+// instead. Requests are retried like with ScrapeDependentsSource. A repository without
+// a dependency graph, e.g. one in an unsupported ecosystem, returns ErrDependentsNoMatch.
+type DependencyGraphDependentsSource struct {
+	Client   *http.Client
+	Host     string
+	Attempts int
+	Backoff  time.Duration
+}
+
+// Count implements DependentsSource.
+func (s DependencyGraphDependentsSource) Count(ctx context.Context, owner, repo string) (int, error) {
+
+	dependentsURL := fmt.Sprintf(`https://%s/%s/%s/network/dependents`, hostOrDefault(s.Host), url.PathEscape(owner), url.PathEscape(repo))
+
+	content, err := getDependentsPage(ctx, s.Client, dependentsURL, s.Attempts, s.Backoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseDependencyGraphCount(content)
+}
+
+// FallbackDependentsSource counts dependents with Primary, or with Fallback if Primary
+// fails for any reason other than the context being done, e.g. a DependencyGraphDependentsSource
+// falling back to a ScrapeDependentsSource for repositories without a dependency graph.
+// The built-in sources count different things, dependent repositories and mentioning
+// commits, on very different scales, so they're never combined by default, and the
+// dependents counts of a batch combining them aren't comparable across repositories.
+type FallbackDependentsSource struct {
+	Primary  DependentsSource
+	Fallback DependentsSource
+}
+
+// Count implements DependentsSource.
+func (s FallbackDependentsSource) Count(ctx context.Context, owner, repo string) (int, error) {
+
+	count, err := s.Primary.Count(ctx, owner, repo)
+	if err == nil || ctx.Err() != nil {
+		return count, err
+	}
+
+	return s.Fallback.Count(ctx, owner, repo)
+}
+
+// hostOrDefault returns host, or github.com if it's empty.
+func hostOrDefault(host string) string {
+	if host == "" {
+		return DefaultGitHubHost
+	}
+	return host
+}
+
+// getDependentsPage returns the content of a github.com page used to count dependents,
+// requested with the tool's UserAgent. The token isn't sent, since github.com pages
+// don't accept it. Rate-limited (403/429) and server error (5xx) responses are retried
+// with exponential backoff, starting at backoff, up to attempts requests, or after the
+// delay of a Retry-After header. At least one request is made, even if attempts is 0.
+func getDependentsPage(ctx context.Context, client *http.Client, pageURL string, attempts int, backoff time.Duration) ([]byte, error) {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := backoff
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+			backoff *= 2
+			delay = backoff
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", UserAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		content, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests || isRateLimitedResponse(resp):
+			lastErr = ErrDependentsRateLimited
+			if d, ok := retryAfter(resp.Header); ok {
+				delay = d
+			}
+			continue
+		case resp.StatusCode == http.StatusForbidden:
+			return nil, fmt.Errorf("%w : dependents search was blocked", ErrForbidden)
+		case resp.StatusCode >= 500:
+			lastErr = ErrDependentsServerError
+			continue
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("dependents search returned status %d", resp.StatusCode)
+		}
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return content, nil
+	}
+
+	return nil, lastErr
+}
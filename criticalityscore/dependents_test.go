@@ -0,0 +1,307 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestGetDependentsPage(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		attempts int
+		statuses []int
+		header   http.Header
+		wantErr  error
+		wantReqs int
+	}{
+		{name: "ok", attempts: 3, statuses: []int{200}, wantReqs: 1},
+		{name: "zero attempts makes one request", attempts: 0, statuses: []int{200}, wantReqs: 1},
+		{name: "negative attempts makes one request", attempts: -1, statuses: []int{200}, wantReqs: 1},
+		{name: "retries rate limit", attempts: 3, statuses: []int{429, 429, 200}, wantReqs: 3},
+		{name: "retries server error", attempts: 3, statuses: []int{502, 200}, wantReqs: 2},
+		{name: "gives up on server errors", attempts: 2, statuses: []int{500, 500, 200}, wantErr: ErrDependentsServerError, wantReqs: 2},
+		{name: "gives up when rate limited", attempts: 2, statuses: []int{403, 403}, header: http.Header{"X-Ratelimit-Remaining": {"0"}}, wantErr: ErrDependentsRateLimited, wantReqs: 2},
+		{name: "blocked", attempts: 3, statuses: []int{403}, wantErr: ErrForbidden, wantReqs: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("User-Agent") != UserAgent {
+					t.Errorf("User-Agent = %q, want %q", r.Header.Get("User-Agent"), UserAgent)
+				}
+				status := tt.statuses[requests]
+				requests++
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(status)
+				w.Write([]byte("page"))
+			}))
+			defer srv.Close()
+
+			content, err := getDependentsPage(context.Background(), srv.Client(), srv.URL, tt.attempts, time.Millisecond)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("getDependentsPage() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil || string(content) != "page" {
+				t.Errorf("getDependentsPage() = %q, %v, want the page", content, err)
+			}
+			if requests != tt.wantReqs {
+				t.Errorf("made %d requests, want %d", requests, tt.wantReqs)
+			}
+		})
+	}
+}
+
+func TestGetDependentsPageContextDone(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := getDependentsPage(ctx, srv.Client(), srv.URL, 10, time.Hour)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("getDependentsPage() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestFallbackDependentsSource(t *testing.T) {
+
+	source := FallbackDependentsSource{
+		Primary:  countFunc(func() (int, error) { return 0, ErrDependentsNoMatch }),
+		Fallback: countFunc(func() (int, error) { return 7, nil }),
+	}
+	count, err := source.Count(context.Background(), "o", "r")
+	if err != nil || count != 7 {
+		t.Errorf("Count() = %d, %v, want 7 from the fallback", count, err)
+	}
+}
+
+func TestDependentsWrappedNoMatch(t *testing.T) {
+
+	config := DefaultScoreConfig()
+	config.DependentsSource = countFunc(func() (int, error) { return 0, fmt.Errorf("custom source: %w", ErrDependentsNoMatch) })
+	ghr := GitHubRepository{ctx: context.Background(), config: config, R: &github.Repository{Name: github.String("r"), Owner: &github.User{Login: github.String("o")}}}
+
+	if _, err := ghr.Dependents(); !errors.Is(err, ErrDependentsNoMatch) {
+		t.Errorf("Dependents() error = %v, want ErrDependentsNoMatch", err)
+	}
+}
+
+// countFunc is a DependentsSource returning the result of a function.
+type countFunc func() (int, error)
+
+func (f countFunc) Count(ctx context.Context, owner, repo string) (int, error) {
+	return f()
+}
+
+func TestDependentsContextMethods(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		method     string
+		graphPage  string
+		want       int
+		wantErr    error
+		wantSearch bool
+	}{
+		{
+			name:      "dependency graph",
+			method:    DependentsDependencyGraph,
+			graphPage: `<a href="/o/r/network/dependents?dependent_type=REPOSITORY">1,234 Repositories</a>`,
+			want:      1234,
+		},
+		{
+			name:      "no dependency graph doesn't fall back to the search",
+			method:    DependentsDependencyGraph,
+			graphPage: `<p>Dependency graph is not enabled</p>`,
+			wantErr:   ErrDependentsNoMatch,
+		},
+		{
+			name:       "commit search",
+			method:     DependentsCommitSearch,
+			want:       42,
+			wantSearch: true,
+		},
+		{
+			name:       "commit search by default",
+			graphPage:  `<a href="/o/r/network/dependents?dependent_type=REPOSITORY">1,234 Repositories</a>`,
+			want:       42,
+			wantSearch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			searched := false
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/search":
+					searched = true
+					w.Write([]byte(`<h3>42 commit results</h3>`))
+				case strings.HasSuffix(r.URL.Path, "/network/dependents"):
+					w.Write([]byte(tt.graphPage))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			config := DefaultScoreConfig()
+			config.GitHubBaseURL = srv.URL + "/api/v3/"
+			if tt.method != "" {
+				config.DependentsMethod = tt.method
+			}
+			ghr := GitHubRepository{
+				ctx:        context.Background(),
+				httpClient: srv.Client(),
+				config:     config,
+				R:          &github.Repository{Name: github.String("r"), Owner: &github.User{Login: github.String("o")}},
+			}
+
+			count, err := ghr.DependentsContext(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("DependentsContext() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil || count != tt.want {
+				t.Errorf("DependentsContext() = %d, %v, want %d", count, err, tt.want)
+			}
+			if searched != tt.wantSearch {
+				t.Errorf("searched commits = %v, want %v", searched, tt.wantSearch)
+			}
+		})
+	}
+}
+
+func TestParseDependentsCount(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		page    string
+		parse   func([]byte) (int, error)
+		want    int
+		wantErr error
+	}{
+		{name: "search results", page: `<h3>  1,234 commit results  </h3>`, parse: parseDependentsCount, want: 1234},
+		{name: "no search results", page: `<h3>We couldn’t find any commits matching '"o/r"'</h3>`, parse: parseDependentsCount, want: 0},
+		{name: "unparseable search page", page: `<form action="/session">Sign in</form>`, parse: parseDependentsCount, wantErr: ErrDependentsNoMatch},
+		{name: "dependents", page: `<a class="btn-link selected" href="/o/r/network/dependents?dependent_type=REPOSITORY">
+			<svg></svg>
+			12,345
+			Repositories
+		</a>`, parse: parseDependencyGraphCount, want: 12345},
+		{name: "no dependents", page: `<h3>We haven’t found any dependents for this repository yet.</h3>`, parse: parseDependencyGraphCount, want: 0},
+		{name: "no dependency graph", page: `<p>Dependency graph not enabled</p>`, parse: parseDependencyGraphCount, wantErr: ErrDependentsNoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse([]byte(tt.page))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("count = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackDependentsSourceDependencyGraph(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		graphPage  string
+		want       int
+		wantSearch bool
+	}{
+		{
+			name:      "dependency graph",
+			graphPage: `<a href="/o/r/network/dependents?dependent_type=REPOSITORY">1,234 Repositories</a>`,
+			want:      1234,
+		},
+		{
+			name:       "no dependency graph falls back to the search",
+			graphPage:  `<p>Dependency graph is not enabled</p>`,
+			want:       42,
+			wantSearch: true,
+		},
+		{
+			name:       "unparseable page falls back to the search",
+			graphPage:  `<form action="/session">Sign in</form>`,
+			want:       42,
+			wantSearch: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			searched := false
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/search":
+					searched = true
+					w.Write([]byte(`<h3>42 commit results</h3>`))
+				case r.URL.Path == "/o/r/network/dependents":
+					w.Write([]byte(tt.graphPage))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			host := strings.TrimPrefix(srv.URL, "https://")
+			source := FallbackDependentsSource{
+				Primary:  DependencyGraphDependentsSource{Client: srv.Client(), Host: host, Attempts: 1},
+				Fallback: ScrapeDependentsSource{Client: srv.Client(), Host: host, Attempts: 1},
+			}
+
+			count, err := source.Count(context.Background(), "o", "r")
+			if err != nil || count != tt.want {
+				t.Errorf("Count() = %d, %v, want %d", count, err, tt.want)
+			}
+			if searched != tt.wantSearch {
+				t.Errorf("searched commits = %v, want %v", searched, tt.wantSearch)
+			}
+		})
+	}
+}
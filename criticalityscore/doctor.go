@@ -0,0 +1,147 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// doctorSearchRepo is the repository used to check the dependents search page.
+const doctorSearchRepo = "ossf/criticality_score"
+
+// DoctorCheck is the result of a single environment check.
+type DoctorCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Doctor checks that the environment is ready for scoring: the token and its scopes,
+// the remaining rate limit, and whether github.com, or the configured GitHub Enterprise
+// Server, and the search page used by Dependents can be reached. No repository is scored.
+func Doctor(token string, config ScoreConfig) []DoctorCheck {
+
+	ctx := context.Background()
+
+	// Cached responses would hide the current state.
+	config.CacheDir = ""
+	scraper := newScrapeClient(config)
+	host := config.GitHubHost()
+
+	var checks []DoctorCheck
+
+	client, err := newGitHubClient(ctx, token, config)
+	if err != nil {
+		return append(checks, DoctorCheck{"github base url", false, err.Error()})
+	}
+
+	if token == "" {
+		checks = append(checks, DoctorCheck{"token", false, "env variable GITHUB_AUTH_TOKEN not provided"})
+	} else {
+		checks = append(checks, DoctorCheck{"token", true, "provided"})
+
+		user, resp, err := client.Users.Get(ctx, "")
+		if err != nil {
+			checks = append(checks, DoctorCheck{"token scopes", false, classifyForbidden(err).Error()})
+		} else {
+			scopes := resp.Header.Get("X-OAuth-Scopes")
+			if scopes == "" {
+				scopes = "none listed (fine-grained token or no scopes)"
+			}
+			checks = append(checks, DoctorCheck{"token scopes", true, fmt.Sprintf("authenticated as %s, scopes: %s", user.GetLogin(), scopes)})
+		}
+	}
+
+	rateLimits, _, err := client.RateLimits(ctx)
+	if err != nil {
+		checks = append(checks, DoctorCheck{"rate limit", false, err.Error()})
+	} else {
+		core := rateLimits.GetCore()
+		search := rateLimits.GetSearch()
+		checks = append(checks, DoctorCheck{
+			"rate limit",
+			core.Remaining >= 50,
+			fmt.Sprintf("core %d/%d remaining until %s, search %d/%d remaining", core.Remaining, core.Limit, core.Reset.Format("15:04:05"), search.Remaining, search.Limit),
+		})
+	}
+
+	checks = append(checks, reachable(ctx, scraper, host, "https://"+host, false))
+
+	if !config.skipsDependents() {
+		params := url.Values{}
+		params.Add("q", fmt.Sprintf(`"%s"`, doctorSearchRepo))
+		params.Add("type", "commits")
+		checks = append(checks, reachable(ctx, scraper, "dependents search", fmt.Sprintf("https://%s/search?%s", host, params.Encode()), true))
+	}
+
+	return checks
+}
+
+// PrintDoctor outputs the results of the environment checks and whether the
+// environment is ready for scoring.
+func PrintDoctor(checks []DoctorCheck) {
+	ready := true
+	for _, c := range checks {
+		status := "ok"
+		if !c.OK {
+			status = "fail"
+			ready = false
+		}
+		fmt.Printf("[%s] %s: %s\n", status, c.Name, c.Detail)
+	}
+	if ready {
+		fmt.Println("ready")
+		return
+	}
+	fmt.Println("not ready")
+}
+
+// reachable checks that a page returns 200 and, if dependents is set, that the page
+// has a commit results count.
+func reachable(ctx context.Context, client *http.Client, name, pageURL string, dependents bool) DoctorCheck {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return DoctorCheck{name, false, err.Error()}
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return DoctorCheck{name, false, err.Error()}
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return DoctorCheck{name, false, err.Error()}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return DoctorCheck{name, false, fmt.Sprintf("%s returned status %d", pageURL, resp.StatusCode)}
+	}
+
+	if dependents {
+		if _, err := parseDependentsCount(content); err != nil {
+			return DoctorCheck{name, false, err.Error()}
+		}
+	}
+
+	return DoctorCheck{name, true, "reachable"}
+}
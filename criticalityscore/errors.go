@@ -0,0 +1,166 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// ErrorOutput is the structured form of an error, with a suggestion for fixing
+// common misconfigurations.
+type ErrorOutput struct {
+	Error      string `json:"error"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// MetricError is the error of a metric that couldn't be collected.
+type MetricError struct {
+	Metric string
+	Err    error
+}
+
+func (e *MetricError) Error() string {
+	return fmt.Sprintf("%s : %s", e.Metric, e.Err.Error())
+}
+
+// Unwrap returns the underlying error of the metric.
+func (e *MetricError) Unwrap() error {
+	return e.Err
+}
+
+// MetricErrors are the errors of all metrics of a repository that couldn't be collected,
+// as returned by RepositoryStats.
+type MetricErrors []*MetricError
+
+func (e MetricErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the metric errors matches target, so that errors.Is finds
+// e.g. ErrForbidden whichever metric failed with it.
+func (e MetricErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the metric errors that matches target, like errors.As.
+func (e MetricErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retryable reports whether err is likely to be transient, such as a rate limit, a server
+// error or GitHub still calculating statistics, so that scoring the repository again
+// later may succeed. Errors like ErrRepoNotFound or ErrForbidden aren't retryable.
+func Retryable(err error) bool {
+
+	transient := []error{
+		ErrCommitFrequencyBeingCalculated,
+		ErrDependentsRateLimited,
+		ErrDependentsServerError,
+		ErrRateLimitCheck,
+		ErrAPIResponseError,
+	}
+	for _, target := range transient {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr) {
+		return true
+	}
+
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode >= http.StatusInternalServerError
+}
+
+var suggestions = []struct {
+	err        error
+	suggestion string
+}{
+	{ErrUnauthenticated, "set the GITHUB_AUTH_TOKEN env variable to a github personal access token, or allow unauthenticated runs"},
+	{ErrRepoNotProvided, "pass the url of a github repository"},
+	{ErrInvalidGitHubURL, "use a repository url like https://github.com/owner/repo"},
+	{ErrRepoNotFound, "check the repository url, and that the token can read the repository if it's private"},
+	{ErrScopeMissing, "grant the token read access to the repository's metadata and contents"},
+	{ErrForbidden, "check that the token is valid and hasn't expired or been revoked"},
+	{ErrCommitFrequencyBeingCalculated, "github is still calculating statistics for this repository, try again in a few seconds or raise --stats-attempts"},
+	{ErrDependentsRateLimited, "wait a few minutes before scoring more repositories"},
+}
+
+// ErrorSuggestion returns a suggestion for fixing the cause of err, or an empty
+// string if there's none.
+func ErrorSuggestion(err error) string {
+	for _, s := range suggestions {
+		if errors.Is(err, s.err) {
+			return s.suggestion
+		}
+	}
+	return ""
+}
+
+// PrintError outputs an error and a suggestion for fixing it, as a json object for
+// the json format or as plain lines otherwise.
+func PrintError(err error, format string) {
+	WriteError(os.Stdout, err, format)
+}
+
+// WriteError writes an error and the suggestion for fixing it to w, in the same
+// layout as PrintError.
+func WriteError(w io.Writer, err error, format string) {
+
+	output := ErrorOutput{
+		Error:      err.Error(),
+		Suggestion: ErrorSuggestion(err),
+	}
+
+	if format == "json" || format == "openssf" {
+		b, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintln(w, string(b))
+		return
+	}
+
+	fmt.Fprintln(w, output.Error)
+	if output.Suggestion != "" {
+		fmt.Fprintf(w, "suggestion: %s\n", output.Suggestion)
+	}
+}
@@ -0,0 +1,112 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// fundingFilePaths are the paths GitHub reads a repository's FUNDING.yml from, in order.
+var fundingFilePaths = []string{".github/FUNDING.yml", "FUNDING.yml", "docs/FUNDING.yml"}
+
+// Funding returns the funding platforms, such as github (GitHub Sponsors), open_collective
+// or tidelift, listed in the repository's FUNDING.yml, or in the default one of its owner's
+// .github repository. A repository without one has no platforms.
+func (ghr GitHubRepository) Funding() ([]string, error) {
+
+	if ghr.gitlab != nil {
+		return nil, ErrMetricUnavailable
+	}
+
+	owner := ghr.R.GetOwner().GetLogin()
+
+	sources := [][2]string{}
+	for _, path := range fundingFilePaths {
+		sources = append(sources, [2]string{ghr.R.GetName(), path})
+	}
+	sources = append(sources, [2]string{".github", "FUNDING.yml"}, [2]string{".github", ".github/FUNDING.yml"})
+
+	for _, source := range sources {
+		file, _, _, err := ghr.source.GetContents(ghr.ctx, owner, source[0], source[1], nil)
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, classifyForbidden(err)
+		}
+		if file == nil {
+			// The path is a directory.
+			continue
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, err
+		}
+		return parseFundingPlatforms(content), nil
+	}
+
+	return nil, nil
+}
+
+// parseFundingPlatforms returns the platforms with a value in a FUNDING.yml, which maps
+// each platform to an account, a list of accounts or, for custom, urls.
+func parseFundingPlatforms(content string) []string {
+
+	var platforms []string
+	platform := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// An indented list item is a value of the last platform.
+		if line[0] == ' ' || line[0] == '\t' || line[0] == '-' {
+			if platform != "" && strings.HasPrefix(strings.TrimSpace(line), "-") {
+				platforms = append(platforms, platform)
+				platform = ""
+			}
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		platform = strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		switch value {
+		case "", "~", "null", "[]":
+			// The platform may have its values in a list on the next lines.
+			continue
+		}
+		platforms = append(platforms, platform)
+		platform = ""
+	}
+
+	sort.Strings(platforms)
+	return platforms
+}
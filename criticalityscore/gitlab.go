@@ -0,0 +1,451 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+var (
+	ErrGitLabAPIResponse error = fmt.Errorf("gitlab api response error")
+)
+
+// GitLabHost is the host of repository urls loaded from GitLab.
+const GitLabHost = "gitlab.com"
+
+// gitLabAPIURL is the base url of the GitLab REST API.
+const gitLabAPIURL = "https://gitlab.com/api/v4/"
+
+// gitLabUnsupportedMetrics are the metrics the GitLab API has no data for. GitLab's
+// contributors have no account to look up a company for, there is no search of the
+// commits of other projects to count dependents with, and projects have no watchers.
+var gitLabUnsupportedMetrics = []string{"org_count", "dependents_count", "watchers_count"}
+
+// gitLabProject is the subset of a GitLab project used for scoring.
+type gitLabProject struct {
+	ID                int64     `json:"id"`
+	Path              string    `json:"path"`
+	PathWithNamespace string    `json:"path_with_namespace"`
+	WebURL            string    `json:"web_url"`
+	Description       string    `json:"description"`
+	DefaultBranch     string    `json:"default_branch"`
+	Topics            []string  `json:"topics"`
+	Visibility        string    `json:"visibility"`
+	CreatedAt         time.Time `json:"created_at"`
+	LastActivityAt    time.Time `json:"last_activity_at"`
+	StarCount         int       `json:"star_count"`
+	ForksCount        int       `json:"forks_count"`
+	OpenIssuesCount   int       `json:"open_issues_count"`
+	IssuesEnabled     bool      `json:"issues_enabled"`
+	WikiEnabled       bool      `json:"wiki_enabled"`
+	Archived          bool      `json:"archived"`
+	Namespace         struct {
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+	ForkedFromProject *struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"forked_from_project"`
+}
+
+// gitLabRepository is a GitLab project loaded by LoadRepository. Its metrics are
+// collected with the GitLab REST API.
+type gitLabRepository struct {
+	ctx     context.Context
+	client  *http.Client
+	token   string
+	project gitLabProject
+	config  ScoreConfig
+	// issues caches the issue counts used by several metric methods.
+	issues *gitLabIssueCounts
+}
+
+// parseGitLabURL returns the path with namespace of a gitlab.com project url, e.g.
+// group/subgroup/project, or an empty string if the url isn't a GitLab project url.
+// Git remotes such as git@gitlab.com:group/project.git are parsed too.
+func parseGitLabURL(s string) string {
+
+	u, err := url.Parse(normalizeRepoURL(s))
+	if err != nil || !sameHost(u.Host, GitLabHost) {
+		return ""
+	}
+
+	// Pages of a project, such as its issues, are under "/-/".
+	p := strings.Split(u.Path, "/-/")[0]
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if strings.Count(p, "/") < 1 {
+		return ""
+	}
+
+	return p
+}
+
+// loadGitLabRepository returns a GitHubRepository object for a GitLab project, whose
+// metric methods request GitLab. R is filled in from the project, so the project's
+// metadata is output the same as a GitHub repository's.
+func loadGitLabRepository(ctx context.Context, path string, config ScoreConfig) (GitHubRepository, error) {
+
+	if config.GitLabToken == "" && !config.AllowUnauthenticated {
+		return GitHubRepository{}, ErrUnauthenticated
+	}
+
+	gl := &gitLabRepository{
+		ctx:    ctx,
+		client: newGitLabClient(config),
+		token:  config.GitLabToken,
+		config: config,
+		issues: new(gitLabIssueCounts),
+	}
+
+	if resp, err := gl.get("projects/"+url.PathEscape(path), nil, &gl.project); err != nil {
+		if ctx.Err() != nil {
+			return GitHubRepository{}, ctx.Err()
+		}
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return GitHubRepository{}, ErrRepoNotFound
+		}
+		return GitHubRepository{}, fmt.Errorf("repository : %w", err)
+	}
+
+	r := gl.project.repository()
+
+	// The project's main language is the one with the largest share of its code.
+	var languages map[string]float64
+	if _, err := gl.get(gl.projectPath("languages"), nil, &languages); err == nil {
+		share := 0.0
+		for language, percent := range languages {
+			if percent > share {
+				r.Language = github.String(language)
+				share = percent
+			}
+		}
+	}
+
+	return GitHubRepository{
+		ctx:    ctx,
+		authed: gl.token != "",
+		R:      r,
+		config: config,
+		gitlab: gl,
+	}, nil
+}
+
+// newGitLabClient returns the http client used for GitLab API requests, cached on disk if
+// a cache directory is configured.
+func newGitLabClient(config ScoreConfig) *http.Client {
+	if config.CacheDir == "" {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: &cachingTransport{
+			base:  http.DefaultTransport,
+			dir:   config.CacheDir,
+			ttl:   config.CacheTTL,
+			token: config.GitLabToken,
+		},
+	}
+}
+
+// repository maps a GitLab project onto a github.Repository.
+func (p gitLabProject) repository() *github.Repository {
+
+	r := &github.Repository{
+		ID:              github.Int64(p.ID),
+		Name:            github.String(p.Path),
+		FullName:        github.String(p.PathWithNamespace),
+		Owner:           &github.User{Login: github.String(p.Namespace.FullPath)},
+		HTMLURL:         github.String(p.WebURL),
+		Description:     github.String(p.Description),
+		DefaultBranch:   github.String(p.DefaultBranch),
+		Topics:          p.Topics,
+		Private:         github.Bool(p.Visibility != "public"),
+		Fork:            github.Bool(p.ForkedFromProject != nil),
+		Archived:        github.Bool(p.Archived),
+		HasIssues:       github.Bool(p.IssuesEnabled),
+		HasWiki:         github.Bool(p.WikiEnabled),
+		StargazersCount: github.Int(p.StarCount),
+		ForksCount:      github.Int(p.ForksCount),
+		OpenIssuesCount: github.Int(p.OpenIssuesCount),
+		CreatedAt:       &github.Timestamp{Time: p.CreatedAt},
+		UpdatedAt:       &github.Timestamp{Time: p.LastActivityAt},
+		PushedAt:        &github.Timestamp{Time: p.LastActivityAt},
+	}
+
+	if p.ForkedFromProject != nil {
+		r.Parent = &github.Repository{FullName: github.String(p.ForkedFromProject.PathWithNamespace)}
+	}
+
+	return r
+}
+
+// updatedSince returns the number of months since the last commit, or ErrNoCommits for
+// an empty project.
+func (gl *gitLabRepository) updatedSince() (int, error) {
+
+	var last []struct {
+		CommittedDate time.Time `json:"committed_date"`
+	}
+	if _, err := gl.get(gl.projectPath("repository/commits"), url.Values{"per_page": {"1"}}, &last); err != nil {
+		return 0, gitLabUnavailable(err)
+	}
+	if len(last) == 0 {
+		return 0, ErrNoCommits
+	}
+
+	return int(math.Round(time.Since(last[0].CommittedDate).Hours() / 24.0 / 30.0)), nil
+}
+
+// contributors returns the number of contributors of the project.
+func (gl *gitLabRepository) contributors() (int, error) {
+	count, err := gl.total(gl.projectPath("repository/contributors"), nil)
+	return count, gitLabUnavailable(err)
+}
+
+// commitFrequency returns the weekly average number of commits over the last 52 weeks.
+func (gl *gitLabRepository) commitFrequency() (float64, error) {
+
+	since := lookbackTime(52 * 7).UTC().Format(time.RFC3339)
+	count, err := gl.total(gl.projectPath("repository/commits"), url.Values{"since": {since}})
+	if err != nil {
+		return 0, gitLabUnavailable(err)
+	}
+
+	return math.Round(float64(count)/52.0*10.0) / 10, nil
+}
+
+// closedIssues returns the number of issues closed within the issue lookback days.
+func (gl *gitLabRepository) closedIssues() (int, error) {
+	issuesSince := lookbackTime(gl.config.IssueLookbackDays).UTC().Format(time.RFC3339)
+	count, err := gl.total(gl.projectPath("issues"), url.Values{"state": {"closed"}, "updated_after": {issuesSince}})
+	return count, gitLabUnavailable(err)
+}
+
+// updatedIssues returns the number of issues updated within the issue lookback days.
+func (gl *gitLabRepository) updatedIssues() (int, error) {
+	count, _, err := gl.recentIssueComments()
+	return count, err
+}
+
+// commentFrequency returns the ratio of the comments on the issues updated within the
+// issue lookback days to issueCount.
+func (gl *gitLabRepository) commentFrequency(issueCount int) (float64, error) {
+
+	if issueCount == 0 {
+		return 0, nil
+	}
+
+	_, comments, err := gl.recentIssueComments()
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Round(float64(comments)/float64(issueCount)*10.0) / 10, nil
+}
+
+// gitLabIssueCounts caches the issues updated within the issue lookback days and their
+// number of comments, which are counted from the same pages of issues.
+type gitLabIssueCounts struct {
+	once     sync.Once
+	count    int
+	comments int
+	err      error
+}
+
+// recentIssueComments returns the number of issues updated within the issue lookback days
+// and their total number of comments. Issues carry their number of comments, so both are
+// counted from the same requests, which are made once.
+func (gl *gitLabRepository) recentIssueComments() (int, int, error) {
+
+	list := func() (int, int, error) {
+		issuesSince := lookbackTime(gl.config.IssueLookbackDays).UTC().Format(time.RFC3339)
+		count, comments, err := gl.issueComments(gl.projectPath("issues"), url.Values{"updated_after": {issuesSince}})
+		return count, comments, gitLabUnavailable(err)
+	}
+
+	if gl.issues == nil {
+		return list()
+	}
+	c := gl.issues
+	c.once.Do(func() {
+		c.count, c.comments, c.err = list()
+	})
+	return c.count, c.comments, c.err
+}
+
+// projectPath returns the API path of a resource of the project.
+func (gl *gitLabRepository) projectPath(resource string) string {
+	return fmt.Sprintf("projects/%d/%s", gl.project.ID, resource)
+}
+
+// gitLabUnavailable returns the error of a GitLab request wrapped in ErrMetricUnavailable,
+// so a metric GitLab fails to return, e.g. for an empty or restricted project, is left
+// out of the score rather than failing it.
+func gitLabUnavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w : %s", ErrMetricUnavailable, err.Error())
+}
+
+// recentReleases returns the number of releases within the release lookback days.
+func (gl *gitLabRepository) recentReleases() (int, error) {
+
+	since := lookbackTime(gl.config.ReleaseLookbackDays)
+	count := 0
+
+	// Releases are listed most recently released first.
+	err := gl.pages(gl.projectPath("releases"), nil, func(page json.RawMessage) (bool, error) {
+		var releases []struct {
+			ReleasedAt time.Time `json:"released_at"`
+		}
+		if err := json.Unmarshal(page, &releases); err != nil {
+			return false, err
+		}
+		for _, release := range releases {
+			if release.ReleasedAt.Before(since) {
+				return false, nil
+			}
+			count++
+		}
+		return true, nil
+	})
+
+	return count, gitLabUnavailable(err)
+}
+
+// issueComments returns the number of issues matching query and their total number of
+// comments.
+func (gl *gitLabRepository) issueComments(path string, query url.Values) (int, int, error) {
+
+	count, comments := 0, 0
+
+	err := gl.pages(path, query, func(page json.RawMessage) (bool, error) {
+		var issues []struct {
+			UserNotesCount int `json:"user_notes_count"`
+		}
+		if err := json.Unmarshal(page, &issues); err != nil {
+			return false, err
+		}
+		for _, issue := range issues {
+			count++
+			comments += issue.UserNotesCount
+		}
+		return true, nil
+	})
+
+	return count, comments, err
+}
+
+// total returns the number of items of a list, from its X-Total header or, for large
+// lists where GitLab leaves it out, by paging through up to GitLabCountPageLimit pages.
+func (gl *gitLabRepository) total(path string, query url.Values) (int, error) {
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("per_page", "1")
+
+	var first []json.RawMessage
+	resp, err := gl.get(path, q, &first)
+	if err != nil {
+		return 0, err
+	}
+	if total, err := strconv.Atoi(resp.Header.Get("X-Total")); err == nil {
+		return total, nil
+	}
+
+	count := 0
+	err = gl.pages(path, query, func(page json.RawMessage) (bool, error) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(page, &items); err != nil {
+			return false, err
+		}
+		count += len(items)
+		return true, nil
+	})
+
+	return count, err
+}
+
+// pages calls f with each page of a list, 100 items per page, until f returns false,
+// there are no more pages or GitLabCountPageLimit pages were read.
+func (gl *gitLabRepository) pages(path string, query url.Values, f func(json.RawMessage) (bool, error)) error {
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("per_page", "100")
+
+	for page := 1; page <= GitLabCountPageLimit; page++ {
+		q.Set("page", strconv.Itoa(page))
+
+		var items json.RawMessage
+		resp, err := gl.get(path, q, &items)
+		if err != nil {
+			return err
+		}
+		more, err := f(items)
+		if err != nil || !more {
+			return err
+		}
+		if resp.Header.Get("X-Next-Page") == "" {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// get requests a path of the GitLab API and decodes the json response into v.
+func (gl *gitLabRepository) get(path string, query url.Values, v interface{}) (*http.Response, error) {
+
+	u := gitLabAPIURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(gl.ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if gl.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", gl.token)
+	}
+
+	resp, err := gl.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("%w : %s returned status %d", ErrGitLabAPIResponse, path, resp.StatusCode)
+	}
+
+	return resp, json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,217 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestGitLabRepository returns a GitHubRepository for the GitLab project g/p, with
+// its API requests served by handler, and counts the requests by path.
+func newTestGitLabRepository(t *testing.T, config ScoreConfig, handler http.HandlerFunc) (GitHubRepository, map[string]int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	// Requests to the GitLab API go to the test server instead.
+	target, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	gl := &gitLabRepository{
+		ctx:    context.Background(),
+		client: client,
+		token:  "tok",
+		project: gitLabProject{
+			ID:                42,
+			Path:              "p",
+			PathWithNamespace: "g/p",
+			CreatedAt:         time.Now().AddDate(-2, 0, 0),
+			IssuesEnabled:     true,
+		},
+		config: config,
+		issues: new(gitLabIssueCounts),
+	}
+	gl.project.Namespace.FullPath = "g"
+
+	return GitHubRepository{
+		ctx:    context.Background(),
+		authed: true,
+		R:      gl.project.repository(),
+		config: config,
+		gitlab: gl,
+	}, requests
+}
+
+// roundTripFunc is an http.RoundTripper calling a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// gitLabHandler serves a project with 520 commits in the last year by 12 contributors,
+// 4 recently updated issues with 3 comments each, of which 1 was closed.
+func gitLabHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/v4/projects/42/repository/commits":
+		w.Header().Set("X-Total", "520")
+		fmt.Fprintf(w, `[{"committed_date":%q}]`, time.Now().Format(time.RFC3339))
+	case "/api/v4/projects/42/repository/contributors":
+		w.Header().Set("X-Total", "12")
+		fmt.Fprint(w, `[{}]`)
+	case "/api/v4/projects/42/releases":
+		fmt.Fprintf(w, `[{"released_at":%q}]`, time.Now().Format(time.RFC3339))
+	case "/api/v4/projects/42/issues":
+		if r.URL.Query().Get("state") == "closed" {
+			w.Header().Set("X-Total", "1")
+			fmt.Fprint(w, `[{}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"user_notes_count":3},{"user_notes_count":3},{"user_notes_count":3},{"user_notes_count":3}]`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// TestGitLabMetricMethods calls every metric method of a GitLab project, which must
+// request GitLab or return ErrMetricUnavailable rather than use the GitHub client.
+func TestGitLabMetricMethods(t *testing.T) {
+
+	ghr, _ := newTestGitLabRepository(t, DefaultScoreConfig(), gitLabHandler)
+
+	v := reflect.ValueOf(ghr)
+	for i := 0; i < v.NumMethod(); i++ {
+		method := v.Type().Method(i)
+		t.Run(method.Name, func(t *testing.T) {
+
+			var args []reflect.Value
+			for j := 0; j < method.Type.NumIn()-1; j++ {
+				switch in := method.Type.In(j + 1); in.Kind() {
+				case reflect.Int:
+					args = append(args, reflect.ValueOf(4))
+				case reflect.Interface:
+					args = append(args, reflect.ValueOf(context.Background()))
+				default:
+					t.Skipf("argument of type %s", in)
+				}
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s() panicked: %v", method.Name, r)
+				}
+			}()
+			out := v.Method(i).Call(args)
+
+			last := out[len(out)-1]
+			if err, ok := last.Interface().(error); ok && err != nil && !errors.Is(err, ErrMetricUnavailable) {
+				t.Errorf("%s() error = %v, want nil or ErrMetricUnavailable", method.Name, err)
+			}
+		})
+	}
+
+	if n, err := ghr.Contributors(); n != 12 || err != nil {
+		t.Errorf("Contributors() = %d, %v, want 12 from GitLab", n, err)
+	}
+	if frequency, err := ghr.CommitFrequency(); frequency != 10 || err != nil {
+		t.Errorf("CommitFrequency() = %v, %v, want 10 from GitLab", frequency, err)
+	}
+}
+
+func TestRepositoryStatsGitLab(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		metrics         []string
+		wantUnavailable []string
+		want            Score
+	}{
+		{
+			name:            "all metrics",
+			wantUnavailable: []string{"dependents_count", "org_count", "watchers_count"},
+			want:            Score{ContributorCount: 12, CommitFrequency: 10, RecentReleasesCount: 1, ClosedIssuesCount: 1, UpdatedIssuesCount: 4, CommentFrequency: 3},
+		},
+		{
+			name:            "only comment frequency",
+			metrics:         []string{"comment_frequency"},
+			wantUnavailable: []string{"closed_issues_count", "commit_frequency", "contributor_count", "created_since", "dependents_count", "forks_count", "org_count", "recent_releases_count", "stars_count", "updated_issues_count", "updated_since", "watchers_count"},
+			want:            Score{CommentFrequency: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			config := DefaultScoreConfig()
+			config.Metrics = tt.metrics
+			ghr, requests := newTestGitLabRepository(t, config, gitLabHandler)
+
+			score, err := RepositoryStats(ghr, nil)
+			if err != nil {
+				t.Fatalf("RepositoryStats() error = %v", err)
+			}
+
+			if strings.Join(score.Unavailable, ",") != strings.Join(tt.wantUnavailable, ",") {
+				t.Errorf("Unavailable = %v, want %v", score.Unavailable, tt.wantUnavailable)
+			}
+			got := Score{
+				ContributorCount:    score.ContributorCount,
+				CommitFrequency:     score.CommitFrequency,
+				RecentReleasesCount: score.RecentReleasesCount,
+				ClosedIssuesCount:   score.ClosedIssuesCount,
+				UpdatedIssuesCount:  score.UpdatedIssuesCount,
+				CommentFrequency:    score.CommentFrequency,
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metrics = %+v, want %+v", got, tt.want)
+			}
+			if !contains(score.Warnings, "scored from gitlab, which has no data for org_count or dependents_count or watchers_count") {
+				t.Errorf("Warnings = %q, want the gitlab warning", score.Warnings)
+			}
+
+			// The updated issues are listed once for both issue metrics, besides the
+			// request of the closed issues count.
+			wantIssues := 2
+			if tt.metrics != nil {
+				wantIssues = 1
+			}
+			if n := requests["/api/v4/projects/42/issues"]; n != wantIssues {
+				t.Errorf("issues requested %d times, want %d", n, wantIssues)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	ErrGraphQL error = fmt.Errorf("github graphql api response error")
+)
+
+// graphQLStatsQuery fetches the commit, issue and release data of several metrics in a
+// single request. The issue counts use search, and are only requested if $search is true.
+const graphQLStatsQuery = `query($owner: String!, $name: String!, $commitsSince: GitTimestamp!, $search: Boolean!, $updatedQuery: String!, $closedQuery: String!) {
+  repository(owner: $owner, name: $name) {
+    defaultBranchRef {
+      target {
+        ... on Commit {
+          latest: history(first: 1) { nodes { authoredDate } }
+          recent: history(since: $commitsSince) { totalCount }
+        }
+      }
+    }
+    releases(first: 100, orderBy: {field: CREATED_AT, direction: DESC}) {
+      nodes { createdAt }
+      pageInfo { hasNextPage }
+    }
+    tags: refs(refPrefix: "refs/tags/", first: 0) { totalCount }
+  }
+  updated: search(type: ISSUE, query: $updatedQuery, first: 0) @include(if: $search) { issueCount }
+  closed: search(type: ISSUE, query: $closedQuery, first: 0) @include(if: $search) { issueCount }
+}`
+
+// graphQLStats holds the metric data returned by graphQLStatsQuery.
+type graphQLStats struct {
+	lastCommit    *time.Time
+	recentCommits int
+	// issueCounts is whether updatedIssues and closedIssues were requested.
+	issueCounts   bool
+	updatedIssues int
+	closedIssues  int
+	releases      []time.Time
+	// moreReleases is whether there are releases beyond the first page.
+	moreReleases bool
+	tags         int
+}
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLResponse struct {
+	Data struct {
+		Repository *struct {
+			DefaultBranchRef *struct {
+				Target struct {
+					Latest struct {
+						Nodes []struct {
+							AuthoredDate time.Time `json:"authoredDate"`
+						} `json:"nodes"`
+					} `json:"latest"`
+					Recent struct {
+						TotalCount int `json:"totalCount"`
+					} `json:"recent"`
+				} `json:"target"`
+			} `json:"defaultBranchRef"`
+			Releases struct {
+				Nodes []struct {
+					CreatedAt time.Time `json:"createdAt"`
+				} `json:"nodes"`
+				PageInfo struct {
+					HasNextPage bool `json:"hasNextPage"`
+				} `json:"pageInfo"`
+			} `json:"releases"`
+			Tags struct {
+				TotalCount int `json:"totalCount"`
+			} `json:"tags"`
+		} `json:"repository"`
+		Updated *struct {
+			IssueCount int `json:"issueCount"`
+		} `json:"updated"`
+		Closed *struct {
+			IssueCount int `json:"issueCount"`
+		} `json:"closed"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// loadGraphQLStats requests graphQLStatsQuery from GitHub's GraphQL API, which
+// requires a token. The issue counts are left out with NoSearch.
+func (ghr GitHubRepository) loadGraphQLStats() (*graphQLStats, error) {
+
+	if !ghr.authed {
+		return nil, fmt.Errorf("%w : the graphql api requires a token", ErrGraphQL)
+	}
+
+	repo := ghr.R.GetFullName()
+	issuesSince := lookbackTime(ghr.config.IssueLookbackDays).UTC().Format(time.RFC3339)
+
+	body := graphQLRequest{
+		Query: graphQLStatsQuery,
+		Variables: map[string]interface{}{
+			"owner":        ghr.R.GetOwner().GetLogin(),
+			"name":         ghr.R.GetName(),
+			"commitsSince": time.Now().AddDate(0, 0, -52*7).UTC().Format(time.RFC3339),
+			"search":       !ghr.config.NoSearch,
+			"updatedQuery": fmt.Sprintf("repo:%s updated:>=%s", repo, issuesSince),
+			"closedQuery":  fmt.Sprintf("repo:%s is:closed updated:>=%s", repo, issuesSince),
+		},
+	}
+
+	var resp graphQLResponse
+	if _, err := ghr.source.GraphQL(ghr.ctx, body, &resp); err != nil {
+		return nil, classifyForbidden(err)
+	}
+	if len(resp.Errors) > 0 {
+		messages := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			messages[i] = e.Message
+		}
+		return nil, fmt.Errorf("%w : %s", ErrGraphQL, strings.Join(messages, "; "))
+	}
+	r := resp.Data.Repository
+	if r == nil {
+		return nil, fmt.Errorf("%w : repository %s not found", ErrGraphQL, repo)
+	}
+
+	stats := &graphQLStats{
+		issueCounts:  resp.Data.Updated != nil && resp.Data.Closed != nil,
+		moreReleases: r.Releases.PageInfo.HasNextPage,
+		tags:         r.Tags.TotalCount,
+	}
+	if ref := r.DefaultBranchRef; ref != nil {
+		if len(ref.Target.Latest.Nodes) > 0 {
+			stats.lastCommit = &ref.Target.Latest.Nodes[0].AuthoredDate
+		}
+		stats.recentCommits = ref.Target.Recent.TotalCount
+	}
+	if stats.issueCounts {
+		stats.updatedIssues = resp.Data.Updated.IssueCount
+		stats.closedIssues = resp.Data.Closed.IssueCount
+	}
+	for _, release := range r.Releases.Nodes {
+		stats.releases = append(stats.releases, release.CreatedAt)
+	}
+
+	return stats, nil
+}
+
+// updatedSince returns the number of months since the last default-branch commit, or
+// ErrNoCommits for an empty repository.
+func (s *graphQLStats) updatedSince() (int, error) {
+	if s.lastCommit == nil {
+		return 0, ErrNoCommits
+	}
+	difference := time.Since(*s.lastCommit)
+	return int(math.Round(difference.Hours() / 24.0 / 30.0)), nil
+}
+
+// commitFrequency returns the weekly average number of default-branch commits over the
+// last 52 weeks, the same as the participation source.
+func (s *graphQLStats) commitFrequency() float64 {
+	return math.Round(float64(s.recentCommits)/52.0*10.0) / 10
+}
+
+// recentReleases returns the number of releases within the release lookback days, like
+// GitHubRepository.RecentReleasesEstimate, and false if the first page of releases isn't
+// enough to tell.
+func (s *graphQLStats) recentReleases(ghr GitHubRepository) (int, bool, bool) {
+
+	total := 0
+	for _, createdAt := range s.releases {
+		if time.Since(createdAt).Hours()/24.0 > ghr.config.ReleaseLookbackDays {
+			continue
+		}
+		total++
+	}
+
+	if total == len(s.releases) && s.moreReleases {
+		return 0, false, false
+	}
+	if total != 0 {
+		return total, false, true
+	}
+
+	daysSinceCreation := int(time.Since(ghr.R.CreatedAt.Time).Hours() / 24.0)
+	if daysSinceCreation == 0 {
+		return 0, false, true
+	}
+
+	return int(math.Round(float64(s.tags) / float64(daysSinceCreation) * ghr.config.ReleaseLookbackDays)), true, true
+}
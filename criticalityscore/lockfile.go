@@ -0,0 +1,272 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnsupportedLockfile error = fmt.Errorf("unsupported lockfile")
+	ErrTimedOut            error = fmt.Errorf("timed out")
+)
+
+// Dependency is a package listed in a lockfile and the GitHub repository it resolves
+// to. RepoURL is empty if the package couldn't be resolved.
+type Dependency struct {
+	Name    string
+	RepoURL string
+}
+
+// DependencyResolver reads the dependencies of one ecosystem from a lockfile and
+// resolves them to GitHub repositories.
+type DependencyResolver interface {
+	Resolve(content []byte) ([]Dependency, error)
+}
+
+// DependencyResolvers maps lockfile names to the resolver for their ecosystem.
+var DependencyResolvers = map[string]DependencyResolver{
+	"go.mod": GoModResolver{},
+	"go.sum": GoModResolver{},
+}
+
+// GoModResolver resolves Go modules from a go.mod or go.sum file. Modules hosted on
+// github.com resolve to their repository, and modules of well-known vanity import
+// paths (e.g. golang.org/x) to the GitHub repository behind them.
+type GoModResolver struct{}
+
+// goVanityPrefixes maps module path prefixes to the GitHub org or repository they are
+// hosted in. A prefix ending with a slash maps the next path element to a repository
+// of the org.
+var goVanityPrefixes = map[string]string{
+	"golang.org/x/":               "golang",
+	"go.uber.org/":                "uber-go",
+	"k8s.io/":                     "kubernetes",
+	"sigs.k8s.io/":                "kubernetes-sigs",
+	"go.opentelemetry.io/otel":    "open-telemetry/opentelemetry-go",
+	"google.golang.org/grpc":      "grpc/grpc-go",
+	"google.golang.org/protobuf":  "protocolbuffers/protobuf-go",
+	"google.golang.org/api":       "googleapis/google-api-go-client",
+	"google.golang.org/genproto":  "googleapis/go-genproto",
+	"google.golang.org/appengine": "golang/appengine",
+	"cloud.google.com/go":         "googleapis/google-cloud-go",
+	"go.opencensus.io":            "census-instrumentation/opencensus-go",
+}
+
+// Resolve implements DependencyResolver.
+func (GoModResolver) Resolve(content []byte) ([]Dependency, error) {
+
+	seen := make(map[string]bool)
+	var dependencies []Dependency
+
+	inRequire := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		var module string
+		switch {
+		case line == "require (":
+			inRequire = true
+			continue
+		case inRequire && line == ")":
+			inRequire = false
+			continue
+		case inRequire:
+			module = firstField(line)
+		case strings.HasPrefix(line, "require "):
+			module = firstField(strings.TrimPrefix(line, "require "))
+		case strings.Count(line, " ") == 2 && strings.Contains(line, " h1:"):
+			// go.sum lines are "module version hash".
+			module = firstField(line)
+		}
+
+		if module == "" || seen[module] {
+			continue
+		}
+		seen[module] = true
+		dependencies = append(dependencies, Dependency{Name: module, RepoURL: goModuleRepoURL(module)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return dependencies, nil
+}
+
+// goModuleRepoURL returns the GitHub repository url of a Go module, or an empty
+// string if the module isn't known to be hosted on GitHub.
+func goModuleRepoURL(module string) string {
+
+	if strings.HasPrefix(module, "github.com/") {
+		parts := strings.Split(module, "/")
+		if len(parts) < 3 {
+			return ""
+		}
+		return fmt.Sprintf("https://github.com/%s/%s", parts[1], parts[2])
+	}
+
+	if strings.HasPrefix(module, "gopkg.in/") {
+		// gopkg.in/user/pkg.vN is hosted at github.com/user/pkg, gopkg.in/pkg.vN at
+		// github.com/go-pkg/pkg.
+		parts := strings.Split(strings.TrimPrefix(module, "gopkg.in/"), "/")
+		name := strings.Split(parts[len(parts)-1], ".v")[0]
+		if len(parts) == 2 {
+			return fmt.Sprintf("https://github.com/%s/%s", parts[0], name)
+		}
+		return fmt.Sprintf("https://github.com/go-%s/%s", name, name)
+	}
+
+	for prefix, target := range goVanityPrefixes {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(module, prefix) {
+			name := strings.Split(strings.TrimPrefix(module, prefix), "/")[0]
+			return fmt.Sprintf("https://github.com/%s/%s", target, name)
+		}
+		if module == prefix || strings.HasPrefix(module, prefix+"/") {
+			return "https://github.com/" + target
+		}
+	}
+
+	return ""
+}
+
+// LoadDependencies reads a lockfile and resolves its dependencies with the resolver
+// registered for its file name in DependencyResolvers.
+func LoadDependencies(path string) ([]Dependency, error) {
+
+	resolver, ok := DependencyResolvers[filepath.Base(path)]
+	if !ok {
+		return nil, fmt.Errorf("%w : %s", ErrUnsupportedLockfile, filepath.Base(path))
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolver.Resolve(content)
+}
+
+// ScoreDependencies scores the GitHub repository of each dependency once and returns
+// the scores sorted from most to least critical. Dependencies that couldn't be resolved
+// to a repository or fail to score are logged and skipped. If ctx is done before all
+// repositories are scored, it returns the scores so far and ErrTimedOut.
+func ScoreDependencies(ctx context.Context, dependencies []Dependency, token string, config ScoreConfig, params []string) ([]Score, error) {
+
+	var scores []Score
+	err := StreamDependencyScores(ctx, dependencies, token, config, params, func(score Score) {
+		scores = append(scores, score)
+	})
+
+	return sortScores(scores), err
+}
+
+// StreamDependencyScores scores the GitHub repository of each dependency once, with up
+// to config.Concurrency at a time, and calls emit with each score in lockfile order as
+// soon as all earlier ones are done. Dependencies that couldn't be resolved to a
+// repository or fail to score are logged and skipped. If ctx is done before all
+// repositories are scored, it returns ErrTimedOut.
+func StreamDependencyScores(ctx context.Context, dependencies []Dependency, token string, config ScoreConfig, params []string, emit func(Score)) error {
+
+	repos := dependencyRepositories(dependencies)
+	repoURLs := make([]string, len(repos))
+	for i, d := range repos {
+		repoURLs[i] = d.RepoURL
+	}
+
+	return ScoreRepositories(ctx, repoURLs, token, config, params, func(result ScoreResult) {
+		if result.Err != nil {
+			log.Printf("%s: %s\n", repos[result.Index].Name, result.Err.Error())
+			return
+		}
+		emit(result.Score)
+	})
+}
+
+// DependencyMetadata loads the GitHub repository of each dependency once and returns
+// its metadata, without scoring it. Dependencies that couldn't be resolved to a
+// repository or fail to load are logged and skipped. If ctx is done before all
+// repositories are loaded, it returns the metadata so far and ErrTimedOut.
+func DependencyMetadata(ctx context.Context, dependencies []Dependency, token string, config ScoreConfig) ([]RepositoryMetadata, error) {
+
+	repos := dependencyRepositories(dependencies)
+	var metadata []RepositoryMetadata
+
+	source, sourceErr := newBatchDataSource(ctx, token, config)
+
+	for i, d := range repos {
+		ghr, err := loadBatchRepository(ctx, d.RepoURL, source, sourceErr, config)
+		if ctx.Err() != nil {
+			return metadata, fmt.Errorf("%w with %d of %d repos loaded", ErrTimedOut, i, len(repos))
+		}
+		if err != nil {
+			log.Printf("%s: %s\n", d.Name, err.Error())
+			continue
+		}
+		metadata = append(metadata, ghr.Metadata())
+	}
+
+	return metadata, nil
+}
+
+// dependencyRepositories returns the first dependency resolved to each repository.
+// Dependencies that couldn't be resolved are logged.
+func dependencyRepositories(dependencies []Dependency) []Dependency {
+
+	seen := make(map[string]bool)
+	var repos []Dependency
+
+	for _, d := range dependencies {
+		if d.RepoURL == "" {
+			log.Printf("%s: no github repository found\n", d.Name)
+			continue
+		}
+		if seen[d.RepoURL] {
+			continue
+		}
+		seen[d.RepoURL] = true
+		repos = append(repos, d)
+	}
+
+	return repos
+}
+
+// sortScores sorts scores from most to least critical.
+func sortScores(scores []Score) []Score {
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].CriticalityScore > scores[j].CriticalityScore
+	})
+	return scores
+}
+
+func firstField(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,63 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamDependencyScores(t *testing.T) {
+
+	// Each repository responds later than the next, so they complete in reverse order.
+	config, _ := newBatchServer(t, func(name string) time.Duration {
+		var i int
+		fmt.Sscanf(name, "r%d", &i)
+		return time.Duration(5-i) * 20 * time.Millisecond
+	})
+	config.Concurrency = 4
+
+	repoURL := func(name string) string {
+		return fmt.Sprintf("https://%s/o/%s", config.GitHubHost(), name)
+	}
+	dependencies := []Dependency{
+		{Name: "zero", RepoURL: repoURL("r0")},
+		{Name: "unresolved"},
+		{Name: "one", RepoURL: repoURL("r1")},
+		{Name: "missing", RepoURL: repoURL("missing")},
+		{Name: "two", RepoURL: repoURL("r2")},
+		{Name: "one-again", RepoURL: repoURL("r1")},
+		{Name: "three", RepoURL: repoURL("r3")},
+	}
+
+	var names []string
+	err := StreamDependencyScores(context.Background(), dependencies, "tok", config, nil, func(score Score) {
+		names = append(names, score.Name)
+	})
+	if err != nil {
+		t.Fatalf("StreamDependencyScores() error = %v", err)
+	}
+
+	// Unresolved, failed and duplicate dependencies are skipped, and the rest are
+	// emitted in lockfile order.
+	want := "r0,r1,r2,r3"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("emitted %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// RepositoryMetadata is a curated subset of the github.Repository a score was computed
+// from. Token-specific fields like permissions are never included, and for private
+// repositories the free-text fields (description, homepage and topics) are left out.
+type RepositoryMetadata struct {
+	ID              int64     `json:"id"`
+	FullName        string    `json:"full_name"`
+	HTMLURL         string    `json:"html_url"`
+	Language        string    `json:"language,omitempty"`
+	Description     string    `json:"description,omitempty"`
+	Homepage        string    `json:"homepage,omitempty"`
+	Topics          []string  `json:"topics,omitempty"`
+	DefaultBranch   string    `json:"default_branch"`
+	License         string    `json:"license,omitempty"`
+	Private         bool      `json:"private"`
+	Fork            bool      `json:"fork"`
+	Archived        bool      `json:"archived"`
+	StargazersCount int       `json:"stargazers_count"`
+	ForksCount      int       `json:"forks_count"`
+	WatchersCount   int       `json:"watchers_count"`
+	OpenIssuesCount int       `json:"open_issues_count"`
+	Size            int       `json:"size"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	PushedAt        time.Time `json:"pushed_at"`
+}
+
+// newRepositoryMetadata returns the RepositoryMetadata of a github.Repository.
+func newRepositoryMetadata(r *github.Repository) *RepositoryMetadata {
+
+	m := &RepositoryMetadata{
+		ID:              r.GetID(),
+		FullName:        r.GetFullName(),
+		HTMLURL:         r.GetHTMLURL(),
+		Language:        r.GetLanguage(),
+		DefaultBranch:   r.GetDefaultBranch(),
+		License:         r.GetLicense().GetSPDXID(),
+		Private:         r.GetPrivate(),
+		Fork:            r.GetFork(),
+		Archived:        r.GetArchived(),
+		StargazersCount: r.GetStargazersCount(),
+		ForksCount:      r.GetForksCount(),
+		WatchersCount:   r.GetWatchersCount(),
+		OpenIssuesCount: r.GetOpenIssuesCount(),
+		Size:            r.GetSize(),
+		CreatedAt:       r.GetCreatedAt().Time,
+		UpdatedAt:       r.GetUpdatedAt().Time,
+		PushedAt:        r.GetPushedAt().Time,
+	}
+
+	if !m.Private {
+		m.Description = r.GetDescription()
+		m.Homepage = r.GetHomepage()
+		m.Topics = r.Topics
+	}
+
+	return m
+}
+
+// Metadata returns the RepositoryMetadata of the loaded repository. Unlike
+// RepositoryStats, it makes no further API calls.
+func (ghr GitHubRepository) Metadata() RepositoryMetadata {
+	return *newRepositoryMetadata(ghr.R)
+}
+
+// PrintMetadata outputs repository metadata in one of the OutputFormats.
+func PrintMetadata(m RepositoryMetadata, format string) {
+	WriteMetadata(os.Stdout, m, format)
+}
+
+// WriteMetadata writes repository metadata in one of the OutputFormats to w.
+func WriteMetadata(w io.Writer, m RepositoryMetadata, format string) {
+
+	if format == "github-summary" {
+		fmt.Fprintf(w, "### Repository metadata for %s\n\n", m.FullName)
+		writeMarkdownTable(w, m)
+		fmt.Fprintln(w)
+		return
+	}
+
+	if format == "openssf" {
+		format = "json"
+	}
+	if format == "csv-table" {
+		format = "csv"
+	}
+
+	writeRecord(w, m, format)
+}
+
+// PrintMetadataJSONArray outputs repository metadata as a single json array, even if
+// there's only one repository.
+func PrintMetadataJSONArray(metadata []RepositoryMetadata) {
+	WriteMetadataJSONArray(os.Stdout, metadata)
+}
+
+// WriteMetadataJSONArray writes repository metadata as a single json array to w.
+func WriteMetadataJSONArray(w io.Writer, metadata []RepositoryMetadata) {
+	if metadata == nil {
+		metadata = []RepositoryMetadata{}
+	}
+	writeJSON(w, metadata)
+}
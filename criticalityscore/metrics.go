@@ -0,0 +1,238 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownMetric error = fmt.Errorf("unknown metric")
+)
+
+// Metric directions.
+const (
+	DirectionHigher = "higher"
+	DirectionLower  = "lower"
+)
+
+// MetricDescriptor describes a metric of the criticality score. Direction is the
+// direction of the metric's value that raises the score, and Optional metrics are
+// only collected when enabled in the ScoreConfig.
+type MetricDescriptor struct {
+	Name        string  `json:"name"`
+	Unit        string  `json:"unit"`
+	Weight      float64 `json:"weight"`
+	Threshold   float64 `json:"threshold"`
+	Direction   string  `json:"direction"`
+	Optional    bool    `json:"optional"`
+	Description string  `json:"description"`
+}
+
+// MetricDescriptors returns a descriptor of each metric, with the weights and max
+// thresholds of the config.
+func (config ScoreConfig) MetricDescriptors() []MetricDescriptor {
+
+	w := config.Weights
+	t := config.Thresholds
+
+	descriptors := []MetricDescriptor{
+		{Name: "created_since", Unit: "months", Weight: w.CreatedSince, Threshold: t.CreatedSince,
+			Description: "time since the repository was created"},
+		{Name: "updated_since", Unit: "months", Weight: w.UpdatedSince, Threshold: t.UpdatedSince,
+			Description: "time since the last commit"},
+		{Name: "contributor_count", Unit: "contributors", Weight: w.ContributorCount, Threshold: t.ContributorCount,
+			Description: "number of contributors with commits"},
+		{Name: "org_count", Unit: "organizations", Weight: w.OrgCount, Threshold: t.OrgCount,
+			Description: "number of distinct organizations of the top contributors"},
+		{Name: "commit_frequency", Unit: "commits per week", Weight: w.CommitFrequency, Threshold: t.CommitFrequency,
+			Description: "average number of commits per week over the last year"},
+		{Name: "recent_releases_count", Unit: "releases", Weight: w.RecentReleases, Threshold: t.RecentReleases,
+			Description: fmt.Sprintf("number of releases in the last %0.0f days", config.ReleaseLookbackDays)},
+		{Name: "closed_issues_count", Unit: "issues", Weight: w.ClosedIssues, Threshold: t.ClosedIssues,
+			Description: fmt.Sprintf("number of issues closed in the last %0.0f days", config.IssueLookbackDays)},
+		{Name: "updated_issues_count", Unit: "issues", Weight: w.UpdatedIssues, Threshold: t.UpdatedIssues,
+			Description: fmt.Sprintf("number of issues updated in the last %0.0f days", config.IssueLookbackDays)},
+		{Name: "comment_frequency", Unit: "comments per issue", Weight: w.CommentFrequency, Threshold: t.CommentFrequency,
+			Description: fmt.Sprintf("average number of comments per issue updated in the last %0.0f days", config.IssueLookbackDays)},
+		{Name: "dependents_count", Unit: dependentsUnit(config), Weight: w.DependentsCount, Threshold: t.DependentsCount,
+			Description: dependentsDescription(config)},
+		{Name: "stars_count", Unit: "stars", Weight: w.StarsCount, Threshold: t.StarsCount,
+			Description: "number of stars"},
+		{Name: "forks_count", Unit: "forks", Weight: w.ForksCount, Threshold: t.ForksCount,
+			Description: "number of forks"},
+		{Name: "watchers_count", Unit: "watchers", Weight: w.WatchersCount, Threshold: t.WatchersCount,
+			Description: "number of users watching the repository"},
+		{Name: "churn_files_count", Unit: "files", Weight: w.ChurnFilesCount, Threshold: t.ChurnFilesCount, Optional: true,
+			Description: fmt.Sprintf("number of distinct files changed by the last %d commits of the past %0.0f days", ChurnCommitLimit, ChurnLookbackDays)},
+		{Name: "star_growth", Unit: "stars per 30 days", Weight: w.StarGrowth, Threshold: t.StarGrowth, Optional: true,
+			Description: fmt.Sprintf("number of new stars per 30 days over the past %0.0f days", StarGrowthLookbackDays)},
+		{Name: "release_contributors_count", Unit: "contributors", Weight: w.ReleaseContributors, Threshold: t.ReleaseContributors, Optional: true,
+			Description: "number of distinct commit authors between the two most recent releases"},
+		{Name: "recent_commit_authors_count", Unit: "authors", Weight: w.CommitAuthors, Threshold: t.CommitAuthors, Optional: true,
+			Description: fmt.Sprintf("number of distinct authors of the commits of the past %0.0f days", AuthorLookbackDays)},
+		{Name: "bus_factor", Unit: "authors", Weight: w.BusFactor, Threshold: t.BusFactor, Optional: true,
+			Description: fmt.Sprintf("fewest authors of half of the commits of the past %0.0f days", AuthorLookbackDays)},
+		{Name: "open_pull_requests_count", Unit: "pull requests", Weight: w.OpenPullRequests, Threshold: t.OpenPullRequests, Optional: true,
+			Description: "number of open pull requests"},
+		{Name: "merged_pull_requests_count", Unit: "pull requests", Weight: w.MergedPullRequests, Threshold: t.MergedPullRequests, Optional: true,
+			Description: fmt.Sprintf("number of pull requests merged in the last %0.0f days", config.IssueLookbackDays)},
+		{Name: "pull_request_merge_rate", Unit: "fraction of pull requests", Weight: w.PullRequestMerges, Threshold: t.PullRequestMerges, Optional: true,
+			Description: fmt.Sprintf("fraction of the pull requests closed in the last %0.0f days that were merged", config.IssueLookbackDays)},
+		{Name: "signed_commits_ratio", Unit: "fraction of commits", Weight: w.SignedCommits, Threshold: t.SignedCommits, Optional: true,
+			Description: fmt.Sprintf("fraction of the last %d commits with a verified signature", SignedCommitSampleSize)},
+		{Name: "wiki_enabled", Unit: "boolean", Weight: w.WikiEnabled, Threshold: 1, Optional: true,
+			Description: "whether the repository wiki is enabled"},
+		{Name: "discussions_enabled", Unit: "boolean", Weight: w.DiscussionsEnabled, Threshold: 1, Optional: true,
+			Description: "whether github discussions are enabled"},
+		{Name: "funded", Unit: "boolean", Weight: w.Funded, Threshold: 1, Optional: true,
+			Description: "whether the repository or its owner has a FUNDING.yml with a funding platform"},
+		{Name: "licensed", Unit: "boolean", Weight: w.Licensed, Threshold: 1, Optional: true,
+			Description: "whether github recognized the license of the repository"},
+		{Name: "security_policy", Unit: "boolean", Weight: w.SecurityPolicy, Threshold: 1, Optional: true,
+			Description: "whether the repository has a SECURITY.md security policy"},
+		{Name: "ci_configured", Unit: "boolean", Weight: w.CI, Threshold: 1, Optional: true,
+			Description: "whether the repository has github actions, travis ci, circleci or jenkins configured"},
+	}
+
+	for i := range descriptors {
+		descriptors[i].Direction = DirectionHigher
+		if descriptors[i].Weight < 0 {
+			descriptors[i].Direction = DirectionLower
+		}
+	}
+
+	return descriptors
+}
+
+// dependentsUnit returns the unit of the dependents count of the configured method.
+func dependentsUnit(config ScoreConfig) string {
+	if config.DependentsMethod == DependentsDependencyGraph {
+		return "repositories"
+	}
+	return "commits"
+}
+
+// dependentsDescription returns the description of the dependents count of the
+// configured method.
+func dependentsDescription(config ScoreConfig) string {
+	if config.DependentsMethod == DependentsDependencyGraph {
+		return "number of repositories depending on the repository in github's dependency graph"
+	}
+	return "number of commits mentioning the repository in github search"
+}
+
+// PrintMetricDescriptors outputs metric descriptors in one of the OutputFormats. The
+// json format outputs a json array, and the csv and markdown formats a table with a
+// row per metric.
+func PrintMetricDescriptors(descriptors []MetricDescriptor, format string) {
+	WriteMetricDescriptors(os.Stdout, descriptors, format)
+}
+
+// WriteMetricDescriptors writes metric descriptors to w in one of the OutputFormats, like
+// PrintMetricDescriptors.
+func WriteMetricDescriptors(out io.Writer, descriptors []MetricDescriptor, format string) {
+
+	switch format {
+	case "json":
+		if descriptors == nil {
+			descriptors = []MetricDescriptor{}
+		}
+		writeJSON(out, descriptors)
+	case "csv", "csv-table":
+		w := csv.NewWriter(out)
+		w.Write([]string{"name", "unit", "weight", "threshold", "direction", "optional", "description"})
+		for _, d := range descriptors {
+			w.Write([]string{d.Name, d.Unit, strconv.FormatFloat(d.Weight, 'f', -1, 64), strconv.FormatFloat(d.Threshold, 'f', -1, 64), d.Direction, strconv.FormatBool(d.Optional), d.Description})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Println(err.Error())
+		}
+	case "markdown", "github-summary":
+		fmt.Fprintln(out, "| metric | unit | weight | threshold | direction | optional | description |")
+		fmt.Fprintln(out, "| --- | --- | --- | --- | --- | --- | --- |")
+		for _, d := range descriptors {
+			fmt.Fprintf(out, "| %s | %s | %v | %v | %s | %t | %s |\n", d.Name, d.Unit, d.Weight, d.Threshold, d.Direction, d.Optional, d.Description)
+		}
+	default:
+		for _, d := range descriptors {
+			optional := ""
+			if d.Optional {
+				optional = ", optional"
+			}
+			fmt.Fprintf(out, "%s: %s (%s, weight %v, threshold %v, %s is more critical%s)\n", d.Name, d.Description, d.Unit, d.Weight, d.Threshold, d.Direction, optional)
+		}
+	}
+}
+
+// metricEnabled returns whether a metric is collected: with Metrics configured, only
+// the metrics among them are, and otherwise all are.
+func (config ScoreConfig) metricEnabled(name string) bool {
+	return len(config.Metrics) == 0 || contains(config.Metrics, name)
+}
+
+// withAllMetrics returns a copy of the config that collects every metric, including all
+// opt-in metrics, without short-circuiting.
+func (config ScoreConfig) withAllMetrics() ScoreConfig {
+	config.Metrics = nil
+	config.ShortCircuit = false
+	config.CommunitySignals = true
+	config.Churn = true
+	config.StarGrowth = true
+	config.ReleaseContributors = true
+	config.CommitAuthors = true
+	config.PullRequests = true
+	config.SignedCommits = true
+	config.Funding = true
+	config.License = true
+	config.SecurityPolicy = true
+	config.CI = true
+	config.Scorecard = true
+	return config
+}
+
+// skippedMetrics returns the metrics that aren't optional and aren't among the
+// configured Metrics.
+func (config ScoreConfig) skippedMetrics() []string {
+	var skipped []string
+	for _, d := range config.MetricDescriptors() {
+		if !d.Optional && !config.metricEnabled(d.Name) {
+			skipped = append(skipped, d.Name)
+		}
+	}
+	return skipped
+}
+
+// ValidateMetrics returns ErrUnknownMetric if any of metrics isn't the name of a metric.
+func ValidateMetrics(metrics []string) error {
+	var known []string
+	for _, d := range DefaultScoreConfig().MetricDescriptors() {
+		known = append(known, d.Name)
+	}
+	for _, name := range metrics {
+		if !contains(known, name) {
+			return fmt.Errorf("%w : %s, available metrics are [%s]", ErrUnknownMetric, name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
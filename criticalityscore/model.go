@@ -0,0 +1,370 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"fmt"
+	"math"
+)
+
+// ScoringModel combines the collected metrics and any additional params into a
+// single criticality score, using the weights and max thresholds in config.
+type ScoringModel interface {
+	Score(metrics Score, params []AdditionalParam, config ScoreConfig) float64
+}
+
+// DefaultModel is the scoring model used when none is configured.
+var DefaultModel ScoringModel = OpenSSFModel{}
+
+// ScoringModels maps model names, as used by the --model flag, to scoring models.
+var ScoringModels = map[string]ScoringModel{
+	"openssf": OpenSSFModel{},
+	"linear":  LinearModel{},
+	"geomean": GeometricMeanModel{},
+}
+
+// OpenSSFModel is the OpenSSF criticality score: the weighted sum of each metric's
+// log-scaled ratio to its max threshold, divided by the total weight.
+type OpenSSFModel struct{}
+
+// Score implements ScoringModel.
+func (OpenSSFModel) Score(metrics Score, params []AdditionalParam, config ScoreConfig) float64 {
+	totalWeight := 0.0
+	total := 0.0
+	for _, t := range metricTerms(metrics, params, config) {
+		totalWeight += t.Weight
+		total += t.Capped(ParamScore(t.Value, t.Threshold, 1)) * t.Weight
+	}
+	if totalWeight == 0 {
+		return 0
+	}
+	return total / totalWeight
+}
+
+// LinearModel is the weighted sum of each metric's linear ratio to its max threshold,
+// capped at 1, divided by the total weight.
+type LinearModel struct{}
+
+// Score implements ScoringModel.
+func (LinearModel) Score(metrics Score, params []AdditionalParam, config ScoreConfig) float64 {
+	totalWeight := 0.0
+	total := 0.0
+	for _, t := range metricTerms(metrics, params, config) {
+		totalWeight += t.Weight
+		if t.Threshold > 0 {
+			total += t.Capped(math.Min(math.Max(t.Value, 0)/t.Threshold, 1)) * t.Weight
+		}
+	}
+	if totalWeight == 0 {
+		return 0
+	}
+	return total / totalWeight
+}
+
+// GeometricMeanModel is the weighted geometric mean of each metric's log-scaled ratio
+// to its max threshold, so a repository that is weak on any single metric scores low.
+// Metrics with a negative weight (e.g. UpdatedSince) are inverted, using one minus
+// their ratio with the absolute weight. Ratios are floored at GeometricMeanFloor so a
+// single zero metric doesn't force the whole score to zero.
+type GeometricMeanModel struct{}
+
+// Score implements ScoringModel.
+func (GeometricMeanModel) Score(metrics Score, params []AdditionalParam, config ScoreConfig) float64 {
+	totalWeight := 0.0
+	total := 0.0
+	for _, t := range metricTerms(metrics, params, config) {
+		ratio := t.Capped(ParamScore(t.Value, t.Threshold, 1))
+		weight := t.Weight
+		if weight < 0 {
+			ratio = 1 - ratio
+			weight = -weight
+		}
+		totalWeight += weight
+		total += weight * math.Log(math.Max(ratio, GeometricMeanFloor))
+	}
+	if totalWeight == 0 {
+		return 0
+	}
+	return math.Exp(total / totalWeight)
+}
+
+// ScoreRanger is implemented by scoring models whose scores don't range from 0 to 1,
+// e.g. because negative weights shift the range. ScoreRange returns the lowest and
+// highest possible score for the metrics available for scoring. Models that don't
+// implement it are assumed to range from 0 to 1.
+type ScoreRanger interface {
+	ScoreRange(metrics Score, params []AdditionalParam, config ScoreConfig) (float64, float64)
+}
+
+// ScoreRange implements ScoreRanger.
+func (OpenSSFModel) ScoreRange(metrics Score, params []AdditionalParam, config ScoreConfig) (float64, float64) {
+	return weightedRange(metricTerms(metrics, params, config))
+}
+
+// ScoreRange implements ScoreRanger.
+func (LinearModel) ScoreRange(metrics Score, params []AdditionalParam, config ScoreConfig) (float64, float64) {
+	return weightedRange(metricTerms(metrics, params, config))
+}
+
+// ScoreRange implements ScoreRanger. The lowest score is GeometricMeanFloor unless
+// metrics with a negative weight are capped, since their inverted ratio is then at least
+// one minus the cap, and the highest is below 1 only if metrics with a positive weight
+// are capped.
+func (GeometricMeanModel) ScoreRange(metrics Score, params []AdditionalParam, config ScoreConfig) (float64, float64) {
+	totalWeight := 0.0
+	lo, hi := 0.0, 0.0
+	for _, t := range metricTerms(metrics, params, config) {
+		if t.Weight > 0 {
+			totalWeight += t.Weight
+			lo += t.Weight * math.Log(GeometricMeanFloor)
+			hi += t.Weight * math.Log(math.Max(t.Capped(1), GeometricMeanFloor))
+		} else if t.Weight < 0 {
+			totalWeight -= t.Weight
+			lo -= t.Weight * math.Log(math.Max(1-t.Capped(1), GeometricMeanFloor))
+		}
+	}
+	if totalWeight == 0 {
+		return 0, 1
+	}
+	return math.Exp(lo / totalWeight), math.Exp(hi / totalWeight)
+}
+
+// weightedRange returns the range of a weighted sum of normalized metric values divided
+// by the total weight. A metric with a negative weight lowers the minimum when its value
+// is at its max (or cap), and a metric with a positive weight raises the maximum.
+func weightedRange(terms []MetricValue) (float64, float64) {
+	totalWeight := 0.0
+	lo, hi := 0.0, 0.0
+	for _, t := range terms {
+		totalWeight += t.Weight
+		if t.Weight < 0 {
+			lo += t.Capped(1) * t.Weight
+		} else {
+			hi += t.Capped(1) * t.Weight
+		}
+	}
+	if totalWeight == 0 {
+		return 0, 1
+	}
+	lo, hi = lo/totalWeight, hi/totalWeight
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return lo, hi
+}
+
+// ScaleScore rescales a score returned by model to config.Scale. Unit and percent
+// scores are clamped to 0-1 and 0-100.
+func ScaleScore(raw float64, model ScoringModel, metrics Score, params []AdditionalParam, config ScoreConfig) float64 {
+
+	if config.Scale == "" || config.Scale == ScaleRaw {
+		return raw
+	}
+
+	lo, hi := 0.0, 1.0
+	if ranger, ok := model.(ScoreRanger); ok {
+		lo, hi = ranger.ScoreRange(metrics, params, config)
+	}
+
+	unit := 0.0
+	if hi > lo {
+		unit = math.Min(math.Max((raw-lo)/(hi-lo), 0), 1)
+	}
+
+	if config.Scale == ScalePercent {
+		return unit * 100
+	}
+	return unit
+}
+
+// ParamScores returns the ParamScore of each metric available for scoring, keyed by its
+// json name. Additional params are keyed as param_1, param_2 and so on.
+func ParamScores(metrics Score, params []AdditionalParam, config ScoreConfig) map[string]float64 {
+	paramScores := make(map[string]float64)
+	for _, t := range metricTerms(metrics, params, config) {
+		paramScores[t.Name] = t.Capped(ParamScore(t.Value, t.Threshold, 1)) * t.Weight
+	}
+	return paramScores
+}
+
+// MetricContribution is how a metric contributed to the criticality score: its value,
+// weight and max threshold, its ParamScore, i.e. the log-scaled ratio of the value to
+// the threshold (capped if configured), and its contribution, the ParamScore times the
+// weight divided by the total weight. With the OpenSSF model, the contributions add up
+// to the raw criticality score.
+type MetricContribution struct {
+	Value        float64 `json:"value"`
+	Weight       float64 `json:"weight"`
+	Threshold    float64 `json:"threshold"`
+	ParamScore   float64 `json:"param_score"`
+	Contribution float64 `json:"contribution"`
+}
+
+// Explain returns the contribution of each metric available for scoring, keyed by its
+// json name like ParamScores.
+func Explain(metrics Score, params []AdditionalParam, config ScoreConfig) map[string]MetricContribution {
+
+	terms := metricTerms(metrics, params, config)
+
+	totalWeight := 0.0
+	for _, t := range terms {
+		totalWeight += t.Weight
+	}
+
+	explanation := make(map[string]MetricContribution)
+	for _, t := range terms {
+		paramScore := t.Capped(ParamScore(t.Value, t.Threshold, 1))
+		contribution := 0.0
+		if totalWeight != 0 {
+			contribution = paramScore * t.Weight / totalWeight
+		}
+		explanation[t.Name] = MetricContribution{
+			Value:        t.Value,
+			Weight:       t.Weight,
+			Threshold:    t.Threshold,
+			ParamScore:   math.Round(paramScore*100000) / 100000,
+			Contribution: math.Round(contribution*100000) / 100000,
+		}
+	}
+	return explanation
+}
+
+// MetricValue is a metric available for scoring, as passed to a scoring model: its
+// value, max threshold and weight from the config, and the cap of its normalized value,
+// which is 0 if it isn't capped.
+type MetricValue struct {
+	Name      string
+	Value     float64
+	Threshold float64
+	Weight    float64
+	Cap       float64
+}
+
+// Capped clamps a normalized metric value to the metric's cap, if it has one.
+func (v MetricValue) Capped(ratio float64) float64 {
+	if v.Cap > 0 {
+		return math.Min(ratio, v.Cap)
+	}
+	return ratio
+}
+
+// MetricValues returns the metrics available for scoring, as the built-in models score
+// them: issue metrics are left out when issues are disabled, metrics marked as
+// unavailable are left out, optional metrics only count when they were collected, and
+// additional params follow as param_1, param_2 and so on. A custom ScoringModel can
+// aggregate them without picking the metrics out of the Score itself.
+func MetricValues(metrics Score, params []AdditionalParam, config ScoreConfig) []MetricValue {
+	return metricTerms(metrics, params, config)
+}
+
+// metricTerms returns the metrics available for scoring, with their configured weights,
+// max thresholds and caps. Issue metrics are left out when issues are disabled, metrics marked
+// as unavailable are left out, and optional metrics only count when they were collected.
+func metricTerms(metrics Score, params []AdditionalParam, config ScoreConfig) []MetricValue {
+
+	w := config.Weights
+	t := config.Thresholds
+
+	c := config.Caps
+
+	terms := []MetricValue{
+		{"created_since", float64(metrics.CreatedSince), t.CreatedSince, w.CreatedSince, c.CreatedSince},
+		{"updated_since", float64(metrics.UpdatedSince), t.UpdatedSince, w.UpdatedSince, c.UpdatedSince},
+		{"contributor_count", float64(metrics.ContributorCount), t.ContributorCount, w.ContributorCount, c.ContributorCount},
+		{"org_count", float64(metrics.OrgCount), t.OrgCount, w.OrgCount, c.OrgCount},
+		{"commit_frequency", metrics.CommitFrequency, t.CommitFrequency, w.CommitFrequency, c.CommitFrequency},
+		{"recent_releases_count", float64(metrics.RecentReleasesCount), t.RecentReleases, w.RecentReleases, c.RecentReleases},
+		{"dependents_count", float64(metrics.DependentsCount), t.DependentsCount, w.DependentsCount, c.DependentsCount},
+		{"stars_count", float64(metrics.StarsCount), t.StarsCount, w.StarsCount, c.StarsCount},
+		{"forks_count", float64(metrics.ForksCount), t.ForksCount, w.ForksCount, c.ForksCount},
+		{"watchers_count", float64(metrics.WatchersCount), t.WatchersCount, w.WatchersCount, c.WatchersCount},
+	}
+
+	if metrics.IssuesEnabled {
+		terms = append(terms,
+			MetricValue{"closed_issues_count", float64(metrics.ClosedIssuesCount), t.ClosedIssues, w.ClosedIssues, c.ClosedIssues},
+			MetricValue{"updated_issues_count", float64(metrics.UpdatedIssuesCount), t.UpdatedIssues, w.UpdatedIssues, c.UpdatedIssues},
+			MetricValue{"comment_frequency", metrics.CommentFrequency, t.CommentFrequency, w.CommentFrequency, c.CommentFrequency},
+		)
+	}
+
+	if metrics.ChurnFilesCount != nil {
+		terms = append(terms, MetricValue{"churn_files_count", float64(*metrics.ChurnFilesCount), t.ChurnFilesCount, w.ChurnFilesCount, c.ChurnFilesCount})
+	}
+	if metrics.StarGrowth != nil {
+		terms = append(terms, MetricValue{"star_growth", *metrics.StarGrowth, t.StarGrowth, w.StarGrowth, c.StarGrowth})
+	}
+	if metrics.ReleaseContributors != nil {
+		terms = append(terms, MetricValue{"release_contributors_count", float64(*metrics.ReleaseContributors), t.ReleaseContributors, w.ReleaseContributors, c.ReleaseContributors})
+	}
+	if metrics.CommitAuthors != nil {
+		terms = append(terms, MetricValue{"recent_commit_authors_count", float64(*metrics.CommitAuthors), t.CommitAuthors, w.CommitAuthors, c.CommitAuthors})
+	}
+	if metrics.BusFactor != nil {
+		terms = append(terms, MetricValue{"bus_factor", float64(*metrics.BusFactor), t.BusFactor, w.BusFactor, c.BusFactor})
+	}
+	if metrics.OpenPullRequests != nil {
+		terms = append(terms, MetricValue{"open_pull_requests_count", float64(*metrics.OpenPullRequests), t.OpenPullRequests, w.OpenPullRequests, c.OpenPullRequests})
+	}
+	if metrics.MergedPullRequests != nil {
+		terms = append(terms, MetricValue{"merged_pull_requests_count", float64(*metrics.MergedPullRequests), t.MergedPullRequests, w.MergedPullRequests, c.MergedPullRequests})
+	}
+	if metrics.PullRequestMerges != nil {
+		terms = append(terms, MetricValue{"pull_request_merge_rate", *metrics.PullRequestMerges, t.PullRequestMerges, w.PullRequestMerges, c.PullRequestMerges})
+	}
+	if metrics.SignedCommitsRatio != nil {
+		terms = append(terms, MetricValue{"signed_commits_ratio", *metrics.SignedCommitsRatio, t.SignedCommits, w.SignedCommits, c.SignedCommits})
+	}
+	if metrics.WikiEnabled != nil {
+		terms = append(terms, MetricValue{"wiki_enabled", boolValue(*metrics.WikiEnabled), 1, w.WikiEnabled, c.WikiEnabled})
+	}
+	if metrics.DiscussionsEnabled != nil {
+		terms = append(terms, MetricValue{"discussions_enabled", boolValue(*metrics.DiscussionsEnabled), 1, w.DiscussionsEnabled, c.DiscussionsEnabled})
+	}
+
+	if metrics.Funded != nil {
+		terms = append(terms, MetricValue{"funded", boolValue(*metrics.Funded), 1, w.Funded, c.Funded})
+	}
+	if metrics.Licensed != nil {
+		terms = append(terms, MetricValue{"licensed", boolValue(*metrics.Licensed), 1, w.Licensed, c.Licensed})
+	}
+	if metrics.SecurityPolicy != nil {
+		terms = append(terms, MetricValue{"security_policy", boolValue(*metrics.SecurityPolicy), 1, w.SecurityPolicy, c.SecurityPolicy})
+	}
+	if metrics.CI != nil {
+		terms = append(terms, MetricValue{"ci_configured", boolValue(*metrics.CI), 1, w.CI, c.CI})
+	}
+
+	available := terms[:0]
+	for _, term := range terms {
+		if !contains(metrics.Unavailable, term.Name) {
+			available = append(available, term)
+		}
+	}
+
+	for i, param := range params {
+		available = append(available, MetricValue{fmt.Sprintf("param_%d", i+1), param.Value, param.MaxThreshold, param.Weight, 0})
+	}
+
+	return available
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"math"
+	"testing"
+)
+
+// twoMetricConfig scores only the contributor count and the commit frequency, with equal
+// weights and a max threshold of 100, and the updated since with a negative weight.
+func twoMetricConfig() ScoreConfig {
+	config := DefaultScoreConfig()
+	config.Weights = MetricParams{ContributorCount: 1, CommitFrequency: 1}
+	config.Thresholds = MetricParams{ContributorCount: 100, CommitFrequency: 100, UpdatedSince: 100}
+	return config
+}
+
+func TestGeometricMeanModel(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		contributors  int
+		frequency     float64
+		updatedWeight float64
+		updatedSince  int
+		want          float64
+	}{
+		{name: "all maxed", contributors: 100, frequency: 100, want: 1},
+		{name: "one metric zero", contributors: 100, frequency: 0, want: math.Sqrt(GeometricMeanFloor)},
+		{name: "all zero", want: GeometricMeanFloor},
+		{name: "negative weight inverted", contributors: 100, frequency: 100, updatedWeight: -1, updatedSince: 0, want: 1},
+		{name: "negative weight maxed", contributors: 100, frequency: 100, updatedWeight: -1, updatedSince: 100, want: math.Cbrt(GeometricMeanFloor)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := twoMetricConfig()
+			config.Weights.UpdatedSince = tt.updatedWeight
+			score := Score{ContributorCount: tt.contributors, CommitFrequency: tt.frequency, UpdatedSince: tt.updatedSince}
+
+			got := GeometricMeanModel{}.Score(score, nil, config)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeometricMeanModelPenalizesWeakMetrics(t *testing.T) {
+
+	config := twoMetricConfig()
+	score := Score{ContributorCount: 100, CommitFrequency: 1}
+
+	geomean := GeometricMeanModel{}.Score(score, nil, config)
+	openssf := OpenSSFModel{}.Score(score, nil, config)
+	if geomean >= openssf {
+		t.Errorf("geomean score %v, want below the openssf score %v for a repository weak on one metric", geomean, openssf)
+	}
+}
+
+func TestCaps(t *testing.T) {
+
+	config := twoMetricConfig()
+	config.Caps.ContributorCount = 0.5
+	score := Score{ContributorCount: 1000, CommitFrequency: 100}
+
+	for _, name := range []string{"openssf", "linear"} {
+		got := ScoringModels[name].Score(score, nil, config)
+		if math.Abs(got-0.75) > 1e-9 {
+			t.Errorf("%s Score() = %v, want 0.75 with the contributor count capped at 0.5", name, got)
+		}
+	}
+
+	config.Caps = MetricParams{}
+	if got := (OpenSSFModel{}).Score(score, nil, config); math.Abs(got-1) > 1e-9 {
+		t.Errorf("Score() = %v, want 1 uncapped", got)
+	}
+}
+
+func TestModelsWithoutWeights(t *testing.T) {
+
+	config := DefaultScoreConfig()
+	config.Weights = MetricParams{}
+
+	for name, model := range ScoringModels {
+		if got := model.Score(Score{ContributorCount: 10}, nil, config); got != 0 || math.IsNaN(got) {
+			t.Errorf("%s Score() = %v without any weights, want 0", name, got)
+		}
+	}
+}
+
+// scoreAt returns a Score with each default metric at a fraction of its threshold, and
+// the updated since, the default metric with a negative weight, at its own fraction.
+func scoreAt(th MetricParams, fraction, updated float64) Score {
+	return Score{
+		CreatedSince:        int(th.CreatedSince * fraction),
+		UpdatedSince:        int(th.UpdatedSince * updated),
+		ContributorCount:    int(th.ContributorCount * fraction),
+		OrgCount:            int(th.OrgCount * fraction),
+		CommitFrequency:     th.CommitFrequency * fraction,
+		RecentReleasesCount: int(th.RecentReleases * fraction),
+		DependentsCount:     int(th.DependentsCount * fraction),
+		StarsCount:          int(th.StarsCount * fraction),
+		ForksCount:          int(th.ForksCount * fraction),
+		WatchersCount:       int(th.WatchersCount * fraction),
+		IssuesEnabled:       true,
+		ClosedIssuesCount:   int(th.ClosedIssues * fraction),
+		UpdatedIssuesCount:  int(th.UpdatedIssues * fraction),
+		CommentFrequency:    th.CommentFrequency * fraction,
+	}
+}
+
+func TestScaleScore(t *testing.T) {
+
+	halfCaps := MetricParams{
+		CreatedSince: 0.5, UpdatedSince: 0.5, ContributorCount: 0.5, OrgCount: 0.5, CommitFrequency: 0.5,
+		RecentReleases: 0.5, DependentsCount: 0.5, StarsCount: 0.5, ForksCount: 0.5, WatchersCount: 0.5,
+		ClosedIssues: 0.5, UpdatedIssues: 0.5, CommentFrequency: 0.5,
+	}
+	negativeWeights := MetricParams{UpdatedSince: -1, CreatedSince: -2, ContributorCount: -1}
+
+	tests := []struct {
+		name     string
+		weights  *MetricParams
+		caps     MetricParams
+		fraction float64
+		updated  float64
+		want     float64 // the unit score, or -1 for any score in range
+	}{
+		{name: "all zero", want: -1},
+		{name: "all at threshold", fraction: 1, updated: 1, want: -1},
+		{name: "best", fraction: 1, want: 1},
+		{name: "worst", updated: 1, want: 0},
+		{name: "all above threshold", fraction: 10, updated: 10, want: -1},
+		{name: "capped all zero", caps: halfCaps, want: -1},
+		{name: "capped best", caps: halfCaps, fraction: 1, want: 1},
+		{name: "capped worst", caps: halfCaps, updated: 1, want: 0},
+		{name: "negative weights all zero", weights: &negativeWeights, want: -1},
+		{name: "negative weights all at threshold", weights: &negativeWeights, fraction: 1, updated: 1, want: -1},
+		{name: "negative weights capped", weights: &negativeWeights, caps: halfCaps, fraction: 1, updated: 1, want: -1},
+	}
+
+	for _, tt := range tests {
+		for _, name := range []string{"openssf", "linear", "geomean"} {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				model := ScoringModels[name]
+				config := DefaultScoreConfig()
+				if tt.weights != nil {
+					config.Weights = *tt.weights
+				}
+				config.Caps = tt.caps
+				metrics := scoreAt(config.Thresholds, tt.fraction, tt.updated)
+				raw := model.Score(metrics, nil, config)
+
+				for scale, max := range map[string]float64{ScaleUnit: 1, ScalePercent: 100} {
+					config.Scale = scale
+					got := ScaleScore(raw, model, metrics, nil, config)
+					if math.IsNaN(got) || got < 0 || got > max {
+						t.Errorf("%s ScaleScore(%v) = %v, want within 0-%v", scale, raw, got, max)
+					}
+					if tt.want >= 0 && math.Abs(got-tt.want*max) > 1e-9 {
+						t.Errorf("%s ScaleScore(%v) = %v, want %v", scale, raw, got, tt.want*max)
+					}
+				}
+
+				config.Scale = ScaleRaw
+				if got := ScaleScore(raw, model, metrics, nil, config); got != raw {
+					t.Errorf("raw ScaleScore(%v) = %v, want unchanged", raw, got)
+				}
+			})
+		}
+	}
+}
+
+func TestWeightedRange(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		terms  []MetricValue
+		lo, hi float64
+	}{
+		{name: "no terms", lo: 0, hi: 1},
+		{name: "zero total weight", terms: []MetricValue{{Weight: 1}, {Weight: -1}}, lo: 0, hi: 1},
+		{name: "positive weights", terms: []MetricValue{{Weight: 1}, {Weight: 3}}, lo: 0, hi: 1},
+		{name: "mixed weights", terms: []MetricValue{{Weight: 3}, {Weight: -1}}, lo: -0.5, hi: 1.5},
+		{name: "negative weights", terms: []MetricValue{{Weight: -1}, {Weight: -3}}, lo: 0, hi: 1},
+		{name: "capped", terms: []MetricValue{{Weight: 1, Cap: 0.5}, {Weight: 1}}, lo: 0, hi: 0.75},
+		{name: "capped negative weight", terms: []MetricValue{{Weight: 2}, {Weight: -1, Cap: 0.5}}, lo: -0.5, hi: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lo, hi := weightedRange(tt.terms)
+			if math.Abs(lo-tt.lo) > 1e-9 || math.Abs(hi-tt.hi) > 1e-9 {
+				t.Errorf("weightedRange() = %v, %v, want %v, %v", lo, hi, tt.lo, tt.hi)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// OpenSSFRecord is a score in the schema of the json output of the upstream OpenSSF
+// criticality_score tool, as loaded into its public datasets. Our metrics are the
+// upstream "legacy" signals, and the repo fields come from the repository metadata,
+// which is left out if the score was made without it.
+type OpenSSFRecord struct {
+	DefaultScore float64       `json:"default_score"`
+	Legacy       OpenSSFLegacy `json:"legacy"`
+	Repo         OpenSSFRepo   `json:"repo"`
+}
+
+// OpenSSFLegacy holds the signals of the original criticality score.
+type OpenSSFLegacy struct {
+	ClosedIssuesCount     int     `json:"closed_issues_count"`
+	CommitFrequency       float64 `json:"commit_frequency"`
+	ContributorCount      int     `json:"contributor_count"`
+	CreatedSince          int     `json:"created_since"`
+	GitHubMentionCount    int     `json:"github_mention_count"`
+	IssueCommentFrequency float64 `json:"issue_comment_frequency"`
+	OrgCount              int     `json:"org_count"`
+	RecentReleaseCount    int     `json:"recent_release_count"`
+	UpdatedIssuesCount    int     `json:"updated_issues_count"`
+	UpdatedSince          int     `json:"updated_since"`
+}
+
+// OpenSSFRepo holds the signals describing the repository. UpdatedAt is the time of the
+// last push, which upstream takes from the last commit.
+type OpenSSFRepo struct {
+	URL       string `json:"url"`
+	Language  string `json:"language"`
+	License   string `json:"license,omitempty"`
+	StarCount *int   `json:"star_count,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// OpenSSF maps a score to the upstream OpenSSF criticality_score schema. The
+// dependents count is the upstream github_mention_count, which it only matches
+// exactly when counted with DependentsCommitSearch.
+func (score Score) OpenSSF() OpenSSFRecord {
+
+	record := OpenSSFRecord{
+		DefaultScore: score.CriticalityScore,
+		Legacy: OpenSSFLegacy{
+			ClosedIssuesCount:     score.ClosedIssuesCount,
+			CommitFrequency:       score.CommitFrequency,
+			ContributorCount:      score.ContributorCount,
+			CreatedSince:          score.CreatedSince,
+			GitHubMentionCount:    score.DependentsCount,
+			IssueCommentFrequency: score.CommentFrequency,
+			OrgCount:              score.OrgCount,
+			RecentReleaseCount:    score.RecentReleasesCount,
+			UpdatedIssuesCount:    score.UpdatedIssuesCount,
+			UpdatedSince:          score.UpdatedSince,
+		},
+		Repo: OpenSSFRepo{
+			URL:      score.URL,
+			Language: score.Language,
+		},
+	}
+
+	if m := score.Repository; m != nil {
+		stars := m.StargazersCount
+		record.Repo.License = m.License
+		record.Repo.StarCount = &stars
+		record.Repo.CreatedAt = m.CreatedAt.UTC().Format(time.RFC3339)
+		record.Repo.UpdatedAt = m.PushedAt.UTC().Format(time.RFC3339)
+	}
+
+	return record
+}
+
+// writeOpenSSF writes a score to w in the upstream OpenSSF schema, as a single line of
+// json so that several scores form a json lines file like the upstream output.
+func writeOpenSSF(w io.Writer, score Score) {
+	b, err := json.Marshal(score.OpenSSF())
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, string(b))
+}
@@ -0,0 +1,99 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// unsafeFileNameChars matches characters that aren't kept in output file names.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// outputExtensions maps output formats to the extension of their files.
+var outputExtensions = map[string]string{
+	"default":        ".txt",
+	"csv":            ".csv",
+	"csv-table":      ".csv",
+	"json":           ".json",
+	"markdown":       ".md",
+	"github-summary": ".md",
+	"openssf":        ".json",
+}
+
+// WriteScoreFiles writes each score to its own file in dir, in one of the OutputFormats,
+// and returns the paths of the files. Files are named owner__repo with the extension of
+// the format, and names used by an earlier score get a numbered suffix.
+func WriteScoreFiles(dir string, scores []Score, format string) ([]string, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	var paths []string
+
+	for _, score := range scores {
+		base := scoreFileName(score)
+		name := base
+		for i := 2; used[strings.ToLower(name)]; i++ {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		used[strings.ToLower(name)] = true
+
+		path := filepath.Join(dir, name+outputExtensions[format])
+		f, err := os.Create(path)
+		if err != nil {
+			return paths, err
+		}
+		WriteScore(f, score, format)
+		if err := f.Close(); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// scoreFileName returns the file name, without extension, of a score: owner__repo
+// from its url on any host, or its name if the url can't be parsed, with unsafe characters replaced.
+func scoreFileName(score Score) string {
+
+	name := score.Name
+	host := DefaultGitHubHost
+	if u, err := url.Parse(score.URL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	if owner, repo := parseHostRepoURL(score.URL, host); owner != "" && repo != "" {
+		name = owner + "__" + repo
+	}
+	if score.Path != "" {
+		name += "__" + score.Path
+	}
+
+	name = unsafeFileNameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, ".")
+	if name == "" {
+		name = "score"
+	}
+
+	return name
+}
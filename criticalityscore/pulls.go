@@ -0,0 +1,144 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"math"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// pullRequestCache holds the pull requests closed within the issue lookback days, which
+// MergedPullRequests and PullRequestMergeRate both use. It's shared by the copies of a
+// GitHubRepository, and safe for concurrent use.
+type pullRequestCache struct {
+	closedOnce sync.Once
+	closed     closedPullRequests
+	closedErr  error
+}
+
+// closedPullRequests counts the pull requests closed within the issue lookback days, and
+// those of them that were merged.
+type closedPullRequests struct {
+	closed int
+	merged int
+}
+
+// OpenPullRequests returns the number of open pull requests.
+func (ghr GitHubRepository) OpenPullRequests() (int, error) {
+
+	if ghr.gitlab != nil {
+		return 0, ErrMetricUnavailable
+	}
+
+	opts := &github.PullRequestListOptions{
+		State: "open",
+		ListOptions: github.ListOptions{
+			PerPage: 1,
+		},
+	}
+
+	pulls, resp, err := ghr.source.ListPullRequests(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	if err != nil {
+		return 0, classifyForbidden(err)
+	}
+
+	return totalCount(ghr.ctx, ghr.source, resp, len(pulls)), nil
+}
+
+// MergedPullRequests returns the number of pull requests merged within the issue
+// lookback days. At most PullRequestPageLimit pages of closed pull requests are
+// inspected.
+func (ghr GitHubRepository) MergedPullRequests() (int, error) {
+	pulls, err := ghr.recentClosedPullRequests()
+	return pulls.merged, err
+}
+
+// PullRequestMergeRate returns the fraction of the pull requests closed within the issue
+// lookback days that were merged, rounded to two decimals. It returns
+// ErrMetricUnavailable if no pull requests were closed.
+func (ghr GitHubRepository) PullRequestMergeRate() (float64, error) {
+
+	pulls, err := ghr.recentClosedPullRequests()
+	if err != nil {
+		return 0, err
+	}
+	if pulls.closed == 0 {
+		return 0, ErrMetricUnavailable
+	}
+
+	return math.Round(float64(pulls.merged)/float64(pulls.closed)*100) / 100, nil
+}
+
+// recentClosedPullRequests returns listClosedPullRequests, fetched once for
+// MergedPullRequests and PullRequestMergeRate.
+func (ghr GitHubRepository) recentClosedPullRequests() (closedPullRequests, error) {
+	if ghr.gitlab != nil {
+		return closedPullRequests{}, ErrMetricUnavailable
+	}
+	if ghr.pulls == nil {
+		return ghr.listClosedPullRequests()
+	}
+	c := ghr.pulls
+	c.closedOnce.Do(func() {
+		c.closed, c.closedErr = ghr.listClosedPullRequests()
+	})
+	return c.closed, c.closedErr
+}
+
+// listClosedPullRequests pages through the closed pull requests, most recently updated
+// first, until they were last updated before the issue lookback days. A pull request
+// closed within the lookback days was updated within them too.
+func (ghr GitHubRepository) listClosedPullRequests() (closedPullRequests, error) {
+
+	since := lookbackTime(ghr.config.IssueLookbackDays)
+
+	opts := &github.PullRequestListOptions{
+		State:     "closed",
+		Sort:      "updated",
+		Direction: "desc",
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+
+	var counts closedPullRequests
+	for page := 0; page < PullRequestPageLimit; page++ {
+		pulls, resp, err := ghr.source.ListPullRequests(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+		if err != nil {
+			return closedPullRequests{}, classifyForbidden(err)
+		}
+		for _, pull := range pulls {
+			if pull.GetUpdatedAt().Before(since) {
+				return counts, nil
+			}
+			if pull.GetClosedAt().Before(since) {
+				continue
+			}
+			counts.closed++
+			if pull.MergedAt != nil {
+				counts.merged++
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,157 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that paces requests to a target hourly rate.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows requestsPerHour requests,
+// with up to burst requests sent back-to-back.
+func NewRateLimiter(requestsPerHour float64, burst int) *RateLimiter {
+	b := math.Max(1, math.Min(float64(burst), requestsPerHour))
+	return &RateLimiter{
+		rate:   requestsPerHour / 3600.0,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request may be sent or the context is done.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+
+	rl.mu.Lock()
+	now := time.Now()
+	rl.tokens = math.Min(rl.burst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	rl.last = now
+	rl.tokens--
+	var wait time.Duration
+	if rl.tokens < 0 {
+		wait = time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+	}
+	rl.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		rl.mu.Lock()
+		rl.tokens++
+		rl.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateLimitedTransport is an http.RoundTripper that acquires from a RateLimiter
+// before every request.
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// rateLimitRetryTransport is an http.RoundTripper that retries requests GitHub
+// rejects for a rate limit, after the Retry-After delay or the rate limit reset.
+type rateLimitRetryTransport struct {
+	base     http.RoundTripper
+	attempts int
+}
+
+func (t *rateLimitRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || attempt >= t.attempts || req.Body != nil {
+			return resp, err
+		}
+
+		delay, ok := rateLimitRetryDelay(resp)
+		if !ok {
+			return resp, nil
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		log.Printf("rate limited, retrying %s in %0.0f seconds.\n", req.URL.Path, delay.Seconds())
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// limiterKey identifies a shared RateLimiter by token and pacing.
+type limiterKey struct {
+	token           string
+	requestsPerHour float64
+	burst           int
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[limiterKey]*RateLimiter)
+)
+
+// sharedRateLimiter returns the process-wide RateLimiter for a token, since GitHub
+// applies its rate limit per token rather than per repository. Configs with the same
+// token but a different RequestsPerHour or RequestBurst get a limiter of their own, so
+// each is paced at the rate it asks for, and their combined rate is the sum of both.
+func sharedRateLimiter(token string, config ScoreConfig) *RateLimiter {
+
+	requestsPerHour := config.RequestsPerHour
+	if token == "" {
+		requestsPerHour = math.Min(requestsPerHour, UnauthenticatedRequestsPerHour)
+	}
+	key := limiterKey{token: token, requestsPerHour: requestsPerHour, burst: config.RequestBurst}
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	limiter, ok := limiters[key]
+	if !ok {
+		limiter = NewRateLimiter(requestsPerHour, config.RequestBurst)
+		limiters[key] = limiter
+	}
+	return limiter
+}
@@ -0,0 +1,49 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import "testing"
+
+func TestSharedRateLimiter(t *testing.T) {
+
+	config := DefaultScoreConfig()
+	config.RequestsPerHour = 3600
+	config.RequestBurst = 10
+
+	limiter := sharedRateLimiter("shared-tok", config)
+	if got := sharedRateLimiter("shared-tok", config); got != limiter {
+		t.Error("sharedRateLimiter() returned a new limiter for the same token and rate")
+	}
+	if got := sharedRateLimiter("other-tok", config); got == limiter {
+		t.Error("sharedRateLimiter() shared a limiter across tokens")
+	}
+
+	slower := config
+	slower.RequestsPerHour = 360
+	got := sharedRateLimiter("shared-tok", slower)
+	if got == limiter {
+		t.Fatal("sharedRateLimiter() shared a limiter across rates")
+	}
+	if got.rate != 0.1 || limiter.rate != 1 {
+		t.Errorf("limiter rates = %v, %v, want 0.1, 1 requests per second", got.rate, limiter.rate)
+	}
+
+	smallerBurst := config
+	smallerBurst.RequestBurst = 1
+	if got := sharedRateLimiter("shared-tok", smallerBurst); got == limiter || got.burst != 1 {
+		t.Errorf("sharedRateLimiter() with burst 1 = %p burst %v, want a new limiter with burst 1", got, got.burst)
+	}
+}
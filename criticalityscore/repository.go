@@ -16,14 +16,14 @@
 package criticalityscore
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"net/http"
-	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/github"
@@ -33,57 +33,308 @@ import (
 var (
 	ErrRepoNotProvided                error = fmt.Errorf("please provided a repo url")
 	ErrInvalidGitHubURL               error = fmt.Errorf("invalid github url")
+	ErrInvalidBaseURL                 error = fmt.Errorf("invalid github enterprise base url")
 	ErrRepoNotFound                   error = fmt.Errorf("repo not found")
 	ErrAPIResponseError               error = fmt.Errorf("github api response error, please try again")
 	ErrCommitFrequencyBeingCalculated error = fmt.Errorf("commit frequency is being calculated by github, please try again")
+	ErrDependentsRateLimited          error = fmt.Errorf("dependents search is rate limited, please try again later")
+	ErrDependentsServerError          error = fmt.Errorf("dependents search server error, please try again")
+	ErrDependentsNoMatch              error = fmt.Errorf("dependents count not found in search results page")
+	ErrForbidden                      error = fmt.Errorf("access forbidden, check that the token and source are allowed to read this repository")
+	ErrScopeMissing                   error = fmt.Errorf("token is missing a required scope or permission")
+	ErrUnauthenticated                error = fmt.Errorf("no github token provided, unauthenticated rate limits are too low to score a repository reliably")
+	ErrMetricUnavailable              error = fmt.Errorf("metric is unavailable for this repository")
+	ErrNoCommits                      error = fmt.Errorf("%w, it has no commits", ErrMetricUnavailable)
+	ErrRateLimitCheck                 error = fmt.Errorf("github rate limit check failed, please try again")
 )
 
+// DefaultGitHubHost is the host of repository urls when no GitHub Enterprise Server is
+// configured.
+const DefaultGitHubHost = "github.com"
+
 // GitHubRepository is an object that provides a GitHub client interface for a single repository.
 type GitHubRepository struct {
-	ctx    context.Context
-	client *github.Client
-	R      *github.Repository
+	ctx        context.Context
+	source     RepoDataSource
+	httpClient *http.Client
+	authed     bool
+	R          *github.Repository
+	// Deprecated: Error is no longer set. The metric methods return their errors, and
+	// RepositoryStats returns the errors of all metrics that failed as MetricErrors.
 	Error  error
+	config ScoreConfig
+	// gitlab is set for a GitLab project, whose metric methods request GitLab instead,
+	// or return ErrMetricUnavailable for the metrics GitLab has no data for.
+	gitlab *gitLabRepository
+	// graphql is set by RepositoryStats with GraphQL enabled, and then used instead of
+	// the REST API by the metric methods it has data for.
+	graphql *graphQLStats
+	// commits caches the commits used by several metric methods.
+	commits *commitCache
+	// pulls caches the pull requests used by several metric methods.
+	pulls *pullRequestCache
 }
 
 // LoadRepository returns a GitHubRepository object from a GitHub repository URL
-// and an authorized GitHUB personal access token.
+// and an authorized GitHUB personal access token. A gitlab.com project URL loads the
+// project from GitLab instead, authorized with the GitLabToken of the config.
 func LoadRepository(repoURL, token string) (GitHubRepository, error) {
+	return LoadRepositoryWithConfig(repoURL, token, DefaultScoreConfig())
+}
+
+// LoadRepositoryWithConfig returns a GitHubRepository object like LoadRepository,
+// using the settings in config. All API requests made with the same token share
+// a single rate limiter.
+func LoadRepositoryWithConfig(repoURL, token string, config ScoreConfig) (GitHubRepository, error) {
+	return LoadRepositoryContext(context.Background(), repoURL, token, config)
+}
+
+// LoadRepositoryContext returns a GitHubRepository object like LoadRepositoryWithConfig.
+// Loading the repository and all API requests of its metrics are canceled when ctx is
+// done, after which the metrics are incomplete.
+func LoadRepositoryContext(ctx context.Context, repoURL, token string, config ScoreConfig) (GitHubRepository, error) {
 
 	if repoURL == "" {
 		return GitHubRepository{}, ErrRepoNotProvided
 	}
 
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
+	if path := parseGitLabURL(repoURL); path != "" {
+		return loadGitLabRepository(ctx, path, config)
+	}
+
+	owner, name := parseHostRepoURL(repoURL, config.GitHubHost())
 
-	client := github.NewClient(tc)
+	if owner == "" || name == "" {
+		return GitHubRepository{}, ErrInvalidGitHubURL
+	}
+
+	return loadRepository(ctx, token, config, func(ctx context.Context, source RepoDataSource) (*github.Repository, error) {
+		r, _, err := source.GetRepository(ctx, owner, name)
+		return r, err
+	})
+}
+
+// LoadRepositoryFromDataSource returns a GitHubRepository object like
+// LoadRepositoryContext, making all API requests of the repository and its metrics with
+// source. Unlike LoadRepositoryContext, it doesn't wait for the rate limit to reset, so
+// repositories loaded from the same source only check the rate limit once. A gitlab.com
+// project URL loads the project from GitLab, without source.
+func LoadRepositoryFromDataSource(ctx context.Context, repoURL string, source RepoDataSource, config ScoreConfig) (GitHubRepository, error) {
 
-	pauseIfGitHubRateLimitExceeded(client, ctx)
+	if repoURL == "" {
+		return GitHubRepository{}, ErrRepoNotProvided
+	}
+
+	if path := parseGitLabURL(repoURL); path != "" {
+		return loadGitLabRepository(ctx, path, config)
+	}
 
-	owner, name := parseRepoURL(repoURL)
+	owner, name := parseHostRepoURL(repoURL, config.GitHubHost())
 
 	if owner == "" || name == "" {
 		return GitHubRepository{}, ErrInvalidGitHubURL
 	}
 
-	r, _, err := client.Repositories.Get(ctx, owner, name)
+	return loadFromDataSource(ctx, source, config, func(ctx context.Context, source RepoDataSource) (*github.Repository, error) {
+		r, _, err := source.GetRepository(ctx, owner, name)
+		return r, err
+	})
+}
+
+// LoadRepositoryByID returns a GitHubRepository object from a GitHub repository ID,
+// which unlike the URL doesn't change when a repository is renamed or transferred.
+func LoadRepositoryByID(id int64, token string) (GitHubRepository, error) {
+	return LoadRepositoryByIDWithConfig(id, token, DefaultScoreConfig())
+}
+
+// LoadRepositoryByIDWithConfig returns a GitHubRepository object like LoadRepositoryByID,
+// using the settings in config.
+func LoadRepositoryByIDWithConfig(id int64, token string, config ScoreConfig) (GitHubRepository, error) {
+
+	if id == 0 {
+		return GitHubRepository{}, ErrRepoNotProvided
+	}
+
+	return loadRepository(context.Background(), token, config, func(ctx context.Context, source RepoDataSource) (*github.Repository, error) {
+		r, _, err := source.GetRepositoryByID(ctx, id)
+		return r, err
+	})
+}
+
+// loadRepository returns a GitHubRepository object for the repository returned by get,
+// from a new GitHub data source authorized with token.
+func loadRepository(ctx context.Context, token string, config ScoreConfig, get func(context.Context, RepoDataSource) (*github.Repository, error)) (GitHubRepository, error) {
+
+	if token == "" && !config.AllowUnauthenticated {
+		return GitHubRepository{}, ErrUnauthenticated
+	}
+
+	if err := ctx.Err(); err != nil {
+		return GitHubRepository{}, err
+	}
+
+	source, err := NewGitHubDataSource(ctx, token, config)
 	if err != nil {
-		return GitHubRepository{}, ErrRepoNotFound
+		return GitHubRepository{}, err
+	}
+
+	if err := pauseIfGitHubRateLimitExceeded(source, ctx); err != nil {
+		return GitHubRepository{}, err
+	}
+
+	return loadFromDataSource(ctx, source, config, get)
+}
+
+// loadFromDataSource returns a GitHubRepository object for the repository returned by get.
+// All metrics use the owner and name of the returned repository.
+func loadFromDataSource(ctx context.Context, source RepoDataSource, config ScoreConfig, get func(context.Context, RepoDataSource) (*github.Repository, error)) (GitHubRepository, error) {
+
+	if err := ctx.Err(); err != nil {
+		return GitHubRepository{}, err
+	}
+
+	r, err := get(ctx, source)
+	if err != nil {
+		if ctx.Err() != nil {
+			return GitHubRepository{}, ctx.Err()
+		}
+		if forbidden := classifyForbidden(err); forbidden != err {
+			return GitHubRepository{}, forbidden
+		}
+		// Only a 404 means the repository doesn't exist; other errors, such as a rate
+		// limit, are returned so callers can tell whether to retry.
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+			return GitHubRepository{}, ErrRepoNotFound
+		}
+		return GitHubRepository{}, fmt.Errorf("repository : %w", err)
 	}
 
 	return GitHubRepository{
-		ctx:    ctx,
-		client: client,
-		R:      r,
+		ctx:        ctx,
+		source:     source,
+		httpClient: newScrapeClient(config),
+		authed:     source.Authenticated(),
+		R:          r,
+		config:     config,
+		commits:    new(commitCache),
+		pulls:      new(pullRequestCache),
 	}, nil
 }
 
+// newGitHubClient returns a GitHub API client authorized with token. Requests go
+// through the shared rate limiter, are retried when GitHub rejects them for a rate limit
+// and, if a cache directory is configured, go through the disk cache, so cached responses
+// don't count against the rate limit. If a GitHub Enterprise
+// Server is configured, the client uses its API urls.
+func newGitHubClient(ctx context.Context, token string, config ScoreConfig) (*github.Client, error) {
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	tc := oauth2.NewClient(ctx, ts)
+	if config.RequestsPerHour > 0 {
+		tc.Transport = &rateLimitedTransport{
+			base:    tc.Transport,
+			limiter: sharedRateLimiter(token, config),
+		}
+	}
+	tc.Transport = &rateLimitRetryTransport{
+		base:     tc.Transport,
+		attempts: DefaultRateLimitAttempts,
+	}
+	if config.CacheDir != "" {
+		tc.Transport = &cachingTransport{
+			base:  tc.Transport,
+			dir:   config.CacheDir,
+			ttl:   config.CacheTTL,
+			token: token,
+		}
+	}
+
+	if config.GitHubBaseURL == "" {
+		return github.NewClient(tc), nil
+	}
+
+	uploadURL := config.GitHubUploadURL
+	if uploadURL == "" {
+		uploadURL = fmt.Sprintf("https://%s/api/uploads/", config.GitHubHost())
+	}
+	client, err := github.NewEnterpriseClient(config.GitHubBaseURL, uploadURL, tc)
+	if err != nil {
+		return nil, fmt.Errorf("%w : %s", ErrInvalidBaseURL, err.Error())
+	}
+	return client, nil
+}
+
+// newScrapeClient returns the http client used for requests to github.com pages
+// rather than the API, cached on disk if a cache directory is configured.
+func newScrapeClient(config ScoreConfig) *http.Client {
+	if config.CacheDir == "" {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: &cachingTransport{
+			base: http.DefaultTransport,
+			dir:  config.CacheDir,
+			ttl:  config.CacheTTL,
+		},
+	}
+}
+
+// IssuesEnabled returns whether the repository has GitHub issues enabled.
+func (ghr GitHubRepository) IssuesEnabled() bool {
+	return ghr.R.GetHasIssues()
+}
+
+// WikiEnabled returns whether the repository has its wiki enabled.
+func (ghr GitHubRepository) WikiEnabled() bool {
+	return ghr.R.GetHasWiki()
+}
+
+// DiscussionsEnabled returns whether the repository has GitHub Discussions enabled.
+// The flag isn't part of github.Repository, so the repository is fetched again.
+func (ghr GitHubRepository) DiscussionsEnabled() (bool, error) {
+
+	if ghr.gitlab != nil {
+		return false, ErrMetricUnavailable
+	}
+
+	enabled, _, err := ghr.source.HasDiscussions(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName())
+	if err != nil {
+		return false, classifyForbidden(err)
+	}
+
+	return enabled, nil
+}
+
+// StargazersCount returns the number of stars of the repository.
+func (ghr GitHubRepository) StargazersCount() int {
+	return ghr.R.GetStargazersCount()
+}
+
+// ForksCount returns the number of forks of the repository.
+func (ghr GitHubRepository) ForksCount() int {
+	return ghr.R.GetForksCount()
+}
+
+// WatchersCount returns the number of users watching the repository. GitHub's
+// watchers_count is the number of stars for historical reasons, so this is the
+// subscribers_count.
+func (ghr GitHubRepository) WatchersCount() int {
+	return ghr.R.GetSubscribersCount()
+}
+
 // Criteria important for ranking.
 
+// licenseID returns the SPDX id of the license GitHub recognized for a repository, or an
+// empty string if it didn't detect one or couldn't tell which license it is.
+func licenseID(r *github.Repository) string {
+	id := r.GetLicense().GetSPDXID()
+	if id == "NOASSERTION" {
+		return ""
+	}
+	return id
+}
+
 // CreatedSince returns the number of months since the repository was created.
 func (ghr GitHubRepository) CreatedSince() int {
 	difference := time.Since(ghr.R.CreatedAt.Time)
@@ -91,57 +342,108 @@ func (ghr GitHubRepository) CreatedSince() int {
 }
 
 // UpdatedSince returns the number of months since the last commit.
-func (ghr GitHubRepository) UpdatedSince() int {
+// If a path is configured, only commits touching that path are considered.
+// It returns ErrNoCommits for an empty repository, or if no commits touch the path.
+func (ghr GitHubRepository) UpdatedSince() (int, error) {
 
-	commits, _, err := ghr.client.Repositories.ListCommits(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), nil)
-	if err != nil {
-		ghr.Error = err
-		return 0
+	if ghr.gitlab != nil {
+		return ghr.gitlab.updatedSince()
+	}
+
+	if ghr.graphql != nil && ghr.config.Path == "" {
+		return ghr.graphql.updatedSince()
+	}
+
+	var commits []*github.RepositoryCommit
+	if ghr.config.Path == "" {
+		var err error
+		commits, err = ghr.recentCommits()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		opts := &github.CommitsListOptions{
+			Path: ghr.config.Path,
+		}
+
+		var resp *github.Response
+		var err error
+		commits, resp, err = ghr.source.ListCommits(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+		if err != nil {
+			// GitHub responds with 409 Conflict for an empty repository.
+			if resp != nil && resp.StatusCode == http.StatusConflict {
+				return 0, ErrNoCommits
+			}
+			return 0, classifyForbidden(err)
+		}
+	}
+	if len(commits) == 0 {
+		return 0, ErrNoCommits
 	}
 
 	lastCommit := commits[0]
-	difference := time.Since(lastCommit.Commit.Author.GetDate())
-	return int(math.Round(difference.Hours() / 24.0 / 30.0))
+	difference := time.Since(lastCommit.GetCommit().GetAuthor().GetDate())
+	return int(math.Round(difference.Hours() / 24.0 / 30.0)), nil
 }
 
-// Contributors returns the number of all contributors.
-func (ghr GitHubRepository) Contributors() int {
+// Contributors returns the number of all contributors, including anonymous
+// contributors if configured.
+// If a path is configured, it returns the number of distinct authors of commits
+// touching that path instead.
+func (ghr GitHubRepository) Contributors() (int, error) {
+
+	if ghr.gitlab != nil {
+		return ghr.gitlab.contributors()
+	}
+
+	if ghr.config.Path != "" {
+		authors, err := ghr.cachedPathCommitAuthors()
+		return len(authors), err
+	}
 
 	opts := &github.ListContributorsOptions{
-		Anon: "true",
+		Anon: strconv.FormatBool(ghr.config.IncludeAnonymous),
 		ListOptions: github.ListOptions{
 			PerPage: 1,
 		},
 	}
 
-	contributors, resp, err := ghr.client.Repositories.ListContributors(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	contributors, resp, err := ghr.source.ListContributors(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 	if err != nil {
-		ghr.Error = err
-		return 0
+		return 0, classifyForbidden(err)
 	}
 
-	if resp.Header.Get("link") == "" {
-		return len(contributors)
-	}
-
-	return totalCount(resp)
+	return totalCount(ghr.ctx, ghr.source, resp, len(contributors)), nil
 }
 
 // ContributorOrgs returns a map of companies associated with each of the top contributors.
-func (ghr GitHubRepository) ContributorOrgs() map[string]bool {
+// Only the top contributors are listed and looked up, so the orgs of a repository with
+// thousands of contributors are a sample of its real companies, like any other.
+// Anonymous contributors are counted among the top contributors if configured, the same
+// as in Contributors, but have no company to look up.
+// If a path is configured, the top contributors are the most frequent authors of
+// commits touching that path.
+func (ghr GitHubRepository) ContributorOrgs() (map[string]bool, error) {
+
+	if ghr.gitlab != nil {
+		return nil, ErrMetricUnavailable
+	}
+
+	if ghr.config.Path != "" {
+		return ghr.pathContributorOrgs()
+	}
 
 	opts := &github.ListContributorsOptions{
-		Anon: "false",
+		Anon: strconv.FormatBool(ghr.config.IncludeAnonymous),
 		ListOptions: github.ListOptions{
 			PerPage: 25,
 		},
 	}
 	var allContributors []*github.Contributor
 	for {
-		contributors, resp, err := ghr.client.Repositories.ListContributors(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+		contributors, resp, err := ghr.source.ListContributors(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 		if err != nil {
-			ghr.Error = err
-			return nil
+			return nil, classifyForbidden(err)
 		}
 		allContributors = append(allContributors, contributors...)
 		if resp.NextPage == 0 {
@@ -156,21 +458,21 @@ func (ghr GitHubRepository) ContributorOrgs() map[string]bool {
 
 	orgs := make(map[string]bool)
 
-	if len(allContributors) > 5000 {
-		for i := 0; i < 10; i++ {
-			orgs[string(i)] = true
-		}
-		return orgs
-	}
-
-	maxContributorCount := len(allContributors) - 1
-	if maxContributorCount > TopContributorCount {
-		maxContributorCount = TopContributorCount
+	// A repository with fewer than TopContributorCount contributors has all of them
+	// looked up.
+	topContributors := allContributors
+	if len(topContributors) > TopContributorCount {
+		topContributors = topContributors[:TopContributorCount]
 	}
 
 	var allUsers []*github.User
-	for _, contributor := range allContributors[:maxContributorCount] {
-		user, _, err := ghr.client.Users.GetByID(ghr.ctx, contributor.GetID())
+	for _, contributor := range topContributors {
+		if contributor.GetType() == "Anonymous" {
+			continue
+		}
+		user, err := ghr.lookupUser(func() (*github.User, *github.Response, error) {
+			return ghr.source.GetUserByID(ghr.ctx, contributor.GetID())
+		})
 		if err != nil {
 			continue
 		}
@@ -181,24 +483,84 @@ func (ghr GitHubRepository) ContributorOrgs() map[string]bool {
 		if company == "" {
 			continue
 		}
-		name := filterOrgName(company)
+		name := ghr.normalizeOrgName(company)
 		orgs[name] = true
 	}
 
-	return orgs
+	return orgs, nil
+}
+
+// CommitFrequency returns the weekly average number of commits over the last 52 weeks.
+// The configured source is tried first, falling back to the other if GitHub is still
+// calculating its statistics or the request fails. The commit_activity source counts
+// commits on all branches, the participation source only counts default-branch commits.
+// If a path is configured, only commits touching that path in the last 52 weeks are counted.
+func (ghr GitHubRepository) CommitFrequency() (float64, error) {
+
+	if ghr.gitlab != nil {
+		return ghr.gitlab.commitFrequency()
+	}
+
+	if ghr.config.Path != "" {
+		return ghr.pathCommitFrequency()
+	}
+
+	if ghr.graphql != nil {
+		return ghr.graphql.commitFrequency(), nil
+	}
+
+	sources := []func() (int, error){ghr.commitActivityTotal, ghr.participationTotal}
+	if ghr.config.CommitFrequencySource == CommitFrequencyParticipation {
+		sources = []func() (int, error){ghr.participationTotal, ghr.commitActivityTotal}
+	}
+
+	attempts := ghr.config.StatsAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	// GitHub calculates the statistics in the background after the first request,
+	// usually within seconds, so requests are retried as long as either source
+	// returns 202, even if the other one failed.
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if err = sleepContext(ghr.ctx, ghr.config.StatsRetryDelay); err != nil {
+				return 0, err
+			}
+		}
+
+		calculating := false
+		for _, source := range sources {
+			var total int
+			total, err = source()
+			if err == nil {
+				return math.Round(float64(total)/52.0*10.0) / 10, nil
+			}
+			if errors.Is(err, ErrCommitFrequencyBeingCalculated) {
+				calculating = true
+			}
+		}
+
+		if !calculating {
+			return 0, err
+		}
+	}
+
+	return 0, ErrCommitFrequencyBeingCalculated
 }
 
-// CommitFrequency returns the weekly average number of commits.
-func (ghr GitHubRepository) CommitFrequency() float64 {
+// commitActivityTotal returns the number of commits in the last 52 weeks from the
+// stats/commit_activity endpoint.
+func (ghr GitHubRepository) commitActivityTotal() (int, error) {
 
-	weekStats, resp, err := ghr.client.Repositories.ListCommitActivity(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName())
+	weekStats, resp, err := ghr.source.ListCommitActivity(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName())
 	if err != nil {
-		if resp.StatusCode == 202 {
-			ghr.Error = ErrCommitFrequencyBeingCalculated
-			return 0
+		// resp is nil if the request failed before a response was received.
+		if resp != nil && resp.StatusCode == http.StatusAccepted {
+			return 0, ErrCommitFrequencyBeingCalculated
 		}
-		ghr.Error = err
-		return 0
+		return 0, classifyForbidden(err)
 	}
 
 	total := 0
@@ -206,23 +568,263 @@ func (ghr GitHubRepository) CommitFrequency() float64 {
 		total += weekStat.GetTotal()
 	}
 
-	return math.Round(float64(total)/52.0*10.0) / 10
+	return total, nil
+}
+
+// participationTotal returns the number of default-branch commits in the last 52 weeks
+// from the stats/participation endpoint.
+func (ghr GitHubRepository) participationTotal() (int, error) {
+
+	participation, resp, err := ghr.source.ListParticipation(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName())
+	if resp != nil && resp.StatusCode == http.StatusAccepted {
+		return 0, ErrCommitFrequencyBeingCalculated
+	}
+	if err != nil {
+		return 0, classifyForbidden(err)
+	}
+
+	total := 0
+	for _, weekTotal := range participation.All {
+		total += weekTotal
+	}
+
+	return total, nil
+}
+
+// Churn returns the number of distinct files changed by the most recent commits within
+// ChurnLookbackDays, inspecting at most ChurnCommitLimit commits. It returns
+// ErrMetricUnavailable if GitHub didn't return the changed files for any of the commits.
+func (ghr GitHubRepository) Churn() (int, error) {
+
+	recent, err := ghr.recentCommits()
+	if err != nil {
+		return 0, err
+	}
+
+	since := time.Now().Add(-ChurnLookbackDays * 24.0 * time.Hour)
+	var commits []*github.RepositoryCommit
+	for _, c := range recent {
+		if c.GetCommit().GetCommitter().GetDate().Before(since) {
+			continue
+		}
+		commits = append(commits, c)
+		if len(commits) == ChurnCommitLimit {
+			break
+		}
+	}
+
+	if len(commits) == 0 {
+		return 0, nil
+	}
+
+	files := make(map[string]bool)
+	available := false
+	for _, c := range commits {
+		commit, _, err := ghr.source.GetCommit(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), c.GetSHA())
+		if err != nil {
+			return 0, classifyForbidden(err)
+		}
+		if commit.Stats != nil || len(commit.Files) > 0 {
+			available = true
+		}
+		for _, file := range commit.Files {
+			files[file.GetFilename()] = true
+		}
+	}
+
+	if !available {
+		return 0, ErrMetricUnavailable
+	}
+
+	return len(files), nil
+}
+
+// ReleaseContributors returns the number of distinct authors of the commits between the
+// two most recent published releases, i.e. the people who shaped the latest release.
+// GitHub compares at most 250 commits. It returns ErrMetricUnavailable if the repository
+// has fewer than two releases.
+func (ghr GitHubRepository) ReleaseContributors() (int, error) {
+
+	if ghr.gitlab != nil {
+		return 0, ErrMetricUnavailable
+	}
+
+	opts := &github.ListOptions{
+		PerPage: 10,
+	}
+
+	releases, _, err := ghr.source.ListReleases(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	if err != nil {
+		return 0, classifyForbidden(err)
+	}
+
+	// Releases are listed most recent first.
+	var tags []string
+	for _, release := range releases {
+		if release.GetDraft() || release.GetTagName() == "" {
+			continue
+		}
+		tags = append(tags, release.GetTagName())
+		if len(tags) == 2 {
+			break
+		}
+	}
+	if len(tags) < 2 {
+		return 0, ErrMetricUnavailable
+	}
+
+	comparison, _, err := ghr.source.CompareCommits(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), tags[1], tags[0])
+	if err != nil {
+		return 0, classifyForbidden(err)
+	}
+
+	authors := make(map[string]bool)
+	for _, c := range comparison.Commits {
+		// Authors without a GitHub account are told apart by their email.
+		author := c.GetAuthor().GetLogin()
+		if author == "" {
+			author = strings.ToLower(c.GetCommit().GetAuthor().GetEmail())
+		}
+		if author != "" {
+			authors[author] = true
+		}
+	}
+
+	return len(authors), nil
+}
+
+// SignedCommits returns the fraction of the most recent commits, at most
+// SignedCommitSampleSize, whose signature GitHub verified, rounded to two decimals. It
+// returns ErrMetricUnavailable if there are no commits or GitHub didn't return their
+// verification.
+func (ghr GitHubRepository) SignedCommits() (float64, error) {
+
+	commits, err := ghr.recentCommits()
+	if err != nil {
+		return 0, err
+	}
+	if len(commits) > SignedCommitSampleSize {
+		commits = commits[:SignedCommitSampleSize]
+	}
+
+	if len(commits) == 0 {
+		return 0, ErrMetricUnavailable
+	}
+
+	signed := 0
+	for _, c := range commits {
+		verification := c.GetCommit().Verification
+		if verification == nil {
+			return 0, ErrMetricUnavailable
+		}
+		if verification.GetVerified() {
+			signed++
+		}
+	}
+
+	return math.Round(float64(signed)/float64(len(commits))*100) / 100, nil
+}
+
+// StarGrowth returns the number of new stars per 30 days over the last
+// StarGrowthLookbackDays, from the starred-at timestamps of the most recent stargazers.
+// At most StarGrowthPageLimit pages of stargazers are sampled; if they don't reach back
+// to the start of the window, the rate is estimated from the sampled period instead.
+// It returns ErrMetricUnavailable if the stargazer timestamps aren't available.
+func (ghr GitHubRepository) StarGrowth() (float64, error) {
+
+	if ghr.gitlab != nil {
+		return 0, ErrMetricUnavailable
+	}
+
+	opts := &github.ListOptions{
+		PerPage: 100,
+	}
+
+	stargazers, resp, err := ghr.source.ListStargazers(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	if err != nil {
+		return 0, classifyForbidden(err)
+	}
+
+	// Stargazers are listed oldest first, so sample from the last page backwards.
+	page := 1
+	if resp.LastPage != 0 {
+		page = resp.LastPage
+	}
+
+	windowStart := lookbackTime(StarGrowthLookbackDays)
+	oldest := time.Now()
+	count := 0
+	windowReached := false
+
+	for sampled := 0; page >= 1 && sampled < StarGrowthPageLimit; page, sampled = page-1, sampled+1 {
+		if page != 1 {
+			opts.Page = page
+			stargazers, _, err = ghr.source.ListStargazers(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+			if err != nil {
+				return 0, classifyForbidden(err)
+			}
+		}
+
+		for _, stargazer := range stargazers {
+			if stargazer.StarredAt == nil {
+				return 0, ErrMetricUnavailable
+			}
+			starredAt := stargazer.StarredAt.Time
+			if starredAt.Before(windowStart) {
+				windowReached = true
+				continue
+			}
+			count++
+			if starredAt.Before(oldest) {
+				oldest = starredAt
+			}
+		}
+
+		if windowReached {
+			break
+		}
+	}
+
+	days := StarGrowthLookbackDays
+	if !windowReached && page >= 1 && count > 0 {
+		// The sample ran out before reaching the start of the window.
+		days = math.Max(1, time.Since(oldest).Hours()/24.0)
+	}
+
+	return math.Round(float64(count)/days*30.0*10) / 10, nil
 }
 
 // RecentReleases returns the number of recent repository releases.
-// If none found within the number of ReleaseLookbackDays, then an estimate
-// is calculated based on totalTags / daysSinceCreation * ReleaseLookbackDays.
-func (ghr GitHubRepository) RecentReleases() int {
+// If none found within the configured release lookback days, then an estimate
+// is calculated based on totalTags / daysSinceCreation * releaseLookbackDays.
+func (ghr GitHubRepository) RecentReleases() (int, error) {
+	count, _, err := ghr.RecentReleasesEstimate()
+	return count, err
+}
+
+// RecentReleasesEstimate returns the number of recent repository releases like
+// RecentReleases, and whether it was estimated from the tags.
+func (ghr GitHubRepository) RecentReleasesEstimate() (int, bool, error) {
+
+	if ghr.gitlab != nil {
+		count, err := ghr.gitlab.recentReleases()
+		return count, false, err
+	}
+
+	if ghr.graphql != nil {
+		if count, estimated, ok := ghr.graphql.recentReleases(ghr); ok {
+			return count, estimated, nil
+		}
+	}
 
 	opts := &github.ListOptions{
 		PerPage: 100,
 	}
 	var allReleases []*github.RepositoryRelease
 	for {
-		releases, resp, err := ghr.client.Repositories.ListReleases(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+		releases, resp, err := ghr.source.ListReleases(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 		if err != nil {
-			ghr.Error = err
-			return 0
+			return 0, false, classifyForbidden(err)
 		}
 		allReleases = append(allReleases, releases...)
 		if resp.NextPage == 0 {
@@ -233,38 +835,45 @@ func (ghr GitHubRepository) RecentReleases() int {
 
 	total := 0
 	for _, release := range allReleases {
-		if time.Since(release.CreatedAt.Time).Hours()/24.0 > ReleaseLookbackDays {
+		if time.Since(release.CreatedAt.Time).Hours()/24.0 > ghr.config.ReleaseLookbackDays {
 			continue
 		}
 		total++
 	}
 
 	if total != 0 {
-		return total
+		return total, false, nil
 	}
 
 	daysSinceCreation := int(time.Since(ghr.R.CreatedAt.Time).Hours() / 24.0)
 	if daysSinceCreation == 0 {
-		return 0
+		return 0, false, nil
 	}
 
 	opts = &github.ListOptions{
 		PerPage: 1,
 	}
-	_, resp2, err := ghr.client.Repositories.ListTags(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	tags, resp2, err := ghr.source.ListTags(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 	if err != nil {
-		ghr.Error = err
-		return 0
+		return 0, false, classifyForbidden(err)
 	}
-	totalTags := totalCount(resp2)
+	totalTags := totalCount(ghr.ctx, ghr.source, resp2, len(tags))
 
-	return int(math.Round(float64(totalTags) / float64(daysSinceCreation) * ReleaseLookbackDays))
+	return int(math.Round(float64(totalTags) / float64(daysSinceCreation) * ghr.config.ReleaseLookbackDays)), true, nil
 }
 
 // UpdatedIssues returns the number of all repository issues.
-func (ghr GitHubRepository) UpdatedIssues() int {
+func (ghr GitHubRepository) UpdatedIssues() (int, error) {
+
+	if ghr.gitlab != nil {
+		return ghr.gitlab.updatedIssues()
+	}
 
-	issuesSinceTime := time.Now().Add(-IssueLookbackDays * 24.0 * time.Hour)
+	if ghr.graphql != nil && ghr.graphql.issueCounts {
+		return ghr.graphql.updatedIssues, nil
+	}
+
+	issuesSinceTime := lookbackTime(ghr.config.IssueLookbackDays)
 	opts := &github.IssueListByRepoOptions{
 		State: "all",
 		Since: issuesSinceTime,
@@ -273,23 +882,26 @@ func (ghr GitHubRepository) UpdatedIssues() int {
 		},
 	}
 
-	issues, resp, err := ghr.client.Issues.ListByRepo(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	issues, resp, err := ghr.source.ListIssues(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 	if err != nil {
-		ghr.Error = err
-		return 0
+		return 0, classifyForbidden(err)
 	}
 
-	if resp.Header.Get("link") == "" {
-		return len(issues)
-	}
-
-	return totalCount(resp)
+	return totalCount(ghr.ctx, ghr.source, resp, len(issues)), nil
 }
 
 // ClosedIssues returns the number of closed repository issues.
-func (ghr GitHubRepository) ClosedIssues() int {
+func (ghr GitHubRepository) ClosedIssues() (int, error) {
 
-	issuesSinceTime := time.Now().Add(-IssueLookbackDays * 24.0 * time.Hour)
+	if ghr.gitlab != nil {
+		return ghr.gitlab.closedIssues()
+	}
+
+	if ghr.graphql != nil && ghr.graphql.issueCounts {
+		return ghr.graphql.closedIssues, nil
+	}
+
+	issuesSinceTime := lookbackTime(ghr.config.IssueLookbackDays)
 	opts := &github.IssueListByRepoOptions{
 		State: "closed",
 		Since: issuesSinceTime,
@@ -298,83 +910,248 @@ func (ghr GitHubRepository) ClosedIssues() int {
 		},
 	}
 
-	issues, resp, err := ghr.client.Issues.ListByRepo(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	issues, resp, err := ghr.source.ListIssues(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 	if err != nil {
-		ghr.Error = err
-		return 0
+		return 0, classifyForbidden(err)
 	}
 
-	if resp.Header.Get("link") == "" {
-		return len(issues)
-	}
-
-	return totalCount(resp)
+	return totalCount(ghr.ctx, ghr.source, resp, len(issues)), nil
 }
 
-// CommentFrequency returns the ratio of comments to issues.
-func (ghr GitHubRepository) CommentFrequency(issueCount int) float64 {
+// CommentFrequency returns the ratio of comments to issues, i.e. the number of comments
+// made on any of the repository's issues within the issue lookback days divided by issueCount,
+// the number of issues updated in that window. A repository without recent issues has a
+// comment frequency of 0.
+func (ghr GitHubRepository) CommentFrequency(issueCount int) (float64, error) {
+
+	if ghr.gitlab != nil {
+		return ghr.gitlab.commentFrequency(issueCount)
+	}
 
 	if issueCount == 0 {
-		return 0
+		return 0, nil
 	}
 
-	issuesSinceTime := time.Now().Add(-IssueLookbackDays * 24.0 * time.Hour)
+	commentCount, err := ghr.recentIssueComments()
+	if err != nil {
+		return 0, classifyForbidden(err)
+	}
+
+	return math.Round(float64(commentCount)/float64(issueCount)*10) / 10, nil
+}
+
+// recentIssueComments returns the number of comments made within the issue lookback days
+// across all of the repository's issues, using the repository-wide issue comments
+// endpoint rather than the comments of a single issue.
+func (ghr GitHubRepository) recentIssueComments() (int, error) {
+
 	opts := &github.IssueListCommentsOptions{
-		Since: issuesSinceTime,
-		ListOptions: github.ListOptions{
-			PerPage: 1,
-		},
+		Since:       lookbackTime(ghr.config.IssueLookbackDays),
+		ListOptions: github.ListOptions{PerPage: 1},
 	}
 
-	comments, resp, err := ghr.client.Issues.ListComments(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), 0, opts)
+	comments, resp, err := ghr.source.ListIssueComments(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 	if err != nil {
-		ghr.Error = err
-		return 0
+		return 0, err
 	}
 
-	var commentCount int
-	if resp.Header.Get("link") == "" {
-		commentCount = len(comments)
-	} else {
-		commentCount = totalCount(resp)
+	return totalCount(ghr.ctx, ghr.source, resp, len(comments)), nil
+}
+
+// Dependents returns the number of dependents of the repository, as counted by DependentsContext.
+// It returns ErrDependentsNoMatch if the dependents page couldn't be parsed or the
+// repository has no dependency graph.
+func (ghr GitHubRepository) Dependents() (int, error) {
+
+	dependentsCount, err := ghr.DependentsContext(ghr.ctx)
+	if err != nil && !errors.Is(err, ErrDependentsNoMatch) {
+		return 0, classifyForbidden(err)
 	}
 
-	return math.Round(float64(commentCount)/float64(issueCount)*10) / 10
+	return dependentsCount, err
 }
 
-// Dependents returns the number of search results that contain the repository name as in a commit.
-func (ghr GitHubRepository) Dependents() int {
+// DependentsContext returns the number of dependents from the configured DependentsSource,
+// or by default the number of search results that contain the repository name as in a
+// commit, using ScrapeDependentsSource. With the DependentsDependencyGraph method, it's
+// the dependency graph's count of dependent repositories, using
+// DependencyGraphDependentsSource, instead. The two counts aren't comparable, so one never
+// stands in for the other: with the dependency graph method, a repository without a
+// dependency graph returns ErrDependentsNoMatch.
+func (ghr GitHubRepository) DependentsContext(ctx context.Context) (int, error) {
+
+	if ghr.gitlab != nil {
+		return 0, ErrMetricUnavailable
+	}
 
-	params := url.Values{}
-	params.Add("q", fmt.Sprintf(`"%s/%s"`, ghr.R.GetOwner().GetLogin(), ghr.R.GetName()))
-	params.Add("type", "commits")
+	source := ghr.config.DependentsSource
+	if source == nil {
+		if ghr.config.DependentsMethod == DependentsDependencyGraph {
+			source = DependencyGraphDependentsSource{
+				Client:   ghr.httpClient,
+				Host:     ghr.config.GitHubHost(),
+				Attempts: ghr.config.DependentsAttempts,
+				Backoff:  ghr.config.DependentsBackoff,
+			}
+		} else {
+			source = ScrapeDependentsSource{
+				Client:   ghr.httpClient,
+				Host:     ghr.config.GitHubHost(),
+				Attempts: ghr.config.DependentsAttempts,
+				Backoff:  ghr.config.DependentsBackoff,
+			}
+		}
+	}
+
+	return source.Count(ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName())
+}
 
-	dependentsURL := fmt.Sprintf(`https://github.com/search?%s`, params.Encode())
+// pathCommitAuthors returns the number of commits by each author of commits touching
+// the configured path, keyed by login or, if configured, by email for anonymous authors.
+// At most PathCommitPageLimit pages of commits are inspected.
+func (ghr GitHubRepository) pathCommitAuthors() (map[string]int, error) {
 
-	var content []byte
-	for i := 1; i <= 3; i++ {
-		resp, err := http.Get(dependentsURL)
+	opts := &github.CommitsListOptions{
+		Path: ghr.config.Path,
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+
+	authors := make(map[string]int)
+	for page := 0; page < PathCommitPageLimit; page++ {
+		commits, resp, err := ghr.source.ListCommits(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
 		if err != nil {
-			continue
+			return nil, classifyForbidden(err)
 		}
-		if resp.StatusCode == 200 {
-			content, err = ioutil.ReadAll(resp.Body)
-			if err != nil {
+		for _, commit := range commits {
+			author := commit.GetAuthor().GetLogin()
+			if author == "" && ghr.config.IncludeAnonymous {
+				author = commit.GetCommit().GetAuthor().GetEmail()
+			}
+			if author == "" {
 				continue
 			}
+			authors[author]++
+		}
+		if resp.NextPage == 0 {
 			break
 		}
-		time.Sleep(10 * time.Second)
+		opts.Page = resp.NextPage
+	}
+
+	return authors, nil
+}
+
+// pathContributorOrgs returns a map of companies associated with each of the top
+// authors of commits touching the configured path.
+func (ghr GitHubRepository) pathContributorOrgs() (map[string]bool, error) {
+
+	authors, err := ghr.cachedPathCommitAuthors()
+	if err != nil {
+		return nil, err
+	}
+
+	var logins []string
+	for author := range authors {
+		if strings.Contains(author, "@") {
+			continue
+		}
+		logins = append(logins, author)
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if authors[logins[i]] == authors[logins[j]] {
+			return logins[i] < logins[j]
+		}
+		return authors[logins[i]] > authors[logins[j]]
+	})
+	if len(logins) > TopContributorCount {
+		logins = logins[:TopContributorCount]
+	}
+
+	orgs := make(map[string]bool)
+	for _, login := range logins {
+		user, err := ghr.lookupUser(func() (*github.User, *github.Response, error) {
+			return ghr.source.GetUser(ghr.ctx, login)
+		})
+		if err != nil {
+			continue
+		}
+		company := user.GetCompany()
+		if company == "" {
+			continue
+		}
+		orgs[ghr.normalizeOrgName(company)] = true
 	}
 
-	match := DependentsRegex.FindSubmatch(content)
+	return orgs, nil
+}
+
+// pathCommitFrequency returns the weekly average number of commits touching the
+// configured path over the last 52 weeks.
+func (ghr GitHubRepository) pathCommitFrequency() (float64, error) {
 
-	if len(match) == 0 {
-		return 0
+	opts := &github.CommitsListOptions{
+		Path:  ghr.config.Path,
+		Since: lookbackTime(52 * 7),
+		ListOptions: github.ListOptions{
+			PerPage: 1,
+		},
 	}
 
-	b := bytes.ReplaceAll(match[1], []byte(","), []byte(""))
-	b = bytes.TrimSpace(b)
-	dependentsCount, _ := strconv.Atoi(string(b))
-	return dependentsCount
+	commits, resp, err := ghr.source.ListCommits(ghr.ctx, ghr.R.GetOwner().GetLogin(), ghr.R.GetName(), opts)
+	if err != nil {
+		return 0, classifyForbidden(err)
+	}
+
+	total := totalCount(ghr.ctx, ghr.source, resp, len(commits))
+
+	return math.Round(float64(total)/52.0*10.0) / 10, nil
+}
+
+// lookupUser looks up a contributor with get, which is called at most
+// UserLookupAttempts times, and at least once. Calls are paced by UserLookupDelay, since
+// many user lookups in a row trigger GitHub's secondary (abuse) rate limit, and when it's
+// hit anyway the lookup is retried after the delay GitHub asks for, or DefaultAbuseBackoff.
+func (ghr GitHubRepository) lookupUser(get func() (*github.User, *github.Response, error)) (*github.User, error) {
+
+	attempts := ghr.config.UserLookupAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err := sleepContext(ghr.ctx, ghr.config.UserLookupDelay); err != nil {
+			return nil, err
+		}
+
+		var user *github.User
+		user, _, err = get()
+		abuseErr, ok := err.(*github.AbuseRateLimitError)
+		if !ok {
+			return user, err
+		}
+
+		if attempt < attempts {
+			backoff := DefaultAbuseBackoff
+			if abuseErr.RetryAfter != nil {
+				backoff = *abuseErr.RetryAfter
+			}
+			if err := sleepContext(ghr.ctx, backoff); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// normalizeOrgName returns the org name of a contributor's company, using the configured
+// OrgNameNormalizer or, by default, filterOrgName.
+func (ghr GitHubRepository) normalizeOrgName(company string) string {
+	if ghr.config.OrgNameNormalizer != nil {
+		return ghr.config.OrgNameNormalizer(company)
+	}
+	return filterOrgName(company)
 }
@@ -0,0 +1,387 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// newTestRepository returns the repository o/r loaded from a fake GitHub API server.
+// The server answers the rate limit and repository requests itself and passes every
+// other API request, without the /api/v3 prefix, to handler.
+func newTestRepository(t *testing.T, config ScoreConfig, handler http.HandlerFunc) GitHubRepository {
+	t.Helper()
+
+	srv := httptest.NewServer(http.StripPrefix("/api/v3", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rate_limit":
+			fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":5000}}}`)
+		case "/repos/o/r":
+			fmt.Fprint(w, `{"name":"r","full_name":"o/r","html_url":"https://github.com/o/r","owner":{"login":"o"}}`)
+		default:
+			handler(w, r)
+		}
+	})))
+	t.Cleanup(srv.Close)
+
+	config.GitHubBaseURL = srv.URL + "/api/v3/"
+	config.RequestsPerHour = 0
+	config.UserLookupDelay = 0
+
+	ghr, err := LoadRepositoryWithConfig("https://"+config.GitHubHost()+"/o/r", "tok", config)
+	if err != nil {
+		t.Fatalf("LoadRepositoryWithConfig() error = %v", err)
+	}
+	return ghr
+}
+
+// contributorsJSON returns a contributors page with n users with ids from first, and
+// anon anonymous contributors.
+func contributorsJSON(first, n, anon int) string {
+	var items []string
+	for i := 0; i < n; i++ {
+		items = append(items, fmt.Sprintf(`{"id":%d,"login":"u%d","type":"User"}`, first+i, first+i))
+	}
+	for i := 0; i < anon; i++ {
+		items = append(items, fmt.Sprintf(`{"name":"anon%d","type":"Anonymous"}`, i))
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestIncludeAnonymousContributors(t *testing.T) {
+
+	for _, includeAnonymous := range []bool{true, false} {
+		t.Run(fmt.Sprintf("include anonymous %v", includeAnonymous), func(t *testing.T) {
+
+			var anonParams []string
+			var lookups int
+			config := DefaultScoreConfig()
+			config.IncludeAnonymous = includeAnonymous
+
+			ghr := newTestRepository(t, config, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/repos/o/r/contributors":
+					anonParams = append(anonParams, r.URL.Query().Get("anon"))
+					if includeAnonymous {
+						fmt.Fprint(w, contributorsJSON(1, 2, 1))
+					} else {
+						fmt.Fprint(w, contributorsJSON(1, 2, 0))
+					}
+				case strings.HasPrefix(r.URL.Path, "/user/"):
+					lookups++
+					fmt.Fprint(w, `{"company":"Acme Inc."}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+
+			want := 2
+			if includeAnonymous {
+				want = 3
+			}
+			count, err := ghr.Contributors()
+			if err != nil || count != want {
+				t.Errorf("Contributors() = %d, %v, want %d", count, err, want)
+			}
+
+			orgs, err := ghr.ContributorOrgs()
+			if err != nil || len(orgs) != 1 {
+				t.Errorf("ContributorOrgs() = %v, %v, want a single org", orgs, err)
+			}
+			if lookups != 2 {
+				t.Errorf("looked up %d users, want 2, without the anonymous contributor", lookups)
+			}
+
+			for _, anon := range anonParams {
+				if anon != fmt.Sprint(includeAnonymous) {
+					t.Errorf("anon = %q, want %v in every contributors request", anon, includeAnonymous)
+				}
+			}
+		})
+	}
+}
+
+func TestCommentFrequency(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		issueCount int
+		want       float64
+		wantCalls  int
+	}{
+		{name: "no issues", issueCount: 0, want: 0, wantCalls: 0},
+		{name: "comments per issue", issueCount: 10, want: 3.7, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			calls := 0
+			ghr := newTestRepository(t, DefaultScoreConfig(), func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/o/r/issues/comments" {
+					t.Errorf("requested %s, want the repository-wide issue comments", r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if r.URL.Query().Get("since") == "" {
+					t.Error("comments requested without since")
+				}
+				calls++
+				w.Header().Set("Link", `<https://api.github.com/repositories/1/issues/comments?per_page=1&page=2>; rel="next", <https://api.github.com/repositories/1/issues/comments?per_page=1&page=37>; rel="last"`)
+				fmt.Fprint(w, `[{"id":1}]`)
+			})
+
+			got, err := ghr.CommentFrequency(tt.issueCount)
+			if err != nil || got != tt.want {
+				t.Errorf("CommentFrequency(%d) = %v, %v, want %v", tt.issueCount, got, err, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("made %d comments requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLookupUserSecondaryRateLimit(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		attempts  int
+		limited   int
+		wantCalls int
+		wantUser  bool
+	}{
+		{name: "retried after the secondary rate limit", attempts: 3, limited: 1, wantCalls: 2, wantUser: true},
+		{name: "gives up after the last attempt", attempts: 2, limited: 5, wantCalls: 2},
+		{name: "zero attempts looks up once", attempts: 0, wantCalls: 1, wantUser: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			config := DefaultScoreConfig()
+			config.UserLookupAttempts = tt.attempts
+			config.UserLookupDelay = 0
+			ghr := GitHubRepository{ctx: context.Background(), config: config}
+
+			calls := 0
+			noDelay := time.Duration(0)
+			user, err := ghr.lookupUser(func() (*github.User, *github.Response, error) {
+				calls++
+				if calls <= tt.limited {
+					return nil, nil, &github.AbuseRateLimitError{Message: "secondary rate limit", RetryAfter: &noDelay}
+				}
+				return &github.User{Company: github.String("Acme")}, nil, nil
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("looked up the user %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantUser {
+				if err != nil || user.GetCompany() != "Acme" {
+					t.Errorf("lookupUser() = %v, %v, want the user", user, err)
+				}
+			} else if !Retryable(err) {
+				t.Errorf("lookupUser() error = %v, want the retryable rate limit error", err)
+			}
+		})
+	}
+}
+
+func TestContributorOrgsSkipsFailedLookups(t *testing.T) {
+
+	ghr := newTestRepository(t, DefaultScoreConfig(), func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/contributors":
+			fmt.Fprint(w, contributorsJSON(1, 2, 0))
+		case "/user/1":
+			fmt.Fprint(w, `{"company":"Acme"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	orgs, err := ghr.ContributorOrgs()
+	if err != nil || len(orgs) != 1 || !orgs["acme"] {
+		t.Errorf("ContributorOrgs() = %v, %v, want only acme", orgs, err)
+	}
+}
+
+func TestOrgNameNormalizer(t *testing.T) {
+
+	companies := map[string]string{"/user/1": "Google LLC", "/user/2": "@YouTube", "/user/3": "Acme, Inc."}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/o/r/contributors" {
+			fmt.Fprint(w, contributorsJSON(1, 3, 0))
+			return
+		}
+		fmt.Fprintf(w, `{"company":%q}`, companies[r.URL.Path])
+	}
+
+	ghr := newTestRepository(t, DefaultScoreConfig(), handler)
+	orgs, err := ghr.ContributorOrgs()
+	if err != nil || len(orgs) != 3 || !orgs["google"] || !orgs["youtube"] || !orgs["acme"] {
+		t.Errorf("ContributorOrgs() = %v, %v, want google, youtube and acme with the built-in normalization", orgs, err)
+	}
+
+	config := DefaultScoreConfig()
+	config.OrgNameNormalizer = func(company string) string {
+		if strings.Contains(company, "YouTube") {
+			return "google"
+		}
+		return filterOrgName(company)
+	}
+	ghr = newTestRepository(t, config, handler)
+	orgs, err = ghr.ContributorOrgs()
+	if err != nil || len(orgs) != 2 || !orgs["google"] || !orgs["acme"] {
+		t.Errorf("ContributorOrgs() = %v, %v, want google and acme with the custom normalizer", orgs, err)
+	}
+}
+
+func TestContributorOrgsFewContributors(t *testing.T) {
+
+	lookups := 0
+	ghr := newTestRepository(t, DefaultScoreConfig(), func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/contributors":
+			fmt.Fprint(w, contributorsJSON(1, 3, 0))
+		case strings.HasPrefix(r.URL.Path, "/user/"):
+			lookups++
+			fmt.Fprintf(w, `{"company":"Org %s"}`, strings.TrimPrefix(r.URL.Path, "/user/"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	orgs, err := ghr.ContributorOrgs()
+	if err != nil || len(orgs) != 3 || !orgs["org1"] || !orgs["org2"] || !orgs["org3"] {
+		t.Errorf("ContributorOrgs() = %v, %v, want org1, org2 and org3", orgs, err)
+	}
+	if lookups != 3 {
+		t.Errorf("looked up %d users, want all 3 contributors", lookups)
+	}
+}
+
+func TestContributorOrgsManyContributors(t *testing.T) {
+
+	// A repository with more than 5000 contributors, 25 per page.
+	const pages = 201
+	var pagesRequested, lookups int
+	ghr := newTestRepository(t, DefaultScoreConfig(), func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/contributors":
+			pagesRequested++
+			page := 1
+			fmt.Sscan(r.URL.Query().Get("page"), &page)
+			if page < pages {
+				w.Header().Set("Link", fmt.Sprintf(`<http://%s/api/v3/repos/o/r/contributors?per_page=25&page=%d>; rel="next", <http://%s/api/v3/repos/o/r/contributors?per_page=25&page=%d>; rel="last"`, r.Host, page+1, r.Host, pages))
+			}
+			fmt.Fprint(w, contributorsJSON((page-1)*25+1, 25, 0))
+		case strings.HasPrefix(r.URL.Path, "/user/"):
+			lookups++
+			fmt.Fprintf(w, `{"company":"Org %s"}`, strings.TrimPrefix(r.URL.Path, "/user/"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	orgs, err := ghr.ContributorOrgs()
+	if err != nil {
+		t.Fatalf("ContributorOrgs() error = %v", err)
+	}
+	if len(orgs) != TopContributorCount {
+		t.Errorf("ContributorOrgs() returned %d orgs, want one per top contributor, %v", len(orgs), TopContributorCount)
+	}
+	for i := 1; i <= TopContributorCount; i++ {
+		if name := fmt.Sprintf("org%d", i); !orgs[name] {
+			t.Errorf("ContributorOrgs() = %v, missing %s", orgs, name)
+		}
+	}
+	if lookups != TopContributorCount {
+		t.Errorf("looked up %d users, want the top %v contributors", lookups, TopContributorCount)
+	}
+	if pagesRequested != 1 {
+		t.Errorf("requested %d contributor pages, want only the page with the top contributors", pagesRequested)
+	}
+}
+
+func TestCommitActivityTotal(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+		wantErr error
+	}{
+		{
+			name: "weekly totals",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `[{"total":3},{"total":4}]`)
+			},
+			want: 7,
+		},
+		{
+			name: "statistics being calculated",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+			wantErr: ErrCommitFrequencyBeingCalculated,
+		},
+		{
+			name: "no response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.Close()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ghr := newTestRepository(t, DefaultScoreConfig(), func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/o/r/stats/commit_activity" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				tt.handler(w, r)
+			})
+
+			got, err := ghr.commitActivityTotal()
+			switch {
+			case tt.want > 0 && (err != nil || got != tt.want):
+				t.Errorf("commitActivityTotal() = %d, %v, want %d", got, err, tt.want)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("commitActivityTotal() error = %v, want %v", err, tt.wantErr)
+			case tt.want == 0 && err == nil:
+				t.Errorf("commitActivityTotal() = %d, want an error", got)
+			}
+		})
+	}
+}
@@ -18,14 +18,22 @@
 package criticalityscore
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -33,26 +41,72 @@ import (
 var (
 	ErrUnknownOutputFormat error = fmt.Errorf("unknown output format")
 	ErrInvalidParamFormat  error = fmt.Errorf("invalid param format")
+	ErrUnknownField        error = fmt.Errorf("unknown output field")
 )
 
+// OutputFormats lists the formats supported by PrintScore.
+var OutputFormats = []string{"default", "csv", "csv-table", "json", "markdown", "github-summary", "openssf"}
+
 type Score struct {
-	Name                string  `json:"name"`
-	URL                 string  `json:"url"`
-	Language            string  `json:"language"`
-	CreatedSince        int     `json:"created_since"`
-	UpdatedSince        int     `json:"updated_since"`
-	ContributorCount    int     `json:"contributor_count"`
-	OrgCount            int     `json:"org_count"`
-	CommitFrequency     float64 `json:"commit_frequency"`
-	RecentReleasesCount int     `json:"recent_releases_count"`
-	ClosedIssuesCount   int     `json:"closed_issues_count"`
-	UpdatedIssuesCount  int     `json:"updated_issues_count"`
-	CommentFrequency    float64 `json:"comment_frequency"`
-	DependentsCount     int     `json:"dependents_count"`
-	CriticalityScore    float64 `json:"criticality_score"`
-	ScoredOn            string  `json:"scored_on"`
+	Name                string                        `json:"name"`
+	URL                 string                        `json:"url"`
+	Language            string                        `json:"language"`
+	License             string                        `json:"license,omitempty"`
+	Path                string                        `json:"path,omitempty"`
+	PathNote            string                        `json:"path_note,omitempty"`
+	IssuesEnabled       bool                          `json:"issues_enabled"`
+	Reliable            bool                          `json:"reliable"`
+	CreatedSince        int                           `json:"created_since"`
+	UpdatedSince        int                           `json:"updated_since"`
+	ContributorCount    int                           `json:"contributor_count"`
+	OrgCount            int                           `json:"org_count"`
+	CommitFrequency     float64                       `json:"commit_frequency"`
+	RecentReleasesCount int                           `json:"recent_releases_count"`
+	ClosedIssuesCount   int                           `json:"closed_issues_count"`
+	UpdatedIssuesCount  int                           `json:"updated_issues_count"`
+	CommentFrequency    float64                       `json:"comment_frequency"`
+	DependentsCount     int                           `json:"dependents_count"`
+	StarsCount          int                           `json:"stars_count"`
+	ForksCount          int                           `json:"forks_count"`
+	WatchersCount       int                           `json:"watchers_count"`
+	ChurnFilesCount     *int                          `json:"churn_files_count,omitempty"`
+	StarGrowth          *float64                      `json:"star_growth,omitempty"`
+	SignedCommitsRatio  *float64                      `json:"signed_commits_ratio,omitempty"`
+	ReleaseContributors *int                          `json:"release_contributors_count,omitempty"`
+	CommitAuthors       *int                          `json:"recent_commit_authors_count,omitempty"`
+	BusFactor           *int                          `json:"bus_factor,omitempty"`
+	OpenPullRequests    *int                          `json:"open_pull_requests_count,omitempty"`
+	MergedPullRequests  *int                          `json:"merged_pull_requests_count,omitempty"`
+	PullRequestMerges   *float64                      `json:"pull_request_merge_rate,omitempty"`
+	WikiEnabled         *bool                         `json:"wiki_enabled,omitempty"`
+	DiscussionsEnabled  *bool                         `json:"discussions_enabled,omitempty"`
+	Funded              *bool                         `json:"funded,omitempty"`
+	FundingPlatforms    []string                      `json:"funding_platforms,omitempty"`
+	Licensed            *bool                         `json:"licensed,omitempty"`
+	SecurityPolicy      *bool                         `json:"security_policy,omitempty"`
+	CI                  *bool                         `json:"ci_configured,omitempty"`
+	CriticalityScore    float64                       `json:"criticality_score"`
+	BelowThreshold      bool                          `json:"below_threshold,omitempty"`
+	Tier                string                        `json:"tier,omitempty"`
+	Scale               string                        `json:"scale,omitempty"`
+	ParamScores         map[string]float64            `json:"param_scores,omitempty"`
+	Explanation         map[string]MetricContribution `json:"explanation,omitempty"`
+	Unavailable         []string                      `json:"unavailable,omitempty"`
+	Warnings            []string                      `json:"warnings,omitempty"`
+	IssueLookbackDays   float64                       `json:"issue_lookback_days"`
+	ReleaseLookbackDays float64                       `json:"release_lookback_days"`
+	ScoredOn            string                        `json:"scored_on"`
+	Hash                string                        `json:"content_hash"`
+	Repository          *RepositoryMetadata           `json:"repository,omitempty"`
+	Scorecard           *ScorecardResult              `json:"scorecard,omitempty"`
+	// OutputFields limits the output of the score to these json field names, in every
+	// format but openssf. All fields are output if it's empty.
+	OutputFields []string `json:"-"`
 }
 
+// ParamScore returns the log-scaled ratio of a metric value to its max threshold, from 0
+// to 1, times weight. The value is clamped to between 0 and maxValue, and a metric
+// without a positive, finite max threshold scores 0, so the result is never NaN.
 func ParamScore(param interface{}, maxValue, weight float64) float64 {
 	var p float64
 	switch v := param.(type) {
@@ -61,145 +115,767 @@ func ParamScore(param interface{}, maxValue, weight float64) float64 {
 	case int:
 		p = float64(v)
 	}
-	return math.Log(1.0+p) / math.Log(1.0+math.Max(p, maxValue)) * weight
+	if !(maxValue > 0) || math.IsInf(maxValue, 1) || math.IsNaN(p) {
+		return 0
+	}
+	p = math.Min(math.Max(p, 0), maxValue)
+	return math.Log(1.0+p) / math.Log(1.0+maxValue) * weight
 }
 
+// AdditionalParam is a weighted value included in the criticality score besides the
+// built-in metrics.
 type AdditionalParam struct {
 	Value        float64
 	Weight       float64
 	MaxThreshold float64
 }
 
+// RepositoryStats collects the metrics of a repository and returns its Score. The API
+// requests are made with the context the repository was loaded with; if it's done
+// before all metrics are collected, the error wraps the context error.
 func RepositoryStats(ghr GitHubRepository, params []string) (Score, error) {
+	return repositoryStats(ghr.ctx, ghr, ghr.config, params)
+}
+
+// repositoryStats collects the metrics of a repository, making its API requests with ctx,
+// and returns its Score, scored with config. If ctx is done before all metrics are
+// collected, the error wraps the context error.
+func repositoryStats(ctx context.Context, ghr GitHubRepository, config ScoreConfig, params []string) (Score, error) {
+
+	ghr = ghr.withContextConfig(ctx, config)
+	r := ghr.R
 
 	additionalParams, err := parseAdditionalParams(params)
 	if err != nil {
-		return Score{}, fmt.Errorf("%s : %s", ErrInvalidParamFormat.Error(), err.Error())
+		return Score{}, fmt.Errorf("%w : %s", ErrInvalidParamFormat, err.Error())
 	}
 
-	additionalParamsTotalWeight := 0.0
-	additionalParamsScore := 0.0
+	score := Score{
+		Name:          r.GetName(),
+		URL:           r.GetHTMLURL(),
+		Language:      r.GetLanguage(),
+		License:       licenseID(r),
+		IssuesEnabled: ghr.IssuesEnabled(),
+		Reliable:      ghr.authed,
+		StarsCount:    ghr.StargazersCount(),
+		ForksCount:    ghr.ForksCount(),
+		WatchersCount: ghr.WatchersCount(),
 
-	for _, param := range additionalParams {
-		additionalParamsTotalWeight += param.Weight
-		additionalParamsScore += ParamScore(param.Value, param.MaxThreshold, param.Weight)
+		IssueLookbackDays:   config.IssueLookbackDays,
+		ReleaseLookbackDays: config.ReleaseLookbackDays,
 	}
 
-	score := Score{
-		Name:     ghr.R.GetName(),
-		URL:      ghr.R.GetHTMLURL(),
-		Language: ghr.R.GetLanguage(),
+	if config.Path != "" {
+		score.Path = config.Path
+		score.PathNote = "commit frequency, updated since and contributor metrics are scoped to the path; all other metrics are for the whole repository"
+	}
+
+	model := config.Model
+	if model == nil {
+		model = DefaultModel
 	}
 
 	wg := new(sync.WaitGroup)
-	wg.Add(9)
+	notes := new(scoreNotes)
 
-	go func() {
-		score.CreatedSince = ghr.CreatedSince()
-		wg.Done()
-	}()
+	// Metrics that aren't among the configured Metrics are left out from the start, so
+	// short-circuiting doesn't count on them either.
+	for _, name := range config.skippedMetrics() {
+		notes.markUnavailable(name)
+	}
 
-	go func() {
-		score.UpdatedSince = ghr.UpdatedSince()
-		wg.Done()
-	}()
+	// With GraphQL, several metrics are collected with a single query, and any metric
+	// it fails for is collected with the REST API instead.
+	if ghr.gitlab == nil && config.GraphQL {
+		if stats, err := ghr.loadGraphQLStats(); err == nil {
+			ghr.graphql = stats
+		} else if ctx.Err() == nil {
+			notes.warn("graphql query failed, metrics are collected with the rest api : %s", err.Error())
+		}
+	}
 
-	go func() {
-		score.ContributorCount = ghr.Contributors()
-		wg.Done()
-	}()
+	// GitLab has no data for some metrics, which are left out from the start.
+	if ghr.gitlab != nil {
+		for _, name := range gitLabUnsupportedMetrics {
+			notes.markUnavailable(name)
+		}
+		notes.warn("scored from gitlab, which has no data for %s", strings.Join(gitLabUnsupportedMetrics, " or "))
+	}
 
-	go func() {
-		score.OrgCount = len(ghr.ContributorOrgs())
-		wg.Done()
-	}()
+	var skipped []string
+	collectCheapMetrics(ghr, config, &score, wg, notes)
 
-	go func() {
-		score.CommitFrequency = ghr.CommitFrequency()
-		wg.Done()
-	}()
+	// With short-circuiting, the expensive metrics are only collected if the repository
+	// can still reach the fail-under score.
+	if config.ShortCircuit && config.FailUnder > 0 {
+		wg.Wait()
+		var optimistic Score
+		optimistic, skipped = optimisticScore(score, config)
+		optimistic.Unavailable = notes.sortedUnavailable()
+		bound := ScaleScore(model.Score(optimistic, additionalParams, config), model, optimistic, additionalParams, config)
+		if bound >= config.FailUnder {
+			skipped = nil
+		}
+	}
 
-	go func() {
-		score.RecentReleasesCount = ghr.RecentReleases()
-		wg.Done()
-	}()
+	if skipped == nil {
+		collectExpensiveMetrics(ghr, config, &score, wg, notes)
+		wg.Wait()
+	}
 
-	go func() {
-		score.ClosedIssuesCount = ghr.ClosedIssues()
-		wg.Done()
-	}()
+	if err := ctx.Err(); err != nil {
+		return Score{}, fmt.Errorf("repository stats of %s incomplete : %w", r.GetFullName(), err)
+	}
 
-	go func() {
-		score.UpdatedIssuesCount = ghr.UpdatedIssues()
-		score.CommentFrequency = ghr.CommentFrequency(score.UpdatedIssuesCount)
-		wg.Done()
-	}()
+	if err := notes.err(); err != nil {
+		return Score{}, err
+	}
+
+	score.Unavailable = notes.sortedUnavailable()
+	score.Warnings = append(scoreWarnings(ghr, config, score), notes.sortedWarnings()...)
 
+	// A short-circuited score is the highest score the repository could have reached.
+	scored := score
+	if skipped != nil {
+		scored, _ = optimisticScore(score, config)
+		score.Warnings = append(score.Warnings, fmt.Sprintf("can't reach the fail-under score of %v, skipped %s and scored the highest achievable score", config.FailUnder, strings.Join(skipped, ", ")))
+	}
+
+	raw := model.Score(scored, additionalParams, config)
+	if config.Scale != ScaleRaw {
+		score.Scale = config.Scale
+	}
+	if clamped, ok := clampScore(raw, config); ok {
+		score.Warnings = append(score.Warnings, fmt.Sprintf("criticality score %0.5f was clamped to %v", raw, clamped))
+		raw = clamped
+	}
+	score.CriticalityScore = math.Round(ScaleScore(raw, model, scored, additionalParams, config)*100000) / 100000
+	score.BelowThreshold = config.FailUnder > 0 && score.CriticalityScore < config.FailUnder
+	if config.Tiers {
+		score.Tier = config.Tier(score.CriticalityScore)
+	}
+
+	if config.ParamScores && skipped == nil {
+		score.ParamScores = ParamScores(score, additionalParams, config)
+	}
+	if config.Explain && skipped == nil {
+		score.Explanation = Explain(score, additionalParams, config)
+	}
+
+	score.ScoredOn = config.scoredOn(time.Now())
+
+	if config.IncludeRepository {
+		score.Repository = newRepositoryMetadata(r)
+	}
+
+	score.Hash = score.ContentHash()
+	score.OutputFields = config.Fields
+
+	return score, nil
+}
+
+// clampScore returns a raw score clamped to [config.ScoreMin, config.ScoreMax], and
+// whether it was out of that range. Unit and percent scores are always within their
+// range, so only raw scores are clamped, and a ScoreMax that isn't above ScoreMin
+// disables clamping.
+func clampScore(raw float64, config ScoreConfig) (float64, bool) {
+
+	if config.Scale != "" && config.Scale != ScaleRaw {
+		return raw, false
+	}
+	if config.ScoreMax <= config.ScoreMin {
+		return raw, false
+	}
+
+	clamped := math.Min(math.Max(raw, config.ScoreMin), config.ScoreMax)
+	return clamped, clamped != raw
+}
+
+// RepositoryStatsWithContext returns the Score of a repository like RepositoryStats,
+// making all API requests with ctx instead of the context the repository was loaded
+// with. Metrics in progress return early when ctx is done, and the error then wraps
+// ctx.Err(), e.g. context.DeadlineExceeded.
+func RepositoryStatsWithContext(ctx context.Context, ghr GitHubRepository, params []string) (Score, error) {
+	return repositoryStats(ctx, ghr, ghr.config, params)
+}
+
+// RepositoryStatsWithConfig returns the Score of a repository like RepositoryStats,
+// scored with config instead of the config the repository was loaded with, e.g. to score
+// a repository with other weights and thresholds without loading it again. The API
+// client, with its token, base url and cache, is still the one it was loaded with.
+func RepositoryStatsWithConfig(ghr GitHubRepository, params []string, config ScoreConfig) (Score, error) {
+	return repositoryStats(ghr.ctx, ghr, config, params)
+}
+
+// issueMetrics are the metrics that are unavailable when issues are disabled.
+var issueMetrics = []string{"closed_issues_count", "updated_issues_count", "comment_frequency"}
+
+// collectMetric runs a metric concurrently, unless it isn't among the configured
+// Metrics, in which case it's marked as unavailable without any API requests.
+func collectMetric(config ScoreConfig, wg *sync.WaitGroup, notes *scoreNotes, name string, metric func()) {
+	if !config.metricEnabled(name) {
+		notes.markUnavailable(name)
+		return
+	}
+	wg.Add(1)
 	go func() {
-		score.DependentsCount = ghr.Dependents()
-		wg.Done()
+		defer wg.Done()
+		metric()
 	}()
+}
+
+// collectCheapMetrics collects the metrics that take a single or few API calls each.
+func collectCheapMetrics(ghr GitHubRepository, config ScoreConfig, score *Score, wg *sync.WaitGroup, notes *scoreNotes) {
+
+	collectMetric(config, wg, notes, "created_since", func() {
+		score.CreatedSince = ghr.CreatedSince()
+	})
+
+	collectMetric(config, wg, notes, "updated_since", func() {
+		updatedSince, err := ghr.UpdatedSince()
+		score.UpdatedSince = updatedSince
+		notes.fail("updated_since", err)
+	})
+
+	collectMetric(config, wg, notes, "contributor_count", func() {
+		contributorCount, err := ghr.Contributors()
+		score.ContributorCount = contributorCount
+		notes.fail("contributor_count", err)
+	})
+
+	collectMetric(config, wg, notes, "commit_frequency", func() {
+		commitFrequency, err := ghr.CommitFrequency()
+		score.CommitFrequency = commitFrequency
+		notes.fail("commit_frequency", err)
+	})
 
-	wg.Wait()
+	// Issue-based metrics are unavailable when issues are disabled (e.g. the project
+	// uses an external tracker), so they're left out rather than scored as zero.
+	if score.IssuesEnabled {
+		collectMetric(config, wg, notes, "closed_issues_count", func() {
+			closedIssues, err := ghr.ClosedIssues()
+			score.ClosedIssuesCount = closedIssues
+			notes.fail("closed_issues_count", err)
+		})
 
-	if ghr.Error != nil {
-		return Score{}, ghr.Error
+		// The comment frequency is relative to the updated issues count, which is
+		// collected for it even if only the comment frequency is among the Metrics.
+		updatedIssues := config.metricEnabled("updated_issues_count")
+		commentFrequency := config.metricEnabled("comment_frequency")
+		if !updatedIssues {
+			notes.markUnavailable("updated_issues_count")
+		}
+		if !commentFrequency {
+			notes.markUnavailable("comment_frequency")
+		}
+		if updatedIssues || commentFrequency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				updatedIssuesCount, err := ghr.UpdatedIssues()
+				if err != nil {
+					if updatedIssues {
+						notes.fail("updated_issues_count", err)
+					} else {
+						notes.fail("comment_frequency", err)
+					}
+					return
+				}
+				if updatedIssues {
+					score.UpdatedIssuesCount = updatedIssuesCount
+				}
+				if commentFrequency {
+					frequency, err := ghr.CommentFrequency(updatedIssuesCount)
+					score.CommentFrequency = frequency
+					notes.fail("comment_frequency", err)
+				}
+			}()
+		}
+	} else {
+		for _, name := range issueMetrics {
+			notes.markUnavailable(name)
+		}
 	}
 
-	totalWeight := CreatedSinceWeight + UpdatedSinceWeight +
-		ContributorCountWeight + OrgCountWeight +
-		CommitFrequencyWeight + RecentReleasesWeight +
-		ClosedIssuesWeight + UpdatedIssuesWeight +
-		CommentFrequencyWeight + DependentsCountWeight +
-		additionalParamsTotalWeight
+	if config.CommunitySignals {
+		if config.metricEnabled("wiki_enabled") {
+			wikiEnabled := ghr.WikiEnabled()
+			score.WikiEnabled = &wikiEnabled
+		} else {
+			notes.markUnavailable("wiki_enabled")
+		}
 
-	score.CriticalityScore = math.Round((ParamScore(score.CreatedSince, CreatedSinceThreshold, CreatedSinceWeight)+
-		ParamScore(score.UpdatedSince, UpdatedSinceThreshold, UpdatedSinceWeight)+
-		ParamScore(score.ContributorCount, ContributorCountThreshold, ContributorCountWeight)+
-		ParamScore(score.OrgCount, OrgCountThreshold, OrgCountWeight)+
-		ParamScore(score.CommitFrequency, CommitFrequencyThreshold, CommitFrequencyWeight)+
-		ParamScore(score.RecentReleasesCount, RecentReleasesThreshold, RecentReleasesWeight)+
-		ParamScore(score.ClosedIssuesCount, ClosedIssuesThreshold, ClosedIssuesWeight)+
-		ParamScore(score.UpdatedIssuesCount, UpdatedIssuesThreshold, UpdatedIssuesWeight)+
-		ParamScore(score.CommentFrequency, CommentFrequencyThreshold, CommentFrequencyWeight)+
-		ParamScore(score.DependentsCount, DependentsCountThreshold, DependentsCountWeight)+
-		additionalParamsScore)/totalWeight*100000) / 100000
+		collectMetric(config, wg, notes, "discussions_enabled", func() {
+			if discussionsEnabled, err := ghr.DiscussionsEnabled(); err == nil {
+				score.DiscussionsEnabled = &discussionsEnabled
+			} else {
+				notes.failOptional("discussions_enabled", err)
+			}
+		})
+	}
 
-	score.ScoredOn = time.Now().UTC().Format(time.UnixDate)
+	// GitLab doesn't detect the license of a project.
+	if config.License {
+		if config.metricEnabled("licensed") && ghr.gitlab == nil {
+			licensed := score.License != ""
+			score.Licensed = &licensed
+		} else {
+			notes.markUnavailable("licensed")
+		}
+	}
 
-	return score, nil
+	if config.SecurityPolicy {
+		collectMetric(config, wg, notes, "security_policy", func() {
+			if hasPolicy, err := ghr.HasSecurityPolicy(); err == nil {
+				score.SecurityPolicy = &hasPolicy
+			} else {
+				notes.failOptional("security_policy", err)
+			}
+		})
+	}
+
+	if config.CI {
+		collectMetric(config, wg, notes, "ci_configured", func() {
+			if hasCI, err := ghr.HasCI(); err == nil {
+				score.CI = &hasCI
+			} else {
+				notes.failOptional("ci_configured", err)
+			}
+		})
+	}
+
+	if config.Funding {
+		collectMetric(config, wg, notes, "funded", func() {
+			if platforms, err := ghr.Funding(); err == nil {
+				funded := len(platforms) > 0
+				score.Funded = &funded
+				score.FundingPlatforms = platforms
+			} else {
+				notes.failOptional("funded", err)
+			}
+		})
+	}
+
+	if config.SignedCommits {
+		collectMetric(config, wg, notes, "signed_commits_ratio", func() {
+			if ratio, err := ghr.SignedCommits(); err == nil {
+				score.SignedCommitsRatio = &ratio
+			} else {
+				notes.failOptional("signed_commits_ratio", err)
+			}
+		})
+	}
+
+	// Search-based metrics are skipped when the search quota is not to be used.
+	if config.skipsDependents() {
+		notes.markUnavailable("dependents_count")
+	}
+}
+
+// collectExpensiveMetrics collects the metrics that page through results or make an
+// API call per contributor, commit or search.
+func collectExpensiveMetrics(ghr GitHubRepository, config ScoreConfig, score *Score, wg *sync.WaitGroup, notes *scoreNotes) {
+
+	collectMetric(config, wg, notes, "org_count", func() {
+		orgs, err := ghr.ContributorOrgs()
+		score.OrgCount = len(orgs)
+		notes.fail("org_count", err)
+	})
+
+	collectMetric(config, wg, notes, "recent_releases_count", func() {
+		recentReleases, estimated, err := ghr.RecentReleasesEstimate()
+		score.RecentReleasesCount = recentReleases
+		notes.fail("recent_releases_count", err)
+		if estimated {
+			notes.warn("no releases in the last %0.0f days, recent_releases_count is estimated from tags", config.ReleaseLookbackDays)
+		}
+	})
+
+	if !config.skipsDependents() {
+		collectMetric(config, wg, notes, "dependents_count", func() {
+			dependentsCount, err := ghr.Dependents()
+			score.DependentsCount = dependentsCount
+			if errors.Is(err, ErrDependentsNoMatch) {
+				notes.markUnavailable("dependents_count")
+			} else {
+				notes.fail("dependents_count", err)
+			}
+		})
+	}
+
+	if config.Churn {
+		collectMetric(config, wg, notes, "churn_files_count", func() {
+			if churn, err := ghr.Churn(); err == nil {
+				score.ChurnFilesCount = &churn
+			} else {
+				notes.failOptional("churn_files_count", err)
+			}
+		})
+	}
+
+	if config.StarGrowth {
+		collectMetric(config, wg, notes, "star_growth", func() {
+			if growth, err := ghr.StarGrowth(); err == nil {
+				score.StarGrowth = &growth
+			} else {
+				notes.failOptional("star_growth", err)
+			}
+		})
+	}
+
+	if config.ReleaseContributors {
+		collectMetric(config, wg, notes, "release_contributors_count", func() {
+			if contributors, err := ghr.ReleaseContributors(); err == nil {
+				score.ReleaseContributors = &contributors
+			} else {
+				notes.failOptional("release_contributors_count", err)
+			}
+		})
+	}
+
+	if config.CommitAuthors {
+		collectMetric(config, wg, notes, "recent_commit_authors_count", func() {
+			if authors, err := ghr.RecentCommitAuthors(); err == nil {
+				score.CommitAuthors = &authors
+			} else {
+				notes.failOptional("recent_commit_authors_count", err)
+			}
+		})
+		collectMetric(config, wg, notes, "bus_factor", func() {
+			if busFactor, err := ghr.BusFactor(); err == nil {
+				score.BusFactor = &busFactor
+			} else {
+				notes.failOptional("bus_factor", err)
+			}
+		})
+	}
+
+	if config.PullRequests {
+		collectMetric(config, wg, notes, "open_pull_requests_count", func() {
+			if open, err := ghr.OpenPullRequests(); err == nil {
+				score.OpenPullRequests = &open
+			} else {
+				notes.failOptional("open_pull_requests_count", err)
+			}
+		})
+		collectMetric(config, wg, notes, "merged_pull_requests_count", func() {
+			if merged, err := ghr.MergedPullRequests(); err == nil {
+				score.MergedPullRequests = &merged
+			} else {
+				notes.failOptional("merged_pull_requests_count", err)
+			}
+		})
+		collectMetric(config, wg, notes, "pull_request_merge_rate", func() {
+			if rate, err := ghr.PullRequestMergeRate(); err == nil {
+				score.PullRequestMerges = &rate
+			} else {
+				notes.failOptional("pull_request_merge_rate", err)
+			}
+		})
+	}
+
+	if config.Scorecard {
+		wg.Add(1)
+		go func() {
+			scorecard, err := ghr.Scorecard()
+			if err != nil {
+				notes.warn("scorecard is unavailable: %s", err.Error())
+			}
+			score.Scorecard = scorecard
+			wg.Done()
+		}()
+	}
+}
+
+// optimisticScore returns a copy of a score with the expensive metrics set to their
+// best values, i.e. the max threshold for metrics with a positive weight and zero
+// otherwise, and the names of those metrics. The score of the copy is the highest
+// score the repository can reach.
+func optimisticScore(score Score, config ScoreConfig) (Score, []string) {
+
+	best := func(weight, threshold float64) float64 {
+		if weight < 0 {
+			return 0
+		}
+		return threshold
+	}
+
+	w := config.Weights
+	t := config.Thresholds
+
+	score.OrgCount = int(math.Ceil(best(w.OrgCount, t.OrgCount)))
+	score.RecentReleasesCount = int(math.Ceil(best(w.RecentReleases, t.RecentReleases)))
+	skipped := []string{"org_count", "recent_releases_count"}
+
+	if !config.skipsDependents() {
+		score.DependentsCount = int(math.Ceil(best(w.DependentsCount, t.DependentsCount)))
+		skipped = append(skipped, "dependents_count")
+	}
+	if config.Churn {
+		churn := int(math.Ceil(best(w.ChurnFilesCount, t.ChurnFilesCount)))
+		score.ChurnFilesCount = &churn
+		skipped = append(skipped, "churn_files_count")
+	}
+	if config.StarGrowth {
+		growth := best(w.StarGrowth, t.StarGrowth)
+		score.StarGrowth = &growth
+		skipped = append(skipped, "star_growth")
+	}
+	if config.ReleaseContributors {
+		contributors := int(math.Ceil(best(w.ReleaseContributors, t.ReleaseContributors)))
+		score.ReleaseContributors = &contributors
+		skipped = append(skipped, "release_contributors_count")
+	}
+	if config.CommitAuthors {
+		authors := int(math.Ceil(best(w.CommitAuthors, t.CommitAuthors)))
+		busFactor := int(math.Ceil(best(w.BusFactor, t.BusFactor)))
+		score.CommitAuthors, score.BusFactor = &authors, &busFactor
+		skipped = append(skipped, "recent_commit_authors_count", "bus_factor")
+	}
+	if config.PullRequests {
+		open := int(math.Ceil(best(w.OpenPullRequests, t.OpenPullRequests)))
+		merged := int(math.Ceil(best(w.MergedPullRequests, t.MergedPullRequests)))
+		rate := best(w.PullRequestMerges, t.PullRequestMerges)
+		score.OpenPullRequests, score.MergedPullRequests, score.PullRequestMerges = &open, &merged, &rate
+		skipped = append(skipped, "open_pull_requests_count", "merged_pull_requests_count", "pull_request_merge_rate")
+	}
+
+	return score, skipped
+}
+
+// scoreNotes collects the caveats and errors reported by metrics, which are collected
+// concurrently. It's safe for use by multiple goroutines.
+type scoreNotes struct {
+	mu          sync.Mutex
+	unavailable []string
+	warnings    []string
+	errs        MetricErrors
+}
+
+// fail records the error of a metric, if any. A metric that returned
+// ErrMetricUnavailable is left out of the score instead.
+func (n *scoreNotes) fail(name string, err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ErrMetricUnavailable) {
+		n.markUnavailable(name)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.errs = append(n.errs, &MetricError{Metric: name, Err: err})
+}
+
+// failOptional records the error of an opt-in metric, if any. An opt-in metric that
+// fails is left out of the score with a warning, rather than failing the whole score.
+func (n *scoreNotes) failOptional(name string, err error) {
+	if err == nil {
+		return
+	}
+	n.markUnavailable(name)
+	if !errors.Is(err, ErrMetricUnavailable) {
+		n.warn("%s is unavailable : %s", name, err.Error())
+	}
+}
+
+// err returns the errors of the metrics that failed, sorted by metric, or nil if none did.
+func (n *scoreNotes) err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.errs) == 0 {
+		return nil
+	}
+	errs := append(MetricErrors(nil), n.errs...)
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Metric < errs[j].Metric })
+	return errs
+}
+
+// markUnavailable records a metric that couldn't be collected and is left out of the score.
+func (n *scoreNotes) markUnavailable(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !contains(n.unavailable, name) {
+		n.unavailable = append(n.unavailable, name)
+	}
+}
+
+// warn records a caveat about a metric.
+func (n *scoreNotes) warn(format string, a ...interface{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.warnings = append(n.warnings, fmt.Sprintf(format, a...))
+}
+
+// sortedUnavailable returns the unavailable metrics, sorted so the output doesn't depend
+// on the order the metrics finished in.
+func (n *scoreNotes) sortedUnavailable() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	unavailable := append([]string(nil), n.unavailable...)
+	sort.Strings(unavailable)
+	return unavailable
+}
+
+// sortedWarnings returns the metric warnings, sorted so the output doesn't depend on the
+// order the metrics finished in.
+func (n *scoreNotes) sortedWarnings() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	warnings := append([]string(nil), n.warnings...)
+	sort.Strings(warnings)
+	return warnings
+}
+
+// scoreWarnings returns the caveats of a score that don't come from a single metric,
+// such as the repository being archived, and the metrics left out of the score.
+func scoreWarnings(ghr GitHubRepository, config ScoreConfig, score Score) []string {
+
+	var warnings []string
+
+	if !ghr.authed {
+		host := "github"
+		if ghr.gitlab != nil {
+			host = "gitlab"
+		}
+		warnings = append(warnings, fmt.Sprintf("scored without a %s token, the score is not reliable", host))
+	}
+	if ghr.R.GetArchived() {
+		warnings = append(warnings, "repository is archived")
+	}
+	if ghr.R.GetFork() {
+		warnings = append(warnings, fmt.Sprintf("repository is a fork of %s, metrics are for the fork only", ghr.R.GetParent().GetFullName()))
+	}
+	if !score.IssuesEnabled {
+		warnings = append(warnings, "issues are disabled, issue metrics are left out of the score")
+	}
+	for _, name := range score.Unavailable {
+		if !score.IssuesEnabled && contains(issueMetrics, name) {
+			continue
+		}
+		if !config.metricEnabled(name) {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s is unavailable and left out of the score", name))
+	}
+
+	return warnings
+}
+
+// ContentHash returns a hex SHA-256 hash of the score's json encoding without the
+// ScoredOn timestamp, so scoring an unchanged repository again gives the same hash.
+// All fields are hashed, whatever the OutputFields.
+func (score Score) ContentHash() string {
+	score.ScoredOn = ""
+	score.Hash = ""
+	score.OutputFields = nil
+	b, err := json.Marshal(score)
+	if err != nil {
+		panic(err)
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
 }
 
 // PrintScore outputs all score values in the specified format (default, json or csv)
 func PrintScore(score Score, format string) {
+	PrintScoreTo(os.Stdout, score, format)
+}
+
+// PrintScoreTo outputs all score values to w in the specified format, so library users
+// can capture the output. It's the same as WriteScore.
+func PrintScoreTo(w io.Writer, score Score, format string) {
+	WriteScore(w, score, format)
+}
+
+// PrintScores outputs scores as a single document in the specified format: a json array
+// for json, a single table for csv-table, json lines for openssf, and otherwise the
+// scores one after another, separated by a blank line.
+func PrintScores(scores []Score, format string) {
+	WriteScores(os.Stdout, scores, format)
+}
+
+// WriteScores writes scores to w as a single document in one of the OutputFormats, like
+// PrintScores.
+func WriteScores(w io.Writer, scores []Score, format string) {
+
+	switch format {
+	case "json":
+		if scores == nil {
+			scores = []Score{}
+		}
+		writeJSON(w, scores)
+	case "csv-table":
+		t := NewCSVTableWriter(w)
+		for _, score := range scores {
+			if err := t.Write(score); err != nil {
+				log.Println(err.Error())
+				return
+			}
+		}
+	default:
+		for i, score := range scores {
+			if i > 0 && format != "openssf" {
+				fmt.Fprintln(w)
+			}
+			WriteScore(w, score, format)
+		}
+	}
+}
+
+// WriteScore writes a score to w in one of the OutputFormats.
+func WriteScore(w io.Writer, score Score, format string) {
+
+	if format == "github-summary" {
+		writeStepSummary(w, score)
+		return
+	}
+
+	if format == "openssf" {
+		writeOpenSSF(w, score)
+		return
+	}
+
+	if format == "csv-table" {
+		if err := NewCSVTableWriter(w).Write(score); err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+
+	writeRecord(w, score, format)
+}
+
+// writeRecord writes the fields of a struct, such as a Score, to w in the default, csv,
+// json or markdown format.
+func writeRecord(out io.Writer, record interface{}, format string) {
+
+	unavailable, fields := unavailableMetrics(record), outputFields(record)
 
 	if format == "default" {
-		v := reflect.ValueOf(score)
+		v := reflect.ValueOf(record)
 		typeOfScore := v.Type()
 		for i := 0; i < v.NumField(); i++ {
-			fmt.Printf("%s: %v\n", typeOfScore.Field(i).Tag.Get("json"), v.Field(i).Interface())
+			f, ok := fieldValue(typeOfScore.Field(i), v.Field(i), unavailable, fields)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(out, "%s: %v\n", jsonName(typeOfScore.Field(i)), f)
 		}
 		return
 	}
 
 	if format == "csv" {
-		w := csv.NewWriter(os.Stdout)
-		v := reflect.ValueOf(score)
+		w := csv.NewWriter(out)
+		v := reflect.ValueOf(record)
 		typeOfScore := v.Type()
 		for i := 0; i < v.NumField(); i++ {
-			c1 := typeOfScore.Field(i).Tag.Get("json")
-			var c2 string
-			switch vv := v.Field(i).Interface().(type) {
-			case string:
-				c2 = vv
-			case int:
-				c2 = strconv.Itoa(vv)
-			case float64:
-				c2 = fmt.Sprintf("%0.1f", vv)
-				if c1 == "CriticalityScore" {
-					c2 = fmt.Sprintf("%0.5f", vv)
-				}
+			f, ok := fieldValue(typeOfScore.Field(i), v.Field(i), unavailable, fields)
+			if !ok {
+				continue
 			}
+			c1 := jsonName(typeOfScore.Field(i))
+			c2 := csvValue(c1, f)
 			line := []string{c1, c2}
 			if err := w.Write(line); err != nil {
 				log.Println(err.Error())
@@ -213,13 +889,237 @@ func PrintScore(score Score, format string) {
 	}
 
 	if format == "json" {
-		b, err := json.MarshalIndent(score, "", "\t")
+		writeJSON(out, record)
+		return
+	}
+
+	if format == "markdown" {
+		writeMarkdownTable(out, record)
+		return
+	}
+
+	fmt.Fprintln(out, ErrUnknownOutputFormat.Error())
+}
+
+// csvValue formats the value of a field, as returned by fieldValue, for csv output.
+func csvValue(c1 string, f interface{}) string {
+	var c2 string
+	switch vv := f.(type) {
+	case string:
+		c2 = vv
+	case bool:
+		c2 = strconv.FormatBool(vv)
+	case int:
+		c2 = strconv.Itoa(vv)
+	case int64:
+		c2 = strconv.FormatInt(vv, 10)
+	case float64:
+		c2 = fmt.Sprintf("%0.1f", vv)
+		if c1 == "criticality_score" {
+			c2 = fmt.Sprintf("%0.5f", vv)
+		}
+	case map[string]float64:
+		names := make([]string, 0, len(vv))
+		for name := range vv {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for j, name := range names {
+			names[j] = fmt.Sprintf("%s=%0.5f", name, vv[name])
+		}
+		c2 = strings.Join(names, ";")
+	}
+	return c2
+}
+
+// PrintJSONArray outputs scores as a single json array, even if there's only one score.
+func PrintJSONArray(scores []Score) {
+	WriteScores(os.Stdout, scores, "json")
+}
+
+// WriteScoreJSONLine writes a score to w as a compact json object on a single line, so
+// that a stream of scores is valid JSON Lines, which LoadBaseline reads back.
+func WriteScoreJSONLine(w io.Writer, score Score) {
+	b, err := json.Marshal(score)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, string(b))
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, string(b))
+}
+
+// WriteStepSummary appends the github-summary output for a score to a GitHub Actions
+// job summary file, usually the path in the GITHUB_STEP_SUMMARY env variable.
+func WriteStepSummary(path string, score Score) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writeStepSummary(f, score)
+	return f.Close()
+}
+
+func writeStepSummary(w io.Writer, score Score) {
+	fmt.Fprintf(w, "### Criticality score for %s: %0.5f\n\n", score.Name, score.CriticalityScore)
+	writeMarkdownTable(w, score)
+	fmt.Fprintln(w)
+}
+
+// writeMarkdownTable writes a two-column markdown table of all values of a struct,
+// such as a Score.
+func writeMarkdownTable(w io.Writer, record interface{}) {
+	fmt.Fprintln(w, "| metric | value |")
+	fmt.Fprintln(w, "| --- | --- |")
+	unavailable, fields := unavailableMetrics(record), outputFields(record)
+	v := reflect.ValueOf(record)
+	typeOfScore := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f, ok := fieldValue(typeOfScore.Field(i), v.Field(i), unavailable, fields)
+		if !ok {
+			continue
+		}
+		value := strings.ReplaceAll(fmt.Sprintf("%v", f), "|", "\\|")
+		fmt.Fprintf(w, "| %s | %s |\n", jsonName(typeOfScore.Field(i)), value)
+	}
+}
+
+// fieldValue returns the value of a Score field, dereferencing optional fields,
+// formatting times as RFC 3339 and encoding nested objects as json. Unavailable metrics
+// are "null". It returns false for fields that aren't output and for omitempty fields
+// that weren't set, matching the json output.
+func fieldValue(f reflect.StructField, v reflect.Value, unavailable, fields []string) (interface{}, bool) {
+	if !outputField(f, fields) {
+		return nil, false
+	}
+	if contains(unavailable, jsonName(f)) {
+		return "null", true
+	}
+	if strings.Contains(f.Tag.Get("json"), ",omitempty") && v.IsZero() {
+		return nil, false
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339), true
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		return strings.Join(v.Interface().([]string), "; "), true
+	}
+	if v.Kind() == reflect.Struct || (v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.Struct) {
+		b, err := json.Marshal(v.Interface())
 		if err != nil {
-			panic(err)
+			return nil, false
 		}
-		fmt.Println(string(b))
-		return
+		return string(b), true
+	}
+	return v.Interface(), true
+}
+
+// unavailableMetrics returns the metrics of a Score that couldn't be collected, or nil
+// for other records.
+func unavailableMetrics(record interface{}) []string {
+	if score, ok := record.(Score); ok {
+		return score.Unavailable
+	}
+	return nil
+}
+
+// outputFields returns the OutputFields of a Score, or nil for other records.
+func outputFields(record interface{}) []string {
+	if score, ok := record.(Score); ok {
+		return score.OutputFields
+	}
+	return nil
+}
+
+// outputField reports whether a field is output: fields without a json name never are,
+// and otherwise all fields are unless limited to fields.
+func outputField(f reflect.StructField, fields []string) bool {
+	name := jsonName(f)
+	return name != "-" && (len(fields) == 0 || contains(fields, name))
+}
+
+// ScoreFields returns the json field names of Score, in output order.
+func ScoreFields() []string {
+	typeOfScore := reflect.TypeOf(Score{})
+	var names []string
+	for i := 0; i < typeOfScore.NumField(); i++ {
+		if name := jsonName(typeOfScore.Field(i)); name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ValidateFields returns ErrUnknownField if any of fields isn't a json field name of Score.
+func ValidateFields(fields []string) error {
+	known := ScoreFields()
+	for _, name := range fields {
+		if !contains(known, name) {
+			return fmt.Errorf("%w : %s, available fields are [%s]", ErrUnknownField, name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. Metrics listed as unavailable are encoded as
+// null, instead of a zero value that looks like data or being left out like metrics
+// that weren't enabled. Only the OutputFields are encoded, if there are any.
+func (score Score) MarshalJSON() ([]byte, error) {
+
+	// plain has the fields of Score without its methods, to encode the other fields.
+	type plain Score
+
+	if len(score.Unavailable) == 0 && len(score.OutputFields) == 0 {
+		return json.Marshal(plain(score))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	v := reflect.ValueOf(score)
+	typeOfScore := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := typeOfScore.Field(i)
+		name := jsonName(f)
+
+		var b []byte
+		switch {
+		case !outputField(f, score.OutputFields):
+			continue
+		case contains(score.Unavailable, name):
+			b = []byte("null")
+		case strings.Contains(f.Tag.Get("json"), ",omitempty") && v.Field(i).IsZero():
+			continue
+		default:
+			var err error
+			if b, err = json.Marshal(v.Field(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(b)
 	}
 
-	fmt.Println(ErrUnknownOutputFormat.Error())
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonName returns the json field name of a Score field, without tag options.
+func jsonName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("json"), ",")[0]
 }
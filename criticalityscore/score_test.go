@@ -0,0 +1,155 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteScoreJSONLine(t *testing.T) {
+
+	scores := []Score{
+		{Name: "a", URL: "https://github.com/o/a", CriticalityScore: 0.5},
+		{Name: "b", URL: "https://github.com/o/b", CriticalityScore: 0.25},
+	}
+
+	var b bytes.Buffer
+	for _, score := range scores {
+		WriteScoreJSONLine(&b, score)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != len(scores) {
+		t.Fatalf("wrote %d lines, want one per score:\n%s", len(lines), b.String())
+	}
+
+	dir, err := ioutil.TempDir("", "criticalityscore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scores.jsonl")
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatalf("LoadBaseline() error = %v", err)
+	}
+	if len(loaded) != len(scores) {
+		t.Fatalf("LoadBaseline() returned %d scores, want %d", len(loaded), len(scores))
+	}
+	for i := range scores {
+		if loaded[i].URL != scores[i].URL || loaded[i].CriticalityScore != scores[i].CriticalityScore {
+			t.Errorf("score %d = %s %v, want %s %v", i, loaded[i].URL, loaded[i].CriticalityScore, scores[i].URL, scores[i].CriticalityScore)
+		}
+	}
+}
+
+func TestClampScore(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		raw         float64
+		scale       string
+		min, max    float64
+		want        float64
+		wantClamped bool
+	}{
+		{name: "within range", raw: 0.5, min: 0, max: 1, want: 0.5},
+		{name: "above max", raw: 1.3, min: 0, max: 1, want: 1, wantClamped: true},
+		{name: "below min", raw: -0.2, min: 0, max: 1, want: 0, wantClamped: true},
+		{name: "raw scale", raw: 1.3, scale: ScaleRaw, min: 0, max: 1, want: 1, wantClamped: true},
+		{name: "percent scale not clamped", raw: 1.3, scale: ScalePercent, min: 0, max: 1, want: 1.3},
+		{name: "max not above min disables clamping", raw: 1.3, min: 1, max: 1, want: 1.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultScoreConfig()
+			config.Scale = tt.scale
+			config.ScoreMin, config.ScoreMax = tt.min, tt.max
+
+			got, clamped := clampScore(tt.raw, config)
+			if got != tt.want || clamped != tt.wantClamped {
+				t.Errorf("clampScore(%v) = %v, %v, want %v, %v", tt.raw, got, clamped, tt.want, tt.wantClamped)
+			}
+		})
+	}
+}
+
+func TestRepositoryStatsClamped(t *testing.T) {
+
+	config := fakeConfig()
+	config.ScoreMax = 0.1
+
+	score, err := RepositoryStats(loadFake(t, newFakeGitHub(), config), nil)
+	if err != nil {
+		t.Fatalf("RepositoryStats() error = %v", err)
+	}
+
+	if score.CriticalityScore != 0.1 {
+		t.Errorf("CriticalityScore = %v, want it clamped to 0.1", score.CriticalityScore)
+	}
+	clamped := false
+	for _, w := range score.Warnings {
+		if strings.HasPrefix(w, "criticality score ") && strings.HasSuffix(w, " was clamped to 0.1") {
+			clamped = true
+		}
+	}
+	if !clamped {
+		t.Errorf("Warnings = %q, want a clamped score warning", score.Warnings)
+	}
+}
+
+func TestParamScore(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		param    interface{}
+		maxValue float64
+		want     float64
+	}{
+		{name: "zero", param: 0, maxValue: 100, want: 0},
+		{name: "at max", param: 100, maxValue: 100, want: 1},
+		{name: "float at max", param: 100.0, maxValue: 100, want: 1},
+		{name: "above max", param: 1000, maxValue: 100, want: 1},
+		{name: "negative", param: -5, maxValue: 100, want: 0},
+		{name: "NaN", param: math.NaN(), maxValue: 100, want: 0},
+		{name: "infinite", param: math.Inf(1), maxValue: 100, want: 1},
+		{name: "negative infinite", param: math.Inf(-1), maxValue: 100, want: 0},
+		{name: "zero max", param: 10, maxValue: 0, want: 0},
+		{name: "negative max", param: 10, maxValue: -1, want: 0},
+		{name: "infinite max", param: 10, maxValue: math.Inf(1), want: 0},
+		{name: "NaN max", param: 10, maxValue: math.NaN(), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParamScore(tt.param, tt.maxValue, 1)
+			if math.IsNaN(got) || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("ParamScore(%v, %v, 1) = %v, want %v", tt.param, tt.maxValue, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// depsDevProjectURL is the deps.dev API endpoint for a project, given its escaped
+// project key (e.g. github.com/owner/repo).
+const depsDevProjectURL = "https://api.deps.dev/v3/projects/%s"
+
+// DefaultScorecardChecks are the OpenSSF Scorecard checks included in the output by
+// default.
+var DefaultScorecardChecks = []string{"Maintained", "Branch-Protection", "Code-Review", "Vulnerabilities"}
+
+// ScorecardResult is a subset of the OpenSSF Scorecard of a repository, as published by
+// deps.dev. It's reported alongside the criticality score and not part of it.
+type ScorecardResult struct {
+	Date         string         `json:"date"`
+	OverallScore float64        `json:"overall_score"`
+	Checks       map[string]int `json:"checks,omitempty"`
+}
+
+// Scorecard returns the OpenSSF Scorecard of the repository from deps.dev, with the
+// configured checks. A check's score is -1 if Scorecard couldn't evaluate it. It returns
+// nil without an error if deps.dev has no Scorecard for the repository, which is always
+// the case for repositories on a GitHub Enterprise Server and for GitLab projects.
+func (ghr GitHubRepository) Scorecard() (*ScorecardResult, error) {
+
+	if ghr.gitlab != nil || ghr.config.GitHubHost() != DefaultGitHubHost {
+		return nil, nil
+	}
+
+	projectKey := fmt.Sprintf("github.com/%s/%s", ghr.R.GetOwner().GetLogin(), ghr.R.GetName())
+
+	req, err := http.NewRequestWithContext(ghr.ctx, "GET", fmt.Sprintf(depsDevProjectURL, url.PathEscape(projectKey)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ghr.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("deps.dev returned status %d", resp.StatusCode)
+	}
+
+	var project struct {
+		Scorecard *struct {
+			Date         string  `json:"date"`
+			OverallScore float64 `json:"overallScore"`
+			Checks       []struct {
+				Name  string `json:"name"`
+				Score int    `json:"score"`
+			} `json:"checks"`
+		} `json:"scorecard"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&project); err != nil {
+		return nil, err
+	}
+	if project.Scorecard == nil {
+		return nil, nil
+	}
+
+	result := &ScorecardResult{
+		Date:         project.Scorecard.Date,
+		OverallScore: project.Scorecard.OverallScore,
+		Checks:       make(map[string]int),
+	}
+	for _, check := range project.Scorecard.Checks {
+		if contains(ghr.config.ScorecardChecks, check.Name) {
+			result.Checks[check.Name] = check.Score
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,61 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// securityPolicyDirs are the directories GitHub reads a repository's SECURITY.md from.
+var securityPolicyDirs = []string{"", ".github", "docs"}
+
+// HasSecurityPolicy returns whether the repository has a security policy: a SECURITY.md,
+// in any case, in its root, .github or docs directory, or in the default community
+// health files of its owner's .github repository. A repository without one has none.
+func (ghr GitHubRepository) HasSecurityPolicy() (bool, error) {
+
+	if ghr.gitlab != nil {
+		return false, ErrMetricUnavailable
+	}
+
+	owner := ghr.R.GetOwner().GetLogin()
+
+	sources := [][2]string{}
+	for _, dir := range securityPolicyDirs {
+		sources = append(sources, [2]string{ghr.R.GetName(), dir})
+	}
+	sources = append(sources, [2]string{".github", ""}, [2]string{".github", ".github"})
+
+	for _, source := range sources {
+		_, dir, _, err := ghr.source.GetContents(ghr.ctx, owner, source[0], source[1], nil)
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return false, classifyForbidden(err)
+		}
+		for _, file := range dir {
+			if file.GetType() == "file" && strings.EqualFold(file.GetName(), "SECURITY.md") {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,64 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidTierCutoffs error = fmt.Errorf("invalid tier cutoffs")
+)
+
+// TierNames are the tiers of a criticality score, from least to most critical.
+var TierNames = []string{"low", "medium", "high", "critical"}
+
+// DefaultTierCutoffs are the lowest scores of the medium, high and critical tiers.
+var DefaultTierCutoffs = []float64{0.25, 0.5, 0.75}
+
+// Tier returns the tier of a criticality score in the configured Scale: the last of
+// TierNames whose cutoff the score reaches. The cutoffs are on a 0-1 scale, and compared
+// to a percent score divided by 100.
+func (config ScoreConfig) Tier(criticalityScore float64) string {
+
+	cutoffs := config.TierCutoffs
+	if ValidateTierCutoffs(cutoffs) != nil {
+		cutoffs = DefaultTierCutoffs
+	}
+	if config.Scale == ScalePercent {
+		criticalityScore /= 100
+	}
+
+	tier := 0
+	for tier < len(cutoffs) && criticalityScore >= cutoffs[tier] {
+		tier++
+	}
+	return TierNames[tier]
+}
+
+// ValidateTierCutoffs returns ErrInvalidTierCutoffs unless cutoffs has a cutoff for each
+// tier but the lowest, in ascending order.
+func ValidateTierCutoffs(cutoffs []float64) error {
+	if len(cutoffs) != len(TierNames)-1 {
+		return fmt.Errorf("%w : %v, there must be %d cutoffs for the tiers [%s]", ErrInvalidTierCutoffs, cutoffs, len(TierNames)-1, strings.Join(TierNames, ", "))
+	}
+	if !sort.Float64sAreSorted(cutoffs) {
+		return fmt.Errorf("%w : %v, the cutoffs must be in ascending order", ErrInvalidTierCutoffs, cutoffs)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTier(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		score   float64
+		scale   string
+		cutoffs []float64
+		want    string
+	}{
+		{name: "lowest", score: 0, want: "low"},
+		{name: "below medium", score: 0.249, want: "low"},
+		{name: "at a cutoff", score: 0.25, want: "medium"},
+		{name: "high", score: 0.6, want: "high"},
+		{name: "critical", score: 0.75, want: "critical"},
+		{name: "above 1", score: 1.2, want: "critical"},
+		{name: "percent", score: 60, scale: ScalePercent, want: "high"},
+		{name: "percent below medium", score: 24.9, scale: ScalePercent, want: "low"},
+		{name: "custom cutoffs", score: 0.3, cutoffs: []float64{0.1, 0.2, 0.3}, want: "critical"},
+		{name: "invalid cutoffs fall back to the defaults", score: 0.3, cutoffs: []float64{0.3, 0.2, 0.1}, want: "medium"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultScoreConfig()
+			config.Scale = tt.scale
+			if tt.cutoffs != nil {
+				config.TierCutoffs = tt.cutoffs
+			}
+			if got := config.Tier(tt.score); got != tt.want {
+				t.Errorf("Tier(%v) = %q, want %q", tt.score, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTierCutoffs(t *testing.T) {
+
+	tests := []struct {
+		cutoffs []float64
+		wantErr bool
+	}{
+		{cutoffs: DefaultTierCutoffs},
+		{cutoffs: []float64{0.1, 0.1, 0.9}},
+		{cutoffs: []float64{0.5, 0.25, 0.75}, wantErr: true},
+		{cutoffs: []float64{0.25, 0.5}, wantErr: true},
+		{cutoffs: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateTierCutoffs(tt.cutoffs)
+		if tt.wantErr != errors.Is(err, ErrInvalidTierCutoffs) {
+			t.Errorf("ValidateTierCutoffs(%v) = %v, want an error %v", tt.cutoffs, err, tt.wantErr)
+		}
+	}
+}
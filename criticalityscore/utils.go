@@ -16,11 +16,13 @@
 package criticalityscore
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -28,51 +30,116 @@ import (
 	"github.com/google/go-github/github"
 )
 
-func totalCount(resp *github.Response) int {
+var (
+	ErrInvalidLookback error = fmt.Errorf("invalid lookback, use days (90d), weeks (26w) or a duration (2160h)")
+)
+
+// totalCount returns the number of items of a paginated list, given the response for its
+// first page and the number of items on it, from the page number of the "last" link. With
+// one item per page that's the item count; with more, the last page is fetched to count
+// its items, and if that fails the count is the minimum the page number allows. GitHub
+// leaves out the links when all items fit on the first page, so without a "last" link
+// the count is the items on the first page.
+func totalCount(ctx context.Context, source RepoDataSource, resp *github.Response, firstPageItems int) int {
+
+	if resp == nil {
+		return firstPageItems
+	}
 
 	links := parseLinkHeader(resp.Header)
 
 	lastURL, ok := links["last"]
 	if !ok {
-		return 0
+		return firstPageItems
 	}
 
 	u, err := url.Parse(lastURL)
 	if err != nil {
-		return 0
+		return firstPageItems
 	}
 
 	m, _ := url.ParseQuery(u.RawQuery)
 
 	pageCount, err := strconv.Atoi(m.Get("page"))
 	if err != nil {
-		return 0
+		return firstPageItems
+	}
+
+	perPage := DefaultPerPage
+	if s := m.Get("per_page"); s != "" {
+		perPage, err = strconv.Atoi(s)
+		if err != nil || perPage < 1 {
+			return firstPageItems
+		}
+	}
+
+	if perPage == 1 {
+		return pageCount
 	}
 
-	return pageCount
+	lastPageItems, _, err := source.CountPage(ctx, lastURL)
+	if err != nil {
+		return (pageCount-1)*perPage + 1
+	}
+
+	return (pageCount-1)*perPage + lastPageItems
 }
 
 func parseRepoURL(s string) (string, string) {
-	if !strings.Contains(s, "://") {
-		s = "https://" + s
-	}
+	return parseHostRepoURL(s, DefaultGitHubHost)
+}
 
-	u, err := url.Parse(s)
+// parseHostRepoURL returns the owner and name of a repository url on host, or empty
+// strings if the url isn't a repository url on that host. Besides web urls, the url can
+// be a git remote, e.g. git@github.com:owner/repo.git. A trailing ".git" is stripped
+// from the name, and the path of a page of the repository, such as /tree/main, is ignored.
+func parseHostRepoURL(s, host string) (string, string) {
+
+	u, err := url.Parse(normalizeRepoURL(s))
 	if err != nil {
 		return "", ""
 	}
 
-	if !(u.Host == "github.com") {
+	if !sameHost(u.Host, host) {
 		return "", ""
 	}
 
-	p := strings.Split(u.Path, "/")
+	p := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	if len(p) < 2 {
+		return "", ""
+	}
 
-	if len(p) < 3 {
+	owner, name := p[0], strings.TrimSuffix(p[1], ".git")
+	if owner == "" || name == "" {
 		return "", ""
 	}
 
-	return p[1], p[2]
+	return owner, name
+}
+
+// sameHost returns whether two hosts are the same, ignoring case and a leading "www.",
+// which browsers sometimes add to copied urls.
+func sameHost(a, b string) bool {
+	a = strings.TrimPrefix(strings.ToLower(a), "www.")
+	b = strings.TrimPrefix(strings.ToLower(b), "www.")
+	return a == b
+}
+
+// scpLikeURL matches the scp-like syntax of ssh git remotes, e.g. git@github.com:owner/repo.git.
+var scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@([^:/]+):(.*)$`)
+
+// normalizeRepoURL returns a repository url with a scheme, given as a web url with or
+// without a scheme or as a git remote, so that it can be parsed with url.Parse.
+func normalizeRepoURL(s string) string {
+	s = strings.TrimSpace(s)
+	if m := scpLikeURL.FindStringSubmatch(s); m != nil {
+		return "ssh://" + m[1] + "/" + strings.TrimPrefix(m[2], "/")
+	}
+	if !strings.Contains(s, "://") {
+		return "https://" + s
+	}
+	return s
 }
 
 func parseAdditionalParams(params []string) ([]AdditionalParam, error) {
@@ -115,33 +182,285 @@ func parseAdditionalParams(params []string) ([]AdditionalParam, error) {
 	return additionalParams, nil
 }
 
+// parseLinkHeader returns the url of each relation in a Link header (RFC 8288), e.g.
+// "next" and "last" in GitHub's pagination links. Urls may contain commas and
+// semicolons, parameter values may be quoted and a rel may list several relations.
+// Malformed links are skipped.
 func parseLinkHeader(header http.Header) map[string]string {
 	links := make(map[string]string)
-	linkHeaders := strings.Split(header.Get("link"), ", ")
-	for _, linkHeader := range linkHeaders {
-		lh := strings.Split(linkHeader, "; ")
-		if len(lh) < 2 {
-			continue
+
+	for _, value := range header.Values("Link") {
+		for {
+			start := strings.IndexByte(value, '<')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(value[start:], '>')
+			if end < 0 {
+				break
+			}
+			u := value[start+1 : start+end]
+			value = value[start+end+1:]
+
+			// A '<' without a closing '>' starts a malformed link, which is skipped.
+			if i := strings.LastIndexByte(u, '<'); i >= 0 {
+				u = u[i+1:]
+			}
+
+			// The parameters run up to the next link, i.e. the first comma outside quotes.
+			params, rest := value, ""
+			inQuotes := false
+			for i, c := range value {
+				if c == '"' {
+					inQuotes = !inQuotes
+				}
+				if c == ',' && !inQuotes {
+					params, rest = value[:i], value[i+1:]
+					break
+				}
+			}
+			value = rest
+
+			for _, rel := range strings.Fields(linkParam(params, "rel")) {
+				links[strings.ToLower(rel)] = u
+			}
 		}
-		u := substr(lh[0], 1, -1)
-		r := substr(lh[1], 5, -1)
-		links[r] = u
 	}
+
 	return links
 }
 
-func pauseIfGitHubRateLimitExceeded(client *github.Client, ctx context.Context) {
-	rateLimits, resp, err := client.RateLimits(ctx)
+// linkParam returns the value of a parameter of a link, unquoted, or an empty string
+// if the link doesn't have it.
+func linkParam(params, name string) string {
+	for _, param := range strings.Split(params, ";") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), name) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return ""
+}
+
+// pauseIfGitHubRateLimitExceeded waits for the core rate limit to reset if fewer than
+// RateLimitReserve requests remain. It returns ErrRateLimitCheck if the rate limit
+// can't be read, or the context error if ctx is done while waiting.
+func pauseIfGitHubRateLimitExceeded(source RepoDataSource, ctx context.Context) error {
+	rateLimits, _, err := source.RateLimits(ctx)
 	if err != nil {
-		panic(err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w : %s", ErrRateLimitCheck, err.Error())
 	}
-	defer resp.Body.Close()
 
-	if rateLimits.Core.Remaining < 50 {
-		waitTime := rateLimits.Core.Reset.Sub(time.Now())
+	if rateLimits.GetCore() != nil && rateLimits.Core.Remaining < RateLimitReserve {
+		waitTime := rateLimitWait(time.Until(rateLimits.Core.Reset.Time))
 		log.Printf("rate limit exceeded, sleeping for %0.0f seconds before retry.\n", waitTime.Seconds())
-		time.Sleep(waitTime * time.Second)
+		return sleepContext(ctx, waitTime)
+	}
+	return nil
+}
+
+// rateLimitWait clamps a wait for a rate limit to between zero and MaxRateLimitWait,
+// so a skewed clock or a bogus header can't stall a run indefinitely.
+func rateLimitWait(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d > MaxRateLimitWait {
+		return MaxRateLimitWait
 	}
+	return d
+}
+
+// rateLimitRetryDelay returns how long to wait before retrying a rate-limited response:
+// the delay of its Retry-After header for secondary rate limits, or else the time until
+// its X-RateLimit-Reset once no requests remain.
+func rateLimitRetryDelay(resp *http.Response) (time.Duration, bool) {
+
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if d, ok := retryAfter(resp.Header); ok {
+		return rateLimitWait(d), true
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return rateLimitWait(time.Until(time.Unix(reset, 0))), true
+}
+
+// parseDependentsCount returns the commit results count from a search results page.
+// A page saying no commits were found returns 0, while a page without either the
+// results count or that message returns ErrDependentsNoMatch.
+func parseDependentsCount(content []byte) (int, error) {
+
+	match := DependentsRegex.FindSubmatch(content)
+
+	if len(match) == 0 {
+		if DependentsNoResultsRegex.Match(content) {
+			return 0, nil
+		}
+		return 0, ErrDependentsNoMatch
+	}
+
+	b := bytes.ReplaceAll(match[1], []byte(","), []byte(""))
+	b = bytes.TrimSpace(b)
+	dependentsCount, _ := strconv.Atoi(string(b))
+	return dependentsCount, nil
+}
+
+// parseDependencyGraphCount returns the number of dependent repositories from the
+// dependents page of a repository. A page saying no dependents were found returns 0,
+// while a page without either the repositories count or that message returns
+// ErrDependentsNoMatch.
+func parseDependencyGraphCount(content []byte) (int, error) {
+
+	match := DependencyGraphRegex.FindSubmatch(content)
+
+	if len(match) == 0 {
+		if DependencyGraphNoResultsRegex.Match(content) {
+			return 0, nil
+		}
+		return 0, ErrDependentsNoMatch
+	}
+
+	b := bytes.ReplaceAll(match[1], []byte(","), []byte(""))
+	dependentsCount, _ := strconv.Atoi(string(b))
+	return dependentsCount, nil
+}
+
+// retryAfter returns the delay of a Retry-After header, given in seconds or as a date,
+// and whether the header has one.
+func retryAfter(header http.Header) (time.Duration, bool) {
+
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+// classifyForbidden returns ErrScopeMissing or ErrForbidden, wrapped with the API
+// message, if err is a 403 that isn't caused by a rate limit. Other errors are
+// returned unchanged.
+func classifyForbidden(err error) error {
+
+	errResp, ok := err.(*github.ErrorResponse)
+	if !ok || errResp.Response == nil || errResp.Response.StatusCode != http.StatusForbidden {
+		return err
+	}
+
+	if isRateLimitedResponse(errResp.Response) {
+		return err
+	}
+
+	if strings.Contains(errResp.Message, "not accessible by") || scopeMissing(errResp.Response.Header) {
+		return fmt.Errorf("%w : %s", ErrScopeMissing, errResp.Message)
+	}
+
+	return fmt.Errorf("%w : %s", ErrForbidden, errResp.Message)
+}
+
+// isRateLimitedResponse returns whether a response carries GitHub's rate limit signals,
+// i.e. an exhausted X-RateLimit-Remaining or a Retry-After header.
+func isRateLimitedResponse(resp *http.Response) bool {
+	return resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != ""
+}
+
+// scopeMissing returns whether none of the OAuth scopes accepted by an endpoint were
+// granted to the token.
+func scopeMissing(header http.Header) bool {
+
+	accepted := header.Get("X-Accepted-OAuth-Scopes")
+	if accepted == "" {
+		return false
+	}
+
+	granted := make(map[string]bool)
+	for _, scope := range strings.Split(header.Get("X-OAuth-Scopes"), ",") {
+		granted[strings.TrimSpace(scope)] = true
+	}
+
+	for _, scope := range strings.Split(accepted, ",") {
+		if granted[strings.TrimSpace(scope)] {
+			return false
+		}
+	}
+	return true
+}
+
+// lookbackTime returns the time a number of days ago, from the start of the current hour,
+// so the API requests for a lookback window have the same url within the hour and are
+// served from the disk cache.
+func lookbackTime(days float64) time.Time {
+	return time.Now().Truncate(time.Hour).Add(-time.Duration(days * 24.0 * float64(time.Hour)))
+}
+
+// ParseLookback returns the number of days in a lookback window given either as a
+// number of days or weeks (e.g. "90d", "26w") or as a Go duration (e.g. "2160h").
+func ParseLookback(s string) (float64, error) {
+
+	units := map[string]float64{"d": 1, "w": 7}
+	for suffix, days := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("%w : %s", ErrInvalidLookback, s)
+			}
+			return n * days, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%w : %s", ErrInvalidLookback, s)
+	}
+	return d.Hours() / 24.0, nil
+}
+
+// sleepContext pauses for d, returning early with the context error if ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// contains reports whether s is in list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
 func filterOrgName(orgName string) string {
@@ -151,17 +470,3 @@ func filterOrgName(orgName string) string {
 	name = strings.TrimRight(name, ",")
 	return name
 }
-
-func substr(input string, start int, end int) string {
-	asRunes := []rune(input)
-	if start >= len(asRunes) {
-		return ""
-	}
-	if end > len(asRunes) {
-		end = len(asRunes) - 1
-	}
-	if end <= 0 {
-		end = len(asRunes) + end
-	}
-	return string(asRunes[start:end])
-}
@@ -0,0 +1,332 @@
+// # Copyright 2020 Jon Engelsman
+// # Copyright 2020 Google LLC
+// #
+// # Licensed under the Apache License, Version 2.0 (the "License");
+// # you may not use this file except in compliance with the License.
+// # You may obtain a copy of the License at
+// #
+// #      http://www.apache.org/licenses/LICENSE-2.0
+// #
+// # Unless required by applicable law or agreed to in writing, software
+// # distributed under the License is distributed on an "AS IS" BASIS,
+// # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// # See the License for the specific language governing permissions and
+// # limitations under the License.
+
+package criticalityscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func errorResponse(status int, message string, header http.Header) *github.ErrorResponse {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &github.ErrorResponse{
+		Response: &http.Response{StatusCode: status, Header: header},
+		Message:  message,
+	}
+}
+
+func TestClassifyForbidden(t *testing.T) {
+
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		err       error
+		want      error
+		unchanged bool
+	}{
+		{name: "not accessible", err: errorResponse(403, "Resource not accessible by integration", nil), want: ErrScopeMissing},
+		{name: "scope missing", err: errorResponse(403, "Forbidden", http.Header{"X-Accepted-Oauth-Scopes": {"repo"}, "X-Oauth-Scopes": {"read:org"}}), want: ErrScopeMissing},
+		{name: "scope granted", err: errorResponse(403, "Forbidden", http.Header{"X-Accepted-Oauth-Scopes": {"repo"}, "X-Oauth-Scopes": {"read:org, repo"}}), want: ErrForbidden},
+		{name: "forbidden", err: errorResponse(403, "Bad credentials", nil), want: ErrForbidden},
+		{name: "rate limited", err: errorResponse(403, "API rate limit exceeded", http.Header{"X-Ratelimit-Remaining": {"0"}}), unchanged: true},
+		{name: "secondary rate limit", err: errorResponse(403, "secondary rate limit", http.Header{"Retry-After": {"60"}}), unchanged: true},
+		{name: "not found", err: errorResponse(404, "Not Found", nil), unchanged: true},
+		{name: "other error", err: other, unchanged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyForbidden(tt.err)
+			if tt.unchanged {
+				if got != tt.err {
+					t.Errorf("classifyForbidden() = %v, want the error unchanged", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("classifyForbidden() = %v, want %v", got, tt.want)
+			}
+			if Retryable(got) {
+				t.Errorf("Retryable(%v) = true, want false for a permission error", got)
+			}
+		})
+	}
+}
+
+func TestForbiddenMetric(t *testing.T) {
+
+	ghr := newTestRepository(t, DefaultScoreConfig(), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message":"Resource not accessible by integration"}`)
+	})
+
+	if _, err := ghr.Contributors(); !errors.Is(err, ErrScopeMissing) {
+		t.Errorf("Contributors() error = %v, want ErrScopeMissing", err)
+	}
+}
+
+func TestParseLinkHeader(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		values []string
+		want   map[string]string
+	}{
+		{
+			name:   "github pagination",
+			values: []string{`<https://api.github.com/repos/o/r/contributors?per_page=1&page=2>; rel="next", <https://api.github.com/repos/o/r/contributors?per_page=1&page=42>; rel="last"`},
+			want: map[string]string{
+				"next": "https://api.github.com/repos/o/r/contributors?per_page=1&page=2",
+				"last": "https://api.github.com/repos/o/r/contributors?per_page=1&page=42",
+			},
+		},
+		{
+			name:   "commas and semicolons in urls",
+			values: []string{`<https://x.test/a?q=a,b;c&page=3>; rel="last", <https://x.test/a?q=a,b;c&page=1>; rel="first"`},
+			want:   map[string]string{"last": "https://x.test/a?q=a,b;c&page=3", "first": "https://x.test/a?q=a,b;c&page=1"},
+		},
+		{
+			name:   "unquoted, cased and several relations",
+			values: []string{`<https://x.test/2>; REL=next, <https://x.test/9>; title="a, b"; rel="last end"`},
+			want:   map[string]string{"next": "https://x.test/2", "last": "https://x.test/9", "end": "https://x.test/9"},
+		},
+		{
+			name:   "several header values",
+			values: []string{`<https://x.test/2>; rel="next"`, `<https://x.test/9>; rel="last"`},
+			want:   map[string]string{"next": "https://x.test/2", "last": "https://x.test/9"},
+		},
+		{
+			name:   "missing header",
+			values: nil,
+			want:   map[string]string{},
+		},
+		{
+			name:   "empty and garbage values",
+			values: []string{"", "garbage", `rel="next"`},
+			want:   map[string]string{},
+		},
+		{
+			name:   "link without a rel",
+			values: []string{`<https://x.test/2>, <https://x.test/9>; rel="last"`},
+			want:   map[string]string{"last": "https://x.test/9"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, v := range tt.values {
+				header.Add("Link", v)
+			}
+			got := parseLinkHeader(header)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLinkHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLinkHeaderMalformed(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+	}{
+		{name: "unclosed url", value: `<https://x.test/2; rel="next"`, want: map[string]string{}},
+		{name: "unclosed url before a link", value: `<https://x.test/2; rel="next", <https://x.test/9>; rel="last"`, want: map[string]string{"last": "https://x.test/9"}},
+		{name: "unterminated quote", value: `<https://x.test/9>; rel="last`, want: map[string]string{"last": "https://x.test/9"}},
+		{name: "stray closing bracket", value: `>; rel="next", <https://x.test/9>; rel="last"`, want: map[string]string{"last": "https://x.test/9"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLinkHeader(http.Header{"Link": {tt.value}})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLinkHeader(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTotalCount(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		link           string
+		firstPageItems int
+		lastPage       string
+		lastStatus     int
+		want           int
+		wantFetch      bool
+	}{
+		{name: "no last link", firstPageItems: 7, want: 7},
+		{name: "one item per page", link: "per_page=1&page=42", firstPageItems: 1, want: 42},
+		{name: "last page fetched", link: "per_page=30&page=3", firstPageItems: 30, lastPage: `[1,2,3,4,5]`, lastStatus: http.StatusOK, want: 65, wantFetch: true},
+		{name: "default page size", link: "page=2", firstPageItems: DefaultPerPage, lastPage: `[1]`, lastStatus: http.StatusOK, want: DefaultPerPage + 1, wantFetch: true},
+		{name: "last page fetch fails", link: "per_page=30&page=3", firstPageItems: 30, lastStatus: http.StatusInternalServerError, want: 61, wantFetch: true},
+		{name: "invalid page size", link: "per_page=0&page=3", firstPageItems: 30, want: 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			fetched := false
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fetched = true
+				w.WriteHeader(tt.lastStatus)
+				fmt.Fprint(w, tt.lastPage)
+			}))
+			defer srv.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+			header := http.Header{}
+			if tt.link != "" {
+				header.Set("Link", fmt.Sprintf(`<%s/items?%s>; rel="last"`, srv.URL, tt.link))
+			}
+			resp := &github.Response{Response: &http.Response{Header: header}}
+
+			if got := totalCount(context.Background(), githubDataSource{client: client}, resp, tt.firstPageItems); got != tt.want {
+				t.Errorf("totalCount() = %d, want %d", got, tt.want)
+			}
+			if fetched != tt.wantFetch {
+				t.Errorf("fetched the last page = %v, want %v", fetched, tt.wantFetch)
+			}
+		})
+	}
+}
+
+func TestPauseIfGitHubRateLimitExceeded(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr error
+	}{
+		{
+			name: "requests remaining",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":4000}}}`)
+			},
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantErr: ErrRateLimitCheck,
+		},
+		{
+			name: "no response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.Close()
+			},
+			wantErr: ErrRateLimitCheck,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+			err := pauseIfGitHubRateLimitExceeded(githubDataSource{client: client}, context.Background())
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("pauseIfGitHubRateLimitExceeded() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("pauseIfGitHubRateLimitExceeded() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseRepoURL(t *testing.T) {
+
+	tests := []struct {
+		url       string
+		wantOwner string
+		wantName  string
+	}{
+		{url: "https://github.com/owner/repo", wantOwner: "owner", wantName: "repo"},
+		{url: "github.com/owner/repo", wantOwner: "owner", wantName: "repo"},
+		{url: "https://github.com/owner/repo/", wantOwner: "owner", wantName: "repo"},
+		{url: "https://github.com/owner/repo.git", wantOwner: "owner", wantName: "repo"},
+		{url: "https://github.com/owner/repo/tree/main", wantOwner: "owner", wantName: "repo"},
+		{url: "git@github.com:owner/repo.git", wantOwner: "owner", wantName: "repo"},
+		{url: "git@github.com:owner/repo", wantOwner: "owner", wantName: "repo"},
+		{url: "ssh://git@github.com/owner/repo.git", wantOwner: "owner", wantName: "repo"},
+		{url: "https://github.com/owner"},
+		{url: "https://github.com/owner/.git"},
+		{url: "git@gitlab.com:owner/repo.git"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			owner, name := parseRepoURL(tt.url)
+			if owner != tt.wantOwner || name != tt.wantName {
+				t.Errorf("parseRepoURL(%q) = %q, %q, want %q, %q", tt.url, owner, name, tt.wantOwner, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestParseHostRepoURLHosts(t *testing.T) {
+
+	tests := []struct {
+		url       string
+		host      string
+		wantOwner string
+	}{
+		{url: "https://www.github.com/owner/repo", host: DefaultGitHubHost, wantOwner: "owner"},
+		{url: "https://GitHub.com/owner/repo", host: DefaultGitHubHost, wantOwner: "owner"},
+		{url: "HTTPS://WWW.GITHUB.COM/owner/repo", host: DefaultGitHubHost, wantOwner: "owner"},
+		{url: "https://github.example.com/owner/repo", host: "GitHub.Example.com", wantOwner: "owner"},
+		{url: "https://github.example.com/owner/repo", host: DefaultGitHubHost},
+		{url: "https://notgithub.com/owner/repo", host: DefaultGitHubHost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			owner, _ := parseHostRepoURL(tt.url, tt.host)
+			if owner != tt.wantOwner {
+				t.Errorf("parseHostRepoURL(%q, %q) owner = %q, want %q", tt.url, tt.host, owner, tt.wantOwner)
+			}
+		})
+	}
+}
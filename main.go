@@ -1,23 +1,94 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"strings"
 
 	"github.com/engelsjk/criticalityscore/criticalityscore"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	app     = kingpin.New("criticalityscore", "gives criticality score for an open source project")
-	repoURL = app.Flag("repo", "repository url").Required().String()
-	format  = app.Flag("format", "output format. allowed values are [default, csv, json]").Default("default").String()
-	params  = app.Flag("param", "additional parameter in form <value>:<weight>:<max_threshold>").Strings()
+	app         = kingpin.New("criticalityscore", "gives criticality score for an open source project")
+	repoURL     = app.Flag("repo", "repository url").String()
+	baseURL     = app.Flag("github-base-url", "api url of a github enterprise server, e.g. https://github.mycorp.com/api/v3/").String()
+	uploadURL   = app.Flag("github-upload-url", "upload api url of a github enterprise server, defaults to /api/uploads/ on the host of --github-base-url").String()
+	reposFile   = app.Flag("repos-file", "score each repository url in a file, one per line, skipping blank lines and # comments").String()
+	repoID      = app.Flag("repo-id", "numeric github repository id, used instead of --repo").Int64()
+	format      = app.Flag("format", fmt.Sprintf("output format. allowed values are [%s]", strings.Join(criticalityscore.OutputFormats, ", "))).Default("default").Enum(criticalityscore.OutputFormats...)
+	params      = app.Flag("param", "additional parameter in form <value>:<weight>:<max_threshold>").Strings()
+	rate        = app.Flag("rate", "target github api request rate per hour, shared by all metric calls (0 disables)").Default("5000").Float64()
+	community   = app.Flag("community", "detect wiki and discussions as community-health signals").Bool()
+	baseline    = app.Flag("compare-to-baseline", "previously saved json, jsonl or csv scores to report changes against").String()
+	threshold   = app.Flag("regression-threshold", "criticality score drop flagged as a regression when comparing to a baseline").Default("0.05").Float64()
+	path        = app.Flag("path", "subdirectory to scope commit and contributor metrics to, e.g. a package in a monorepo").String()
+	attempts    = app.Flag("dependents-attempts", "maximum number of dependents search attempts when rate limited or failing").Default("3").Int()
+	statsTries  = app.Flag("stats-attempts", "maximum number of commit statistics requests while github is still calculating them").Default("4").Int()
+	statsDelay  = app.Flag("stats-retry-delay", "delay between commit statistics requests while github is still calculating them").Default("3s").Duration()
+	model       = app.Flag("model", "scoring model. allowed values are [openssf, linear, geomean]").Default("openssf").Enum("openssf", "linear", "geomean")
+	paramScores = app.Flag("param-scores", "output the ParamScore of each metric").Bool()
+	explain     = app.Flag("explain", "output the value, weight, threshold and contribution of each metric").Bool()
+	depsMethod  = app.Flag("dependents-method", "how dependents are counted. allowed values are [commit_search, dependency_graph]").Default("commit_search").Enum("dependency_graph", "commit_search")
+	commitSrc   = app.Flag("commit-frequency-source", "statistics endpoint tried first for commit frequency. allowed values are [commit_activity, participation]").Default("commit_activity").Enum("commit_activity", "participation")
+	cacheDir    = app.Flag("cache-dir", "directory to cache github responses in, so later runs can score from the cache").String()
+	warm        = app.Flag("warm", "fill the cache with all repos of an org or user, or of a repo list file like --repos-file, without outputting scores").String()
+	array       = app.Flag("array", "with json format, output a json array even for a single repo").Bool()
+	churn       = app.Flag("churn", "score the number of distinct files changed by recent commits").Bool()
+	lockfile    = app.Flag("lockfile", "score the dependencies listed in a lockfile instead of a single repo. supported files are [go.mod, go.sum]").String()
+	concurrency = app.Flag("concurrency", "with --lockfile or --repos-file, number of repos scored at a time").Default("4").Int()
+	inputOrder  = app.Flag("input-order", "with --lockfile, stream scores in lockfile order as they're done instead of sorted by criticality (ignores --top)").Bool()
+	minScore    = app.Flag("min-score", "with --lockfile or --repos-file, leave out scores below this criticality score").Float64()
+	sortBy      = app.Flag("sort", "with --lockfile or --repos-file, sort the scores by a field, e.g. score, name or created_since, with an optional :asc or :desc suffix").String()
+	top         = app.Flag("top", "with --lockfile, number of dependencies to output, the most critical or the first in --sort order (0 outputs all)").Default("10").Int()
+	metaOnly    = app.Flag("include-metadata-only", "output only the repository metadata, without scoring").Bool()
+	scorecard   = app.Flag("scorecard", "add the openssf scorecard of the repo from deps.dev to the output").Bool()
+	scale       = app.Flag("scale", "scale of the criticality score. allowed values are [raw, unit, percent]").Default("raw").Enum("raw", "unit", "percent")
+	scoreMin    = app.Flag("score-min", "lowest raw criticality score, lower scores are clamped to it with a warning").Default("0").Float64()
+	scoreMax    = app.Flag("score-max", "highest raw criticality score, higher scores are clamped to it with a warning (not above --score-min disables clamping)").Default("1").Float64()
+	timeFormat  = app.Flag("time-format", "format of the scored_on timestamp. allowed values are [rfc3339, unixdate]").Default("rfc3339").Enum("rfc3339", "unixdate")
+	localTime   = app.Flag("local-time", "give the scored_on timestamp in local time instead of utc").Bool()
+	tiers       = app.Flag("tiers", "add the tier of the criticality score, one of [low, medium, high, critical], to the output").Bool()
+	listMetrics = app.Flag("list-metrics", "output the metrics with their weights, thresholds and descriptions, without scoring").Bool()
+	userDelay   = app.Flag("user-lookup-delay", "delay before each contributor user lookup for the org count").Default("250ms").Duration()
+	output      = app.Flag("output", "write the output to this file instead of stdout").Short('o').String()
+	outputDir   = app.Flag("output-dir", "also write each score to its own owner__repo file in this directory").String()
+	failUnder   = app.Flag("fail-under", "exit with status 1 if the criticality score is below this value").Float64()
+	timeout     = app.Flag("timeout", "with --lockfile, --repos-file or --warm, stop after this duration and output the results so far (0 disables)").Duration()
+	shortCirc   = app.Flag("short-circuit", "with --fail-under, skip the expensive metrics if the score can't reach it").Bool()
+	noSearch    = app.Flag("no-search", "skip metrics that use the github search quota, such as the dependents count").Bool()
+	noDeps      = app.Flag("no-dependents", "skip the dependents count, the slowest metric").Bool()
+	graphQL     = app.Flag("graphql", "collect several metrics with a single github graphql query instead of separate rest calls").Bool()
+	starGrowth  = app.Flag("star-growth", "score the number of new stars per 30 days").Bool()
+	relContrib  = app.Flag("release-contributors", "score the number of distinct commit authors between the two most recent releases").Bool()
+	authors     = app.Flag("commit-authors", "score the number of distinct recent commit authors and report the bus factor").Bool()
+	pulls       = app.Flag("pull-requests", "report the open and merged pull request counts and the pull request merge rate").Bool()
+	license     = app.Flag("license", "score whether github recognized the license of the repository").Bool()
+	secPolicy   = app.Flag("security-policy", "score whether the repository has a SECURITY.md security policy").Bool()
+	ci          = app.Flag("ci", "score whether the repository has ci configured").Bool()
+	funding     = app.Flag("funding", "detect a FUNDING.yml and report its funding platforms, such as github sponsors").Bool()
+	signed      = app.Flag("signed-commits", "score the fraction of recent commits with a verified signature").Bool()
+	rawRepo     = app.Flag("include-repository", "add metadata of the github repository to the output").Bool()
+	metrics     = app.Flag("metrics", "comma-separated metrics to collect and score, e.g. contributor_count,commit_frequency (all metrics if empty)").String()
+	fields      = app.Flag("fields", "comma-separated json field names to output, e.g. name,url,criticality_score (all fields if empty)").String()
+	since       = app.Flag("since", "lookback window for issue and comment metrics, in days (90d), weeks (26w) or a duration (2160h)").String()
+	sinceRel    = app.Flag("since-releases", "also apply --since to the recent releases count").Bool()
+	issueDays   = app.Flag("issue-lookback-days", "lookback window in days for issue and comment metrics, overrides --since").Float64()
+	releaseDays = app.Flag("release-lookback-days", "lookback window in days for the recent releases count, overrides --since").Float64()
+	doctor      = app.Flag("doctor", "check the token, rate limit and github reachability without scoring").Bool()
+	configFile  = app.Flag("config", "json config file with settings, weights and thresholds").String()
+	profile     = app.Flag("profile", "config profile, built in [default, library, application] or from the config file").String()
+	allowUnauth = app.Flag("allow-unauthenticated", "score without GITHUB_AUTH_TOKEN, marking the result as not reliable").Bool()
+	anonymous   = app.Flag("include-anonymous", "count anonymous contributors in the contributor and org counts (--no-include-anonymous to exclude)").Default("true").Bool()
 )
 
 func main() {
 
-	app.Version("0.0.1")
+	app.Version(criticalityscore.Version)
 	_, err := app.Parse(os.Args[1:])
 	if err != nil {
 		fmt.Println(err.Error())
@@ -26,20 +97,494 @@ func main() {
 
 	token := os.Getenv("GITHUB_AUTH_TOKEN")
 	if token == "" {
-		fmt.Println("warning: env variable GITHUB_AUTH_TOKEN not provided")
+		fmt.Fprintln(os.Stderr, "warning: env variable GITHUB_AUTH_TOKEN not provided")
 	}
 
-	repo, err := criticalityscore.LoadRepository(*repoURL, token)
+	config, err := criticalityscore.LoadScoreConfig(*configFile, *profile)
 	if err != nil {
-		fmt.Println(err.Error())
+		criticalityscore.PrintError(err, *format)
+		return
+	}
+	config.GitLabToken = os.Getenv("GITLAB_TOKEN")
+
+	// Flags given on the command line override the config file.
+	set := flagsSet(os.Args[1:])
+	if set["rate"] {
+		config.RequestsPerHour = *rate
+	}
+	if set["community"] {
+		config.CommunitySignals = *community
+	}
+	if set["path"] {
+		config.Path = *path
+	}
+	if set["dependents-attempts"] {
+		config.DependentsAttempts = *attempts
+	}
+	if set["stats-attempts"] {
+		config.StatsAttempts = *statsTries
+	}
+	if set["stats-retry-delay"] {
+		config.StatsRetryDelay = *statsDelay
+	}
+	if set["include-anonymous"] {
+		config.IncludeAnonymous = *anonymous
+	}
+	if set["model"] {
+		config.Model = criticalityscore.ScoringModels[*model]
+	}
+	if set["param-scores"] {
+		config.ParamScores = *paramScores
+	}
+	if set["explain"] {
+		config.Explain = *explain
+	}
+	if set["cache-dir"] {
+		config.CacheDir = *cacheDir
+	}
+	if set["dependents-method"] {
+		config.DependentsMethod = *depsMethod
+	}
+	if set["commit-frequency-source"] {
+		config.CommitFrequencySource = *commitSrc
+	}
+	if set["allow-unauthenticated"] {
+		config.AllowUnauthenticated = *allowUnauth
+	}
+	if set["churn"] {
+		config.Churn = *churn
+	}
+	if set["scorecard"] {
+		config.Scorecard = *scorecard
+	}
+	if set["scale"] {
+		config.Scale = *scale
+	}
+	if set["time-format"] {
+		config.TimeFormat = *timeFormat
+	}
+	if set["local-time"] {
+		config.LocalTime = *localTime
+	}
+	if set["tiers"] {
+		config.Tiers = *tiers
+	}
+	if set["user-lookup-delay"] {
+		config.UserLookupDelay = *userDelay
+	}
+	if set["fail-under"] {
+		config.FailUnder = *failUnder
+	}
+	if set["short-circuit"] {
+		config.ShortCircuit = *shortCirc
+	}
+	if set["no-search"] {
+		config.NoSearch = *noSearch
+	}
+	if set["no-dependents"] {
+		config.NoDependents = *noDeps
+	}
+	if set["graphql"] {
+		config.GraphQL = *graphQL
+	}
+	if set["metrics"] {
+		config.Metrics = splitList(*metrics)
+	}
+	if set["fields"] {
+		config.Fields = splitList(*fields)
+	}
+	if set["star-growth"] {
+		config.StarGrowth = *starGrowth
+	}
+	if set["score-min"] {
+		config.ScoreMin = *scoreMin
+	}
+	if set["score-max"] {
+		config.ScoreMax = *scoreMax
+	}
+	if set["github-base-url"] {
+		config.GitHubBaseURL = *baseURL
+	}
+	if set["github-upload-url"] {
+		config.GitHubUploadURL = *uploadURL
+	}
+	if set["concurrency"] {
+		config.Concurrency = *concurrency
+	}
+	if set["release-contributors"] {
+		config.ReleaseContributors = *relContrib
+	}
+	if set["funding"] {
+		config.Funding = *funding
+	}
+	if set["commit-authors"] {
+		config.CommitAuthors = *authors
+	}
+	if set["pull-requests"] {
+		config.PullRequests = *pulls
+	}
+	if set["license"] {
+		config.License = *license
+	}
+	if set["security-policy"] {
+		config.SecurityPolicy = *secPolicy
+	}
+	if set["ci"] {
+		config.CI = *ci
+	}
+	if set["signed-commits"] {
+		config.SignedCommits = *signed
+	}
+	if set["include-repository"] {
+		config.IncludeRepository = *rawRepo
+	}
+	if *format == "openssf" {
+		// The upstream schema has repository signals such as the license and stars.
+		config.IncludeRepository = true
+	}
+	if set["since"] {
+		days, err := criticalityscore.ParseLookback(*since)
+		if err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+		config.IssueLookbackDays = days
+		if *sinceRel {
+			config.ReleaseLookbackDays = days
+		}
+	}
+	if set["issue-lookback-days"] {
+		config.IssueLookbackDays = *issueDays
+	}
+	if set["release-lookback-days"] {
+		config.ReleaseLookbackDays = *releaseDays
+	}
+
+	if err := criticalityscore.ValidateMetrics(config.Metrics); err != nil {
+		criticalityscore.PrintError(err, *format)
+		return
+	}
+	if err := criticalityscore.ValidateFields(config.Fields); err != nil {
+		criticalityscore.PrintError(err, *format)
+		return
+	}
+	if config.Tiers {
+		if err := criticalityscore.ValidateTierCutoffs(config.TierCutoffs); err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+	}
+	if *sortBy != "" {
+		if err := criticalityscore.ValidateSortKey(*sortBy); err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+	}
+
+	var previous []criticalityscore.Score
+	if *baseline != "" {
+		previous, err = criticalityscore.LoadBaseline(*baseline)
+		if err != nil {
+			criticalityscore.WriteError(os.Stderr, err, *format)
+			return
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if *listMetrics {
+		criticalityscore.WriteMetricDescriptors(out, config.MetricDescriptors(), *format)
+		return
+	}
+
+	if *doctor {
+		criticalityscore.PrintDoctor(criticalityscore.Doctor(token, config))
+		return
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *warm != "" {
+		var count int
+		if info, statErr := os.Stat(*warm); statErr == nil && info.Mode().IsRegular() {
+			var repoURLs []string
+			repoURLs, err = criticalityscore.LoadRepoList(*warm)
+			if err != nil {
+				criticalityscore.PrintError(err, *format)
+				return
+			}
+			count, err = criticalityscore.WarmCacheRepos(ctx, repoURLs, token, config)
+		} else {
+			count, err = criticalityscore.WarmCache(ctx, *warm, token, config)
+		}
+		if errors.Is(err, criticalityscore.ErrTimedOut) {
+			fmt.Printf("cached %d repos for %s\n", count, *warm)
+			exitTimedOut(err)
+		}
+		if err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+		fmt.Printf("cached %d repos for %s\n", count, *warm)
+		return
+	}
+
+	if *lockfile != "" {
+		dependencies, err := criticalityscore.LoadDependencies(*lockfile)
+		if err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+		if *metaOnly {
+			metadata, err := criticalityscore.DependencyMetadata(ctx, dependencies, token, config)
+			if err != nil && !errors.Is(err, criticalityscore.ErrTimedOut) {
+				criticalityscore.PrintError(err, *format)
+				return
+			}
+			if *format == "json" {
+				criticalityscore.WriteMetadataJSONArray(out, metadata)
+			} else {
+				for i, m := range metadata {
+					if i > 0 {
+						fmt.Fprintln(out)
+					}
+					criticalityscore.WriteMetadata(out, m, *format)
+				}
+			}
+			if err != nil {
+				exitTimedOut(err)
+			}
+			return
+		}
+		if *inputOrder {
+			var scores []criticalityscore.Score
+			table := criticalityscore.NewCSVTableWriter(out)
+			err := criticalityscore.StreamDependencyScores(ctx, dependencies, token, config, *params, func(score criticalityscore.Score) {
+				if set["min-score"] && score.CriticalityScore < *minScore {
+					return
+				}
+				scores = append(scores, score)
+				if *baseline != "" {
+					return
+				}
+				if *format == "csv-table" {
+					if err := table.Write(score); err != nil {
+						log.Println(err.Error())
+					}
+					return
+				}
+				if *format == "json" {
+					criticalityscore.WriteScoreJSONLine(out, score)
+					return
+				}
+				if len(scores) > 1 && *format != "openssf" {
+					fmt.Fprintln(out)
+				}
+				criticalityscore.WriteScore(out, score, *format)
+			})
+			if *outputDir != "" {
+				if _, err := criticalityscore.WriteScoreFiles(*outputDir, scores, *format); err != nil {
+					criticalityscore.PrintError(err, *format)
+					return
+				}
+			}
+			if *baseline != "" {
+				writeChanges(out, previous, scores, err != nil)
+			}
+			if err != nil {
+				exitTimedOut(err)
+			}
+			return
+		}
+		scores, err := criticalityscore.ScoreDependencies(ctx, dependencies, token, config, *params)
+		if err != nil && !errors.Is(err, criticalityscore.ErrTimedOut) {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+		if *baseline != "" {
+			writeChanges(out, previous, scores, err != nil)
+			if err != nil {
+				exitTimedOut(err)
+			}
+			return
+		}
+		if set["min-score"] {
+			scores = criticalityscore.FilterScores(scores, *minScore)
+		}
+		if *sortBy != "" {
+			criticalityscore.SortScores(scores, *sortBy)
+		}
+		if *top > 0 && len(scores) > *top {
+			scores = scores[:*top]
+		}
+		if *outputDir != "" {
+			if _, err := criticalityscore.WriteScoreFiles(*outputDir, scores, *format); err != nil {
+				criticalityscore.PrintError(err, *format)
+				return
+			}
+		}
+		criticalityscore.WriteScores(out, scores, *format)
+		if err != nil {
+			exitTimedOut(err)
+		}
+		return
+	}
+
+	if *reposFile != "" {
+		repoURLs, err := criticalityscore.LoadRepoList(*reposFile)
+		if err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+		var scores []criticalityscore.Score
+		err = criticalityscore.ScoreRepositories(ctx, repoURLs, token, config, *params, func(result criticalityscore.ScoreResult) {
+			if result.Err != nil {
+				log.Printf("%s: %s\n", result.RepoURL, result.Err.Error())
+				return
+			}
+			scores = append(scores, result.Score)
+		})
+		if *baseline != "" {
+			writeChanges(out, previous, scores, err != nil)
+			if err != nil {
+				exitTimedOut(err)
+			}
+			return
+		}
+		if set["min-score"] {
+			scores = criticalityscore.FilterScores(scores, *minScore)
+		}
+		if *sortBy != "" {
+			criticalityscore.SortScores(scores, *sortBy)
+		}
+		if *outputDir != "" {
+			if _, err := criticalityscore.WriteScoreFiles(*outputDir, scores, *format); err != nil {
+				criticalityscore.PrintError(err, *format)
+				return
+			}
+		}
+		if *format == "csv" {
+			criticalityscore.WriteScores(out, scores, "csv-table")
+		} else {
+			criticalityscore.WriteScores(out, scores, *format)
+		}
+		if err != nil {
+			exitTimedOut(err)
+		}
+		return
+	}
+
+	var repo criticalityscore.GitHubRepository
+	if *repoID != 0 {
+		repo, err = criticalityscore.LoadRepositoryByIDWithConfig(*repoID, token, config)
+	} else {
+		repo, err = criticalityscore.LoadRepositoryWithConfig(*repoURL, token, config)
+	}
+	if err != nil {
+		criticalityscore.PrintError(err, *format)
+		return
+	}
+
+	if *metaOnly {
+		if *array && *format == "json" {
+			criticalityscore.WriteMetadataJSONArray(out, []criticalityscore.RepositoryMetadata{repo.Metadata()})
+			return
+		}
+		criticalityscore.WriteMetadata(out, repo.Metadata(), *format)
 		return
 	}
 
 	score, err := criticalityscore.RepositoryStats(repo, *params)
 	if err != nil {
-		fmt.Println(err.Error())
+		criticalityscore.PrintError(err, *format)
+		return
+	}
+
+	if *outputDir != "" {
+		if _, err := criticalityscore.WriteScoreFiles(*outputDir, []criticalityscore.Score{score}, *format); err != nil {
+			criticalityscore.PrintError(err, *format)
+			return
+		}
+	}
+
+	if *baseline != "" {
+		writeChanges(out, previous, []criticalityscore.Score{score}, true)
 		return
 	}
 
-	criticalityscore.PrintScore(score, *format)
+	if *array && *format == "json" {
+		criticalityscore.WriteScores(out, []criticalityscore.Score{score}, "json")
+		return
+	}
+
+	criticalityscore.WriteScore(out, score, *format)
+
+	if *format == "github-summary" {
+		if summaryPath := os.Getenv("GITHUB_STEP_SUMMARY"); summaryPath != "" {
+			if err := criticalityscore.WriteStepSummary(summaryPath, score); err != nil {
+				fmt.Println(err.Error())
+			}
+		}
+	}
+
+	if score.BelowThreshold {
+		os.Exit(1)
+	}
+}
+
+// flagsSet returns the names of the flags given on the command line.
+func flagsSet(args []string) map[string]bool {
+	set := make(map[string]bool)
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return set
+	}
+	for _, element := range ctx.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			set[flag.Model().Name] = true
+		}
+	}
+	return set
+}
+
+// writeChanges writes the changes of the scored repositories since the baseline. For a
+// partial run, such as a single repository or a batch stopped by --timeout, repositories
+// that weren't scored aren't reported as removed.
+func writeChanges(w io.Writer, previous, scores []criticalityscore.Score, partial bool) {
+	if partial {
+		previous = criticalityscore.ScoredBaseline(previous, scores)
+	}
+	criticalityscore.WriteChanges(w, criticalityscore.CompareToBaseline(previous, scores, *threshold))
+}
+
+// exitTimedOut reports a batch run stopped by --timeout on stderr, after its partial
+// results, and exits with the status of timeout(1).
+func exitTimedOut(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(124)
+}
+
+// splitList returns the items of a comma-separated flag value, without blanks.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }